@@ -2,22 +2,53 @@ package opennebula
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
 )
 
 type Artifact struct {
-	ImageID    string
-	StateData  map[string]interface{}
-	builderID  string
-	Client     *goca.Client
-	Controller *goca.Controller
+	// ImageID is the comma separated list of ImageIDs.
+	ImageID           string
+	ImageIDs          []int
+	MarketplaceAppIDs []int
+	StateData         map[string]interface{}
+	// FrontendURL is the OpenNebula endpoint the images are registered on,
+	// reported with their datastore as the region in HCP Packer.
+	FrontendURL string
+	// SourceImageID is the image the build started from, reported as the
+	// parent of the images in HCP Packer.
+	SourceImageID string
+	builderID     string
+	files         []string
+	Client        *goca.Client
+	Controller    *goca.Controller
 }
 
 // Artifact implements packersdk.Artifact
 var _ packersdk.Artifact = &Artifact{}
 
+// NewArtifact returns the artifact of the images with the given IDs. The IDs
+// are also available as the "ImageIDs" state.
+func NewArtifact(builderID string, imageIDs []int, client *goca.Client, controller *goca.Controller) *Artifact {
+	ids := make([]string, len(imageIDs))
+	for i, id := range imageIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	return &Artifact{
+		ImageID:    strings.Join(ids, ","),
+		ImageIDs:   imageIDs,
+		StateData:  map[string]interface{}{"ImageIDs": imageIDs},
+		builderID:  builderID,
+		Client:     client,
+		Controller: controller,
+	}
+}
+
 // BuilderId returns the builder ID.
 func (a *Artifact) BuilderId() string {
 	return a.builderID
@@ -25,7 +56,7 @@ func (a *Artifact) BuilderId() string {
 
 // Files returns the files represented by the artifact.
 func (a *Artifact) Files() []string {
-	return nil
+	return a.files
 }
 
 func (a *Artifact) Id() string {
@@ -33,14 +64,55 @@ func (a *Artifact) Id() string {
 }
 
 func (a *Artifact) String() string {
+	if len(a.MarketplaceAppIDs) > 0 {
+		return fmt.Sprintf("An image was created: %v (Marketplace appliances: %v)", a.ImageID, a.MarketplaceAppIDs)
+	}
 	return fmt.Sprintf("An image was created: %v", a.ImageID)
 }
 
 // State returns specific details from the artifact.
 func (a *Artifact) State(name string) interface{} {
+	if name == registryimage.ArtifactStateURI {
+		return a.registryImages()
+	}
 	return a.StateData[name]
 }
 
+// registryImages returns the metadata of the images for HCP Packer.
+func (a *Artifact) registryImages() []*registryimage.Image {
+	frontend := strings.TrimSuffix(a.FrontendURL, "/")
+	if u, err := url.Parse(a.FrontendURL); err == nil && u.Host != "" {
+		frontend = u.Scheme + "://" + u.Host
+	}
+
+	images := make([]*registryimage.Image, 0, len(a.ImageIDs))
+	for _, id := range a.ImageIDs {
+		img := &registryimage.Image{
+			ImageID:       strconv.Itoa(id),
+			ProviderName:  "opennebula",
+			SourceImageID: a.SourceImageID,
+			Labels:        map[string]string{},
+		}
+		region := frontend
+		if a.Controller != nil {
+			if info, err := a.Controller.Image(id).Info(false); err == nil {
+				region = fmt.Sprintf("%s/%s", frontend, info.Datastore)
+				img.Labels["image_name"] = info.Name
+				img.Labels["datastore"] = info.Datastore
+				if info.DatastoreID != nil {
+					img.Labels["datastore_id"] = strconv.Itoa(*info.DatastoreID)
+				}
+				if labels, err := info.Template.GetStr("LABELS"); err == nil && labels != "" {
+					img.Labels["labels"] = labels
+				}
+			}
+		}
+		img.ProviderRegion = region
+		images = append(images, img)
+	}
+	return images
+}
+
 func (a *Artifact) Destroy() error {
 	return nil
 }
@@ -0,0 +1,82 @@
+package opennebula
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+)
+
+func TestArtifact_registryImages(t *testing.T) {
+	srv, config, _ := newTestState(t)
+	system := srv.AddImage("ubuntu-22.04-0", "OS", 1)
+	data := srv.AddImage("ubuntu-22.04-1", "DATABLOCK", 2)
+	if err := config.Controller.Image(system).Update(`LABELS="golden,ubuntu"`, parameters.Merge); err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := NewArtifact("opennebula.test", []int{system, data}, config.Client, config.Controller)
+	artifact.FrontendURL = srv.URL
+	artifact.SourceImageID = "7"
+	images, ok := artifact.State(registryimage.ArtifactStateURI).([]*registryimage.Image)
+	if !ok {
+		t.Fatalf("%s state is %T, expected the registry images", registryimage.ArtifactStateURI, artifact.State(registryimage.ArtifactStateURI))
+	}
+
+	// The region is the frontend, without the RPC2 path, and the datastore
+	frontend := strings.TrimSuffix(srv.URL, "/RPC2")
+	expected := []*registryimage.Image{
+		{
+			ImageID:        strconv.Itoa(system),
+			ProviderName:   "opennebula",
+			ProviderRegion: frontend + "/default",
+			SourceImageID:  "7",
+			Labels: map[string]string{
+				"image_name":   "ubuntu-22.04-0",
+				"datastore":    "default",
+				"datastore_id": "1",
+				"labels":       "golden,ubuntu",
+			},
+		},
+		{
+			ImageID:        strconv.Itoa(data),
+			ProviderName:   "opennebula",
+			ProviderRegion: frontend + "/files",
+			SourceImageID:  "7",
+			Labels: map[string]string{
+				"image_name":   "ubuntu-22.04-1",
+				"datastore":    "files",
+				"datastore_id": "2",
+			},
+		},
+	}
+	if len(images) != len(expected) {
+		t.Fatalf("%d registry images, expected %d", len(images), len(expected))
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(images[i], expected[i]) {
+			t.Errorf("registry image %d is %+v, expected %+v", i, images[i], expected[i])
+		}
+	}
+}
+
+// Images that cannot be read are reported with the frontend as region.
+func TestArtifact_registryImagesUnknown(t *testing.T) {
+	srv, config, _ := newTestState(t)
+	artifact := NewArtifact("opennebula.test", []int{42}, config.Client, config.Controller)
+	artifact.FrontendURL = srv.URL
+
+	images := artifact.State(registryimage.ArtifactStateURI).([]*registryimage.Image)
+	expected := &registryimage.Image{
+		ImageID:        "42",
+		ProviderName:   "opennebula",
+		ProviderRegion: strings.TrimSuffix(srv.URL, "/RPC2"),
+		Labels:         map[string]string{},
+	}
+	if len(images) != 1 || !reflect.DeepEqual(images[0], expected) {
+		t.Errorf("registry images %+v, expected %+v", images, expected)
+	}
+}
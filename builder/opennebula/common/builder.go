@@ -4,18 +4,34 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
+// GeneratedDataKeys are the build variables the builders expose to
+// provisioners and post-processors, e.g. {{ build `VMIP` }}.
+var GeneratedDataKeys = []string{
+	"OpenNebulaVMID",
+	"OpenNebulaHost",
+	"SourceImageID",
+	"SourceImageName",
+	"VMIP",
+	"ClusterID",
+}
+
 type Builder struct {
 	BuilderID string
 	config    Config
-	PreSteps  []multistep.Step
-	runner    multistep.Runner
+	// SourceSteps run first and prepare what the build VM is created from,
+	// e.g. images in addition to the configured ones.
+	SourceSteps []multistep.Step
+	PreSteps    []multistep.Step
+	runner      multistep.Runner
 }
 
 // NewSharedBuilder creates a new shared builder for OpenNebula.
@@ -45,19 +61,35 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	state.Put("debug", b.config.Debug)
 	state.Put("config", &b.config)
 	state.Put("hook", hook)
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
 
 	steps := []multistep.Step{}
 
+	var serialPort int
+	var serialListen string
+	if b.config.BootConsole == "serial" {
+		serialPort = b.config.SerialPort
+		serialListen = b.config.SerialListen
+	}
+
 	// Define execution steps.
 	PreCommonSteps := []multistep.Step{
 		&StepProcessImages{
 			Images: b.config.ImageConfigs,
 		},
+		// The HTTP server runs first so that the kernel command line can
+		// reference it
+		commonsteps.HTTPServerFromHTTPConfig(&b.config.HTTPConfig),
 		&StepCreateVM{
 			VMTemplateConfig:  b.config.VMTemplateConfig,
 			OpenNebulaConnect: b.config.OpenNebulaConnect,
+			KeepVM:            b.config.KeepVM,
+			OnError:           b.config.PackerOnError,
+			VNCPassword:       b.config.VNCPassword,
+			SerialPort:        serialPort,
+			SerialListen:      serialListen,
+			GeneratedData:     generatedData,
 		},
-		commonsteps.HTTPServerFromHTTPConfig(&b.config.HTTPConfig),
 	}
 
 	PostCommonSteps := []multistep.Step{
@@ -73,22 +105,33 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Comm: &b.config.Comm,
 		},
 		&StepPowerOffVM{
-			ShutdownMethod: "poweroff",
+			ShutdownMethods: b.config.ShutdownMethods,
+			ShutdownCommand: b.config.ShutdownCommand,
+			ShutdownTimeout: b.config.ShutdownTimeout,
 		},
 		&StepCloneDisk{},
+		&StepPublishMarketplaceApp{
+			Publish: b.config.MarketplacePublishConfig,
+		},
+		&StepExportImages{
+			Export: b.config.ExportConfig,
+		},
+		&StepRotateImages{
+			Family:   b.config.SnapshotConfig.Snapshot_Family,
+			KeepLast: b.config.SnapshotConfig.Snapshot_KeepLast,
+			DryRun:   b.config.SnapshotConfig.Snapshot_RotationDryRun,
+		},
+		// Last, as the build user may lose access to the images it hands over
+		&StepSetOutputPermissions{
+			Output: b.config.OutputConfig,
+		},
 		// Other steps to create an ISO image
 	}
+	steps = append(steps, b.SourceSteps...)
 	steps = append(steps, PreCommonSteps...)
 	steps = append(steps, b.PreSteps...)
 	steps = append(steps, PostCommonSteps...)
 
-	artifact := Artifact{
-		ImageID:    "",
-		StateData:  map[string]interface{}{},
-		Client:     client,
-		Controller: controller,
-	}
-
 	// Configure the runner and run the steps.
 	b.runner = commonsteps.NewRunnerWithPauseFn(steps, b.config.PackerConfig, ui, state)
 	b.runner.Run(ctx, state)
@@ -110,6 +153,28 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		return nil, errors.New("Build was halted.")
 	}
 
+	var imageIDs []int
+	if ids, ok := state.GetOk("ClonedDiskIDs"); ok {
+		imageIDs = ids.([]int)
+	}
+	artifact := NewArtifact(b.BuilderID, imageIDs, client, controller)
+	if appIDs, ok := state.GetOk("MarketplaceAppIDs"); ok {
+		artifact.MarketplaceAppIDs = appIDs.([]int)
+	}
+	if files, ok := state.GetOk("ExportedFiles"); ok {
+		artifact.files = files.([]string)
+	}
+	artifact.FrontendURL = b.config.OpenNebulaURL
+	if boot, ok := state.GetOk("PostInstallBoot"); ok {
+		artifact.StateData["PostInstallBoot"] = boot
+	}
+	if generated, ok := state.Get("generated_data").(map[string]interface{}); ok {
+		artifact.StateData["generated_data"] = generated
+		if sourceID, ok := generated["SourceImageID"].(int); ok {
+			artifact.SourceImageID = strconv.Itoa(sourceID)
+		}
+	}
+
 	ui.Say("[Info] OpenNebula Packer Build completed successfully.")
-	return &artifact, nil
+	return artifact, nil
 }
@@ -0,0 +1,209 @@
+package opennebula
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// stepGuestPoweroff stands for an installation that powers off the guest.
+type stepGuestPoweroff struct {
+	srv *fakeone.Server
+}
+
+func (s *stepGuestPoweroff) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	s.srv.SetVMState(state.Get("vmID").(int), vm.Poweroff, vm.LcmInit)
+	return multistep.ActionContinue
+}
+
+func (s *stepGuestPoweroff) Cleanup(state multistep.StateBag) {}
+
+// testBuildConfig returns the configuration of a build installing from an
+// ISO to a blank disk.
+func testBuildConfig(t *testing.T, srv *fakeone.Server) Config {
+	config := *testConfig(t, srv)
+	config.Comm.Type = "none"
+	config.VMTemplateConfig.NICs = []NICConfig{{Network: "public"}}
+	config.ImageConfigs = []ImageConfig{
+		{Image_Name: "packer-disk", Image_Type: "DATABLOCK", Image_Size: 10240, Image_DatastoreID: 1},
+		{Image_Name: "packer-installer", Image_Type: "CDROM", Image_Path: "https://example.com/installer.iso", Image_DatastoreID: 1},
+	}
+	config.EjectISO = true
+	config.SnapshotConfig.Snapshot_Name = "ubuntu-22.04"
+	return config
+}
+
+// checkCleanedUp fails unless all the VMs are terminated and only the images
+// in kept are left.
+func checkCleanedUp(t *testing.T, srv *fakeone.Server, kept ...int) {
+	t.Helper()
+	for _, v := range srv.VMs() {
+		if v.State != vm.Done {
+			t.Errorf("VM %d is %s, expected DONE", v.ID, v.State)
+		}
+	}
+	var left []int
+	for _, img := range srv.Images() {
+		left = append(left, img.ID)
+	}
+	if !reflect.DeepEqual(left, kept) {
+		t.Errorf("images %v left, expected %v", left, kept)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	srv := fakeone.New(t)
+	b := NewSharedBuilder("opennebula.test", testBuildConfig(t, srv), []multistep.Step{
+		&stepGuestPoweroff{srv: srv},
+		&StepDetachISO{},
+		&StepStartVM{},
+	})
+
+	artifact, err := b.Run(context.Background(), testUi(t), &packersdk.MockHook{})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+
+	images := srv.Images()
+	if len(images) != 1 {
+		t.Fatalf("%d images left, expected the saved disk only", len(images))
+	}
+	saved := images[0]
+	if saved.Name != "ubuntu-22.04" || saved.Type != "DATABLOCK" || saved.State != image.Ready {
+		t.Errorf("saved image %q is %s %s, expected the READY DATABLOCK ubuntu-22.04", saved.Name, saved.Type, saved.State)
+	}
+	if artifact.Id() != strconv.Itoa(saved.ID) {
+		t.Errorf("artifact ID = %q, expected %d", artifact.Id(), saved.ID)
+	}
+	if generated, ok := artifact.State("generated_data").(map[string]interface{}); !ok || generated["VMIP"] != "10.0.0.2" {
+		t.Errorf("generated data %v, expected the IP of the VM", artifact.State("generated_data"))
+	}
+	checkCleanedUp(t, srv, saved.ID)
+}
+
+func TestBuilder_saveasError(t *testing.T) {
+	srv := fakeone.New(t)
+	srv.FailAlways("one.vm.disksaveas", "not enough space in the datastore")
+	b := NewSharedBuilder("opennebula.test", testBuildConfig(t, srv), []multistep.Step{
+		&stepGuestPoweroff{srv: srv},
+		&StepDetachISO{},
+		&StepStartVM{},
+	})
+
+	if _, err := b.Run(context.Background(), testUi(t), &packersdk.MockHook{}); err == nil {
+		t.Fatal("Run succeeded, expected the build to fail")
+	}
+	checkCleanedUp(t, srv)
+}
+
+func TestBuilder_kernel(t *testing.T) {
+	srv := fakeone.New(t)
+	config := testBuildConfig(t, srv)
+	config.ImageConfigs = config.ImageConfigs[:1]
+	config.EjectISO = false
+	config.VMTemplateConfig.OSArch = "x86_64"
+
+	b := NewSharedBuilder("opennebula.test", config, []multistep.Step{
+		&stepGuestPoweroff{srv: srv},
+		&StepWaitForInstall{},
+		&StepUpdateBootOrder{Boot: "disk0", DropKernel: true},
+		&StepStartVM{},
+	})
+	b.SourceSteps = []multistep.Step{
+		&StepPrepareKernel{KernelPath: "/boot/vmlinuz", InitrdPath: "/boot/initrd.img", DatastoreID: 2},
+	}
+
+	artifact, err := b.Run(context.Background(), testUi(t), &packersdk.MockHook{})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if boot := artifact.State("PostInstallBoot"); boot != "disk0" {
+		t.Errorf("artifact has boot order %v, expected disk0", boot)
+	}
+
+	vms := srv.VMs()
+	if len(vms) != 1 {
+		t.Fatalf("%d VMs created", len(vms))
+	}
+	for _, key := range []string{"KERNEL_DS", "INITRD_DS"} {
+		if value, err := vms[0].Template.GetStrFromVec("OS", key); err == nil {
+			t.Errorf("OS/%s = %q left after the installation", key, value)
+		}
+	}
+
+	// The uploaded kernel and initrd are deleted with the build disk
+	images := srv.Images()
+	if len(images) != 1 || images[0].Name != "ubuntu-22.04" {
+		t.Errorf("images %v left, expected the saved disk only", images)
+	}
+}
+
+func TestBuilder_keepVMOnFailure(t *testing.T) {
+	srv := fakeone.New(t)
+	srv.FailAlways("one.vm.disksaveas", "not enough space in the datastore")
+	config := testBuildConfig(t, srv)
+	config.KeepVM = "on_failure"
+	b := NewSharedBuilder("opennebula.test", config, []multistep.Step{
+		&stepGuestPoweroff{srv: srv},
+		&StepDetachISO{},
+		&StepStartVM{},
+	})
+
+	if _, err := b.Run(context.Background(), testUi(t), &packersdk.MockHook{}); err == nil {
+		t.Fatal("Run succeeded, expected the build to fail")
+	}
+
+	vms := srv.VMs()
+	if len(vms) != 1 {
+		t.Fatalf("%d VMs created", len(vms))
+	}
+	if vms[0].State == vm.Done {
+		t.Error("VM terminated, expected it to be kept")
+	}
+	if labels, _ := vms[0].UserTemplate.GetStr("LABELS"); labels != "packer/kept" {
+		t.Errorf("LABELS = %q, expected packer/kept", labels)
+	}
+	// The disks of the kept VM are kept with it
+	if images := srv.Images(); len(images) != 2 {
+		t.Errorf("%d images left, expected the disks of the kept VM", len(images))
+	}
+}
+
+func TestBuilder_outputPermissionsLast(t *testing.T) {
+	srv := fakeone.New(t)
+	config := testBuildConfig(t, srv)
+	config.OutputConfig = OutputConfig{OutputOwner: "2", OutputPermissions: "600"}
+	config.MarketplacePublishConfig = MarketplacePublishConfig{Publish_Marketplace: "1"}
+	b := NewSharedBuilder("opennebula.test", config, []multistep.Step{
+		&stepGuestPoweroff{srv: srv},
+		&StepDetachISO{},
+		&StepStartVM{},
+	})
+
+	if _, err := b.Run(context.Background(), testUi(t), &packersdk.MockHook{}); err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+
+	// The images are handed over once published
+	published := false
+	for _, c := range srv.Calls("") {
+		switch c.Method {
+		case "one.marketapp.allocate":
+			published = true
+		case "one.image.chown", "one.image.chmod":
+			if !published {
+				t.Fatalf("%s called before the image was published", c.Method)
+			}
+		}
+	}
+	if !published {
+		t.Error("image not published")
+	}
+}
@@ -2,6 +2,7 @@ package opennebula
 
 import (
 	"crypto/tls"
+	"errors"
 	"log"
 	"net/http"
 
@@ -17,6 +18,20 @@ type OpenNebulaConnect struct {
 	Controller    *goca.Controller `mapstructure-to-hcl2:",skip"`
 }
 
+func (c *OpenNebulaConnect) Prepare() []error {
+	var errs []error
+	if c.OpenNebulaURL == "" {
+		errs = append(errs, errors.New("OpenNebulaURL must be specified"))
+	}
+	if c.Username == "" {
+		errs = append(errs, errors.New("Username must be specified"))
+	}
+	if c.Password == "" {
+		errs = append(errs, errors.New("Password must be specified"))
+	}
+	return errs
+}
+
 func NewOpenNebulaConnect(OpenNebulaURL, Username, Password string, Insecure bool) (*goca.Client, *goca.Controller, error) {
 	log.Print("NewOpenNebulaConnect is starting....")
 
@@ -35,3 +50,11 @@ func NewOpenNebulaConnect(OpenNebulaURL, Username, Password string, Insecure boo
 	log.Print("Connected to OpenNebula versio: ", versionOpenNebula)
 	return client, controller, nil
 }
+
+// newHTTPClient returns a client for the HTTP servers of the frontend, which
+// skips the TLS verification like the OpenNebula client when insecure is set.
+func newHTTPClient(insecure bool) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	return &http.Client{Transport: tr}
+}
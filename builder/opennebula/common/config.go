@@ -1,10 +1,13 @@
 //go:generate packer-sdc struct-markdown
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config,ImageConfig,NICConfig,SnapshotConfig
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,ExportConfig,ImageConfig,MarketplaceAppConfig,MarketplacePublishConfig,NICConfig,SnapshotConfig
 package opennebula
 
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
@@ -12,29 +15,58 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/shutdowncommand"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 	"github.com/hashicorp/packer-plugin-sdk/uuid"
 )
 
 type Config struct {
-	common.PackerConfig    `mapstructure:",squash"`
-	commonsteps.HTTPConfig `mapstructure:",squash"`
-	Global                 `mapstructure:",squash"`
-	OpenNebulaConnect      `mapstructure:",squash"`
-	VMTemplateConfig       VMTemplateConfig `mapstructure:",squash"`
-	StepVNCBootCommand     `mapstructure:",squash"`
-	Comm                   communicator.Config `mapstructure:",squash"`
-	Ctx                    interpolate.Context `mapstructure-to-hcl2:",skip"`
+	common.PackerConfig            `mapstructure:",squash"`
+	commonsteps.HTTPConfig         `mapstructure:",squash"`
+	Global                         `mapstructure:",squash"`
+	OpenNebulaConnect              `mapstructure:",squash"`
+	ExportConfig                   `mapstructure:",squash"`
+	shutdowncommand.ShutdownConfig `mapstructure:",squash"`
+	VMTemplateConfig               VMTemplateConfig `mapstructure:",squash"`
+	StepVNCBootCommand             `mapstructure:",squash"`
+	Comm                           communicator.Config `mapstructure:",squash"`
+	Ctx                            interpolate.Context `mapstructure-to-hcl2:",skip"`
 }
 
 type Global struct {
-	Datastore      string         `mapstructure:"datastore"` //  required:"true"`
-	Debug          bool           `mapstructure:"debug"`
-	EjectISO       bool           `mapstructure:"eject_iso"`
-	EjectISODelay  time.Duration  `mapstructure:"eject_iso_delay"`
+	Datastore     string        `mapstructure:"datastore"` //  required:"true"`
+	Debug         bool          `mapstructure:"debug"`
+	EjectISO      bool          `mapstructure:"eject_iso"`
+	EjectISODelay time.Duration `mapstructure:"eject_iso_delay"`
+	// Names or IDs of CDROM images that stay attached when the ISO is
+	// ejected, e.g. a drivers ISO.
+	EjectISOKeep []string `mapstructure:"eject_iso_keep"`
+	// Delete the ejected ISO images created by the build once they are
+	// detached. Existing images the build only attached are kept.
+	EjectISODelete bool           `mapstructure:"eject_iso_delete"`
 	SnapshotConfig SnapshotConfig `mapstructure:"snapshot"`
 	ImageConfigs   []ImageConfig  `mapstructure:"image"`
+	// Methods tried in order to power off the VM after provisioning, each
+	// one when the previous did not power off the VM within
+	// `shutdown_timeout`: `command` runs `shutdown_command` in the guest,
+	// `poweroff` sends an ACPI shutdown and `poweroff_hard` powers the VM
+	// off immediately. Defaults to `["command", "poweroff", "poweroff_hard"]`,
+	// without `command` if `shutdown_command` is not set.
+	ShutdownMethods []string `mapstructure:"shutdown_methods"`
+	// Whether the build VM is kept instead of terminated during cleanup:
+	// `always`, `on_failure` or `never` (default). A kept VM is labelled
+	// `packer/kept` and left in its current state. Failed builds run with
+	// `-on-error=abort` always keep the VM.
+	KeepVM string `mapstructure:"keep_vm"`
+	// Ownership and permissions of the saved images.
+	OutputConfig `mapstructure:",squash"`
+	// Publish the saved images as Marketplace appliances.
+	MarketplacePublishConfig MarketplacePublishConfig `mapstructure:"marketplace_publish"`
+	// ID of the VM whose disks the `opennebula-vm` builder copies to boot the
+	// build VM from, required by that builder. The VM keeps running while its
+	// disks are copied.
+	SourceVMID *int `mapstructure:"source_vm_id"`
 }
 
 type VMTemplateConfig struct {
@@ -52,8 +84,37 @@ type VMTemplateConfig struct {
 	NICs           []NICConfig `mapstructure:"vm_nics"` // Используется массив для сетевых интерфейсов
 	OSArch         string      `mapstructure:"vm_os_arch"`
 	OSBoot         string      `mapstructure:"vm_os_boot"`
-	VCPU           int         `mapstructure:"vm_vcpu"`
-	UserData       string      `mapstructure:"vm_user_data"`
+	// Boot order applied once the OS is installed and the VM powered off,
+	// before it is powered on again, e.g. `disk0`. It is also set on the
+	// templates the `opennebula-template` and `opennebula-import`
+	// post-processors produce from the artifact.
+	PostInstallBoot string `mapstructure:"post_install_boot"`
+	VCPU            int    `mapstructure:"vm_vcpu"`
+	UserData        string `mapstructure:"vm_user_data"`
+	// Name or ID of the KERNEL image the `opennebula-kernel` builder boots
+	// directly, instead of booting from a disk.
+	OSKernel string `mapstructure:"vm_os_kernel"`
+	// Path or URL of a kernel uploaded to `vm_os_kernel_datastore_id` as a
+	// KERNEL image, instead of `vm_os_kernel`.
+	OSKernelPath string `mapstructure:"vm_os_kernel_path"`
+	// Name or ID of the RAMDISK image booted with the kernel.
+	OSInitrd string `mapstructure:"vm_os_initrd"`
+	// Path or URL of an initrd uploaded to `vm_os_kernel_datastore_id` as a
+	// RAMDISK image, instead of `vm_os_initrd`.
+	OSInitrdPath string `mapstructure:"vm_os_initrd_path"`
+	// ID of the kernel (FILE) datastore the kernel and initrd are uploaded to.
+	OSKernelDatastoreID int `mapstructure:"vm_os_kernel_datastore_id"`
+	// Kernel command line, e.g. an autoinstall seed such as
+	// `ds=nocloud-net;s=http://{{ .HTTPIP }}:{{ .HTTPPort }}/`.
+	OSKernelCmd string `mapstructure:"vm_os_kernel_cmd"`
+	// How long the unattended installation may take before it powers the VM
+	// off, also when waiting to apply `post_install_boot`. Defaults to `1h`.
+	OSInstallTimeout time.Duration `mapstructure:"vm_os_install_timeout"`
+}
+
+// HasKernel reports whether the VM boots a kernel directly.
+func (c *VMTemplateConfig) HasKernel() bool {
+	return c.OSKernel != "" || c.OSKernelPath != ""
 }
 
 // ImageConfig holds the configuration settings for the image
@@ -74,6 +135,28 @@ type ImageConfig struct {
 	Image_Size           int      `mapstructure:"size"`
 	Image_CloneFromImage string   `mapstructure:"clone_from_image"`
 	Image_Tags           []string `mapstructure:"tags"`
+	// Keep the image in the datastore after the build instead of deleting
+	// it during cleanup. Only applies to images created by the build.
+	Image_Keep bool `mapstructure:"keep"`
+	// Export the image from an OpenNebula Marketplace appliance into
+	// `datastore_id` instead of creating it from `path`.
+	Image_MarketplaceApp MarketplaceAppConfig `mapstructure:"marketplace_app"`
+}
+
+// MarketplaceAppConfig selects the Marketplace appliance an image is exported
+// from, either by its ID or by its name and marketplace.
+type MarketplaceAppConfig struct {
+	// ID of the Marketplace appliance.
+	App_ID int `mapstructure:"id"`
+	// Name of the Marketplace appliance. Requires `marketplace`.
+	App_Name string `mapstructure:"name"`
+	// Name or ID of the marketplace holding the appliance named `name`.
+	App_Marketplace string `mapstructure:"marketplace"`
+}
+
+// IsSet reports whether a Marketplace appliance has been configured.
+func (c *MarketplaceAppConfig) IsSet() bool {
+	return c.App_ID != 0 || c.App_Name != ""
 }
 
 type NICConfig struct {
@@ -83,6 +166,61 @@ type NICConfig struct {
 type SnapshotConfig struct {
 	Snapshot_Name        string `mapstructure:"name"`
 	Snapshot_DatastoreID int    `mapstructure:"datastore_id"`
+	// Image family the saved images belong to. It is stored in the `FAMILY`
+	// attribute of every saved image.
+	Snapshot_Family string `mapstructure:"family"`
+	// Number of images of the family to keep. After a successful build the
+	// older images that are not used by any VM or VM template are deleted,
+	// whoever owns them, as long as the user is allowed to see them.
+	// Requires `family`. 0 disables the rotation.
+	Snapshot_KeepLast int `mapstructure:"keep_last"`
+	// Only log the images the rotation would delete.
+	Snapshot_RotationDryRun bool `mapstructure:"rotation_dry_run"`
+}
+
+// ExportConfig holds the settings used to download the saved images to the
+// machine running Packer.
+type ExportConfig struct {
+	// Directory the build writes local files to. Defaults to
+	// `output-<build name>`.
+	OutputDir string `mapstructure:"output_directory"`
+	// Download the saved images into `output_directory`.
+	Export bool `mapstructure:"export"`
+	// Where the images are downloaded from: `marketplace` uses the
+	// appliances created by `marketplace_publish`, `http` uses `export_url`.
+	// Defaults to `marketplace` when `marketplace_publish` is set and to
+	// `http` otherwise.
+	ExportSource string `mapstructure:"export_source"`
+	// URL of a saved image, e.g. served by a web server on the frontend.
+	// `{{ .ImageID }}`, `{{ .Name }}`, `{{ .Source }}` and `{{ .Format }}`
+	// are replaced with the attributes of the image.
+	ExportURL string `mapstructure:"export_url"`
+	// URL of a file holding the SHA256 checksum of the image downloaded from
+	// `export_url`. Accepts the same variables as `export_url`. Without it
+	// the checksum of the download is written next to the image, to
+	// `<file>.sha256`.
+	ExportChecksumURL string `mapstructure:"export_checksum_url"`
+}
+
+// MarketplacePublishConfig holds the settings used to create a Marketplace
+// appliance from every image saved by the build.
+type MarketplacePublishConfig struct {
+	// Name or ID of the marketplace the appliances are created in.
+	Publish_Marketplace string `mapstructure:"marketplace"`
+	// Name of the appliance. Defaults to `snapshot.name`. When several
+	// images are saved, the disk index is appended to the name.
+	Publish_Name        string `mapstructure:"name"`
+	Publish_Version     string `mapstructure:"version"`
+	Publish_Description string `mapstructure:"description"`
+	Publish_Publisher   string `mapstructure:"publisher"`
+	// Attributes of the image template used when the appliance is exported,
+	// e.g. `DEV_PREFIX` or `DRIVER`.
+	Publish_AppTemplate map[string]string `mapstructure:"app_template"`
+}
+
+// IsSet reports whether publishing to a marketplace has been configured.
+func (c *MarketplacePublishConfig) IsSet() bool {
+	return c.Publish_Marketplace != ""
 }
 
 func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
@@ -94,7 +232,10 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 			Exclude: []string{
 				"boot_command",
 				"boot_steps",
+				"export_url",
+				"export_checksum_url",
 				"qemuargs",
+				"vm_os_kernel_cmd",
 			},
 		},
 	}, raws...)
@@ -108,15 +249,173 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	errs = packersdk.MultiErrorAppend(errs, c.Comm.Prepare(&c.Ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, c.VNCConfig.Prepare(&c.Ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, c.HTTPConfig.Prepare(&c.Ctx)...)
+	errs = packersdk.MultiErrorAppend(errs, c.ShutdownConfig.Prepare(&c.Ctx)...)
+
+	errs = packersdk.MultiErrorAppend(errs, c.OpenNebulaConnect.Prepare()...)
+
+	for i, img := range c.ImageConfigs {
+		app := img.Image_MarketplaceApp
+		if !app.IsSet() {
+			continue
+		}
+		if app.App_ID != 0 && app.App_Name != "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("image %d: only one of marketplace_app.id or marketplace_app.name can be specified", i))
+		}
+		if app.App_Name != "" && app.App_Marketplace == "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("image %d: marketplace_app.marketplace must be specified together with marketplace_app.name", i))
+		}
+		if img.Image_Path != "" || img.Image_CloneFromImage != "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("image %d: marketplace_app cannot be combined with path or clone_from_image", i))
+		}
+	}
+
+	errs = packersdk.MultiErrorAppend(errs, c.OutputConfig.Prepare()...)
+
+	if len(c.ShutdownMethods) == 0 {
+		if c.ShutdownCommand != "" {
+			c.ShutdownMethods = append(c.ShutdownMethods, "command")
+		}
+		c.ShutdownMethods = append(c.ShutdownMethods, "poweroff", "poweroff_hard")
+	}
+	for _, method := range c.ShutdownMethods {
+		switch method {
+		case "command":
+			if c.ShutdownCommand == "" {
+				errs = packersdk.MultiErrorAppend(errs, errors.New("shutdown method \"command\" requires shutdown_command"))
+			}
+		case "poweroff", "poweroff_hard":
+		default:
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid shutdown method %q, expected \"command\", \"poweroff\" or \"poweroff_hard\"", method))
+		}
+	}
+
+	switch c.VNCProxy {
+	case "":
+	case "sunstone", "fireedge":
+		if c.VNCProxyURL == "" {
+			endpoint, err := url.Parse(c.OpenNebulaURL)
+			if err != nil {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("vnc_proxy_url cannot be derived from opennebula_url: %s", err))
+				break
+			}
+			if c.VNCProxy == "sunstone" {
+				c.VNCProxyURL = fmt.Sprintf("%s://%s", endpoint.Scheme, net.JoinHostPort(endpoint.Hostname(), "9869"))
+			} else {
+				c.VNCProxyURL = fmt.Sprintf("%s://%s/fireedge", endpoint.Scheme, net.JoinHostPort(endpoint.Hostname(), "2616"))
+			}
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid vnc_proxy %q, expected \"sunstone\" or \"fireedge\"", c.VNCProxy))
+	}
+
+	// The console is reached through the SSH bastion unless it goes through
+	// the proxy
+	if c.VNCBastionHost == "" && c.VNCProxy == "" && c.Comm.SSHBastionHost != "" {
+		c.VNCBastionHost = c.Comm.SSHBastionHost
+		if c.VNCBastionPort == 0 {
+			c.VNCBastionPort = c.Comm.SSHBastionPort
+		}
+	}
+	if c.VNCBastionHost != "" {
+		if c.VNCProxy != "" {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("vnc_bastion_host cannot be combined with vnc_proxy"))
+		}
+		if c.VNCBastionPort == 0 {
+			c.VNCBastionPort = 22
+		}
+		if c.VNCBastionUsername == "" {
+			c.VNCBastionUsername = c.Comm.SSHBastionUsername
+		}
+		if c.VNCBastionPassword == "" && c.VNCBastionPrivateKeyFile == "" && !c.VNCBastionAgentAuth {
+			c.VNCBastionPassword = c.Comm.SSHBastionPassword
+			c.VNCBastionPrivateKeyFile = c.Comm.SSHBastionPrivateKeyFile
+			c.VNCBastionAgentAuth = c.Comm.SSHBastionAgentAuth
+		}
+		if c.VNCBastionPassword == "" && c.VNCBastionPrivateKeyFile == "" && !c.VNCBastionAgentAuth {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("vnc_bastion_host requires vnc_bastion_password, vnc_bastion_private_key_file or vnc_bastion_agent_auth"))
+		}
+	}
+
+	vmc := &c.VMTemplateConfig
+	if vmc.OSKernel != "" && vmc.OSKernelPath != "" {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("only one of vm_os_kernel or vm_os_kernel_path can be specified"))
+	}
+	if vmc.OSInitrd != "" && vmc.OSInitrdPath != "" {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("only one of vm_os_initrd or vm_os_initrd_path can be specified"))
+	}
+	if (vmc.OSInitrd != "" || vmc.OSInitrdPath != "" || vmc.OSKernelCmd != "") && !vmc.HasKernel() {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("vm_os_initrd, vm_os_initrd_path and vm_os_kernel_cmd require vm_os_kernel or vm_os_kernel_path"))
+	}
+	if (vmc.OSKernelPath != "" || vmc.OSInitrdPath != "") && vmc.OSKernelDatastoreID == 0 {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("vm_os_kernel_datastore_id must be specified to upload vm_os_kernel_path or vm_os_initrd_path"))
+	}
+	if vmc.OSInstallTimeout == 0 {
+		vmc.OSInstallTimeout = time.Hour
+	}
+
+	switch c.KeepVM {
+	case "":
+		c.KeepVM = "never"
+	case "always", "on_failure", "never":
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid keep_vm %q, expected \"always\", \"on_failure\" or \"never\"", c.KeepVM))
+	}
 
-	if c.OpenNebulaURL == "" {
-		errs = packersdk.MultiErrorAppend(errs, errors.New("OpenNebulaURL must be specified"))
+	if c.OutputDir == "" {
+		c.OutputDir = fmt.Sprintf("output-%s", c.PackerBuildName)
 	}
-	if c.Username == "" {
-		errs = packersdk.MultiErrorAppend(errs, errors.New("Username must be specified"))
+	switch c.BootConsole {
+	case "":
+		c.BootConsole = "vnc"
+	case "vnc":
+	case "serial":
+		if c.SerialPort == 0 {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("serial_port must be specified when boot_console is \"serial\""))
+		}
+		if c.SerialListen == "" {
+			c.SerialListen = "0.0.0.0"
+		} else if net.ParseIP(c.SerialListen) == nil {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid serial_listen %q, expected an IP address", c.SerialListen))
+		}
+		if c.SerialLogFile == "" {
+			c.SerialLogFile = filepath.Join(c.OutputDir, "serial-console.log")
+		}
+		if usesScreenWait(c.BootSteps) {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("screen waits in boot_steps require boot_console = \"vnc\""))
+		}
+		if c.VNCProxy != "" {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("vnc_proxy cannot be used with boot_console = \"serial\""))
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid boot_console %q, expected \"vnc\" or \"serial\"", c.BootConsole))
+	}
+
+	if c.Export {
+		if c.ExportSource == "" {
+			c.ExportSource = "http"
+			if c.MarketplacePublishConfig.IsSet() {
+				c.ExportSource = "marketplace"
+			}
+		}
+		switch c.ExportSource {
+		case "marketplace":
+			if !c.MarketplacePublishConfig.IsSet() {
+				errs = packersdk.MultiErrorAppend(errs, errors.New("export_source = \"marketplace\" requires marketplace_publish"))
+			}
+		case "http":
+			if c.ExportURL == "" {
+				errs = packersdk.MultiErrorAppend(errs, errors.New("export_url must be specified when export_source is \"http\""))
+			}
+		default:
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid export_source %q, expected \"marketplace\" or \"http\"", c.ExportSource))
+		}
+	}
+
+	if c.SnapshotConfig.Snapshot_KeepLast < 0 {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("snapshot.keep_last must not be negative"))
 	}
-	if c.Password == "" {
-		errs = packersdk.MultiErrorAppend(errs, errors.New("Password must be specified"))
+	if c.SnapshotConfig.Snapshot_KeepLast > 0 && c.SnapshotConfig.Snapshot_Family == "" {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("snapshot.family must be specified together with snapshot.keep_last"))
 	}
 
 	// Установка значений по умолчанию
@@ -10,104 +10,145 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName           *string             `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType         *string             `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion         *string             `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug               *bool               `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce               *bool               `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError             *string             `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars            map[string]string   `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars       []string            `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	HTTPDir                   *string             `mapstructure:"http_directory" cty:"http_directory" hcl:"http_directory"`
-	HTTPContent               map[string]string   `mapstructure:"http_content" cty:"http_content" hcl:"http_content"`
-	HTTPPortMin               *int                `mapstructure:"http_port_min" cty:"http_port_min" hcl:"http_port_min"`
-	HTTPPortMax               *int                `mapstructure:"http_port_max" cty:"http_port_max" hcl:"http_port_max"`
-	HTTPAddress               *string             `mapstructure:"http_bind_address" cty:"http_bind_address" hcl:"http_bind_address"`
-	HTTPInterface             *string             `mapstructure:"http_interface" undocumented:"true" cty:"http_interface" hcl:"http_interface"`
-	Datastore                 *string             `mapstructure:"datastore" cty:"datastore" hcl:"datastore"`
-	Debug                     *bool               `mapstructure:"debug" cty:"debug" hcl:"debug"`
-	EjectISO                  *bool               `mapstructure:"eject_iso" cty:"eject_iso" hcl:"eject_iso"`
-	EjectISODelay             *string             `mapstructure:"eject_iso_delay" cty:"eject_iso_delay" hcl:"eject_iso_delay"`
-	SnapshotConfig            *FlatSnapshotConfig `mapstructure:"snapshot" cty:"snapshot" hcl:"snapshot"`
-	ImageConfigs              []FlatImageConfig   `mapstructure:"image" cty:"image" hcl:"image"`
-	OpenNebulaURL             *string             `mapstructure:"opennebula_url" cty:"opennebula_url" hcl:"opennebula_url"`
-	Username                  *string             `mapstructure:"username" cty:"username" hcl:"username"`
-	Password                  *string             `mapstructure:"password" cty:"password" hcl:"password"`
-	Insecure                  *bool               `mapstructure:"insecure" cty:"insecure" hcl:"insecure"`
-	Name                      *string             `mapstructure:"vm_name" cty:"vm_name" hcl:"vm_name"`
-	CPU                       *float64            `mapstructure:"vm_cpu" cty:"vm_cpu" hcl:"vm_cpu"`
-	CPUModel                  *string             `mapstructure:"vm_cpu_model" cty:"vm_cpu_model" hcl:"vm_cpu_model"`
-	Description               *string             `mapstructure:"vm_description" cty:"vm_description" hcl:"vm_description"`
-	EnableVNC                 *bool               `mapstructure:"enable_vnc" cty:"enable_vnc" hcl:"enable_vnc"`
-	GraphicsKeymap            *string             `mapstructure:"vm_graphics_keymap" cty:"vm_graphics_keymap" hcl:"vm_graphics_keymap"`
-	GraphicsListen            *string             `mapstructure:"vm_graphics_listen" cty:"vm_graphics_listen" hcl:"vm_graphics_listen"`
-	GraphicsType              *string             `mapstructure:"vm_graphics_type" cty:"vm_graphics_type" hcl:"vm_graphics_type"`
-	Hypervisor                *string             `mapstructure:"vm_hypervisor" cty:"vm_hypervisor" hcl:"vm_hypervisor"`
-	Logo                      *string             `mapstructure:"vm_logo" cty:"vm_logo" hcl:"vm_logo"`
-	Memory                    *int                `mapstructure:"vm_memory" cty:"vm_memory" hcl:"vm_memory"`
-	NICs                      []FlatNICConfig     `mapstructure:"vm_nics" cty:"vm_nics" hcl:"vm_nics"`
-	OSArch                    *string             `mapstructure:"vm_os_arch" cty:"vm_os_arch" hcl:"vm_os_arch"`
-	OSBoot                    *string             `mapstructure:"vm_os_boot" cty:"vm_os_boot" hcl:"vm_os_boot"`
-	VCPU                      *int                `mapstructure:"vm_vcpu" cty:"vm_vcpu" hcl:"vm_vcpu"`
-	UserData                  *string             `mapstructure:"vm_user_data" cty:"vm_user_data" hcl:"vm_user_data"`
-	BootGroupInterval         *string             `mapstructure:"boot_keygroup_interval" cty:"boot_keygroup_interval" hcl:"boot_keygroup_interval"`
-	BootWait                  *string             `mapstructure:"boot_wait" cty:"boot_wait" hcl:"boot_wait"`
-	BootCommand               []string            `mapstructure:"boot_command" cty:"boot_command" hcl:"boot_command"`
-	DisableVNC                *bool               `mapstructure:"disable_vnc" cty:"disable_vnc" hcl:"disable_vnc"`
-	BootKeyInterval           *string             `mapstructure:"boot_key_interval" cty:"boot_key_interval" hcl:"boot_key_interval"`
-	VNCPassword               *string             `mapstructure:"vm_vnc_password,omitempty" cty:"vm_vnc_password" hcl:"vm_vnc_password"`
-	VNCIP                     *string             `mapstructure:"vnc_ip" required:"false" cty:"vnc_ip" hcl:"vnc_ip"`
-	VNCPort                   *int                `mapstructure:"vnc_port" required:"false" cty:"vnc_port" hcl:"vnc_port"`
-	BootSteps                 [][]string          `mapstructure:"boot_steps" required:"false" cty:"boot_steps" hcl:"boot_steps"`
-	Type                      *string             `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
-	PauseBeforeConnect        *string             `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
-	SSHHost                   *string             `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
-	SSHPort                   *int                `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
-	SSHUsername               *string             `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword               *string             `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
-	SSHKeyPairName            *string             `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
-	SSHTemporaryKeyPairName   *string             `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
-	SSHTemporaryKeyPairType   *string             `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
-	SSHTemporaryKeyPairBits   *int                `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
-	SSHCiphers                []string            `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
-	SSHClearAuthorizedKeys    *bool               `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
-	SSHKEXAlgos               []string            `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
-	SSHPrivateKeyFile         *string             `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
-	SSHCertificateFile        *string             `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
-	SSHPty                    *bool               `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
-	SSHTimeout                *string             `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
-	SSHWaitTimeout            *string             `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	SSHAgentAuth              *bool               `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
-	SSHDisableAgentForwarding *bool               `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
-	SSHHandshakeAttempts      *int                `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
-	SSHBastionHost            *string             `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
-	SSHBastionPort            *int                `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
-	SSHBastionAgentAuth       *bool               `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
-	SSHBastionUsername        *string             `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
-	SSHBastionPassword        *string             `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
-	SSHBastionInteractive     *bool               `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
-	SSHBastionPrivateKeyFile  *string             `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
-	SSHBastionCertificateFile *string             `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
-	SSHFileTransferMethod     *string             `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
-	SSHProxyHost              *string             `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
-	SSHProxyPort              *int                `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
-	SSHProxyUsername          *string             `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
-	SSHProxyPassword          *string             `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
-	SSHKeepAliveInterval      *string             `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
-	SSHReadWriteTimeout       *string             `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
-	SSHRemoteTunnels          []string            `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
-	SSHLocalTunnels           []string            `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
-	SSHPublicKey              []byte              `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
-	SSHPrivateKey             []byte              `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
-	WinRMUser                 *string             `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword             *string             `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMHost                 *string             `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMNoProxy              *bool               `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
-	WinRMPort                 *int                `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
-	WinRMTimeout              *string             `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
-	WinRMUseSSL               *bool               `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
-	WinRMInsecure             *bool               `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
-	WinRMUseNTLM              *bool               `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	PackerBuildName           *string                       `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType         *string                       `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion         *string                       `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug               *bool                         `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce               *bool                         `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError             *string                       `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars            map[string]string             `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars       []string                      `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	HTTPDir                   *string                       `mapstructure:"http_directory" cty:"http_directory" hcl:"http_directory"`
+	HTTPContent               map[string]string             `mapstructure:"http_content" cty:"http_content" hcl:"http_content"`
+	HTTPPortMin               *int                          `mapstructure:"http_port_min" cty:"http_port_min" hcl:"http_port_min"`
+	HTTPPortMax               *int                          `mapstructure:"http_port_max" cty:"http_port_max" hcl:"http_port_max"`
+	HTTPAddress               *string                       `mapstructure:"http_bind_address" cty:"http_bind_address" hcl:"http_bind_address"`
+	HTTPInterface             *string                       `mapstructure:"http_interface" undocumented:"true" cty:"http_interface" hcl:"http_interface"`
+	Datastore                 *string                       `mapstructure:"datastore" cty:"datastore" hcl:"datastore"`
+	Debug                     *bool                         `mapstructure:"debug" cty:"debug" hcl:"debug"`
+	EjectISO                  *bool                         `mapstructure:"eject_iso" cty:"eject_iso" hcl:"eject_iso"`
+	EjectISODelay             *string                       `mapstructure:"eject_iso_delay" cty:"eject_iso_delay" hcl:"eject_iso_delay"`
+	EjectISOKeep              []string                      `mapstructure:"eject_iso_keep" cty:"eject_iso_keep" hcl:"eject_iso_keep"`
+	EjectISODelete            *bool                         `mapstructure:"eject_iso_delete" cty:"eject_iso_delete" hcl:"eject_iso_delete"`
+	SnapshotConfig            *FlatSnapshotConfig           `mapstructure:"snapshot" cty:"snapshot" hcl:"snapshot"`
+	ImageConfigs              []FlatImageConfig             `mapstructure:"image" cty:"image" hcl:"image"`
+	ShutdownMethods           []string                      `mapstructure:"shutdown_methods" cty:"shutdown_methods" hcl:"shutdown_methods"`
+	KeepVM                    *string                       `mapstructure:"keep_vm" cty:"keep_vm" hcl:"keep_vm"`
+	OutputOwner               *string                       `mapstructure:"output_owner" cty:"output_owner" hcl:"output_owner"`
+	OutputGroup               *string                       `mapstructure:"output_group" cty:"output_group" hcl:"output_group"`
+	OutputPermissions         *string                       `mapstructure:"output_permissions" cty:"output_permissions" hcl:"output_permissions"`
+	MarketplacePublishConfig  *FlatMarketplacePublishConfig `mapstructure:"marketplace_publish" cty:"marketplace_publish" hcl:"marketplace_publish"`
+	SourceVMID                *int                          `mapstructure:"source_vm_id" cty:"source_vm_id" hcl:"source_vm_id"`
+	OpenNebulaURL             *string                       `mapstructure:"opennebula_url" cty:"opennebula_url" hcl:"opennebula_url"`
+	Username                  *string                       `mapstructure:"username" cty:"username" hcl:"username"`
+	Password                  *string                       `mapstructure:"password" cty:"password" hcl:"password"`
+	Insecure                  *bool                         `mapstructure:"insecure" cty:"insecure" hcl:"insecure"`
+	OutputDir                 *string                       `mapstructure:"output_directory" cty:"output_directory" hcl:"output_directory"`
+	Export                    *bool                         `mapstructure:"export" cty:"export" hcl:"export"`
+	ExportSource              *string                       `mapstructure:"export_source" cty:"export_source" hcl:"export_source"`
+	ExportURL                 *string                       `mapstructure:"export_url" cty:"export_url" hcl:"export_url"`
+	ExportChecksumURL         *string                       `mapstructure:"export_checksum_url" cty:"export_checksum_url" hcl:"export_checksum_url"`
+	ShutdownCommand           *string                       `mapstructure:"shutdown_command" required:"false" cty:"shutdown_command" hcl:"shutdown_command"`
+	ShutdownTimeout           *string                       `mapstructure:"shutdown_timeout" required:"false" cty:"shutdown_timeout" hcl:"shutdown_timeout"`
+	Name                      *string                       `mapstructure:"vm_name" cty:"vm_name" hcl:"vm_name"`
+	CPU                       *float64                      `mapstructure:"vm_cpu" cty:"vm_cpu" hcl:"vm_cpu"`
+	CPUModel                  *string                       `mapstructure:"vm_cpu_model" cty:"vm_cpu_model" hcl:"vm_cpu_model"`
+	Description               *string                       `mapstructure:"vm_description" cty:"vm_description" hcl:"vm_description"`
+	EnableVNC                 *bool                         `mapstructure:"enable_vnc" cty:"enable_vnc" hcl:"enable_vnc"`
+	GraphicsKeymap            *string                       `mapstructure:"vm_graphics_keymap" cty:"vm_graphics_keymap" hcl:"vm_graphics_keymap"`
+	GraphicsListen            *string                       `mapstructure:"vm_graphics_listen" cty:"vm_graphics_listen" hcl:"vm_graphics_listen"`
+	GraphicsType              *string                       `mapstructure:"vm_graphics_type" cty:"vm_graphics_type" hcl:"vm_graphics_type"`
+	Hypervisor                *string                       `mapstructure:"vm_hypervisor" cty:"vm_hypervisor" hcl:"vm_hypervisor"`
+	Logo                      *string                       `mapstructure:"vm_logo" cty:"vm_logo" hcl:"vm_logo"`
+	Memory                    *int                          `mapstructure:"vm_memory" cty:"vm_memory" hcl:"vm_memory"`
+	NICs                      []FlatNICConfig               `mapstructure:"vm_nics" cty:"vm_nics" hcl:"vm_nics"`
+	OSArch                    *string                       `mapstructure:"vm_os_arch" cty:"vm_os_arch" hcl:"vm_os_arch"`
+	OSBoot                    *string                       `mapstructure:"vm_os_boot" cty:"vm_os_boot" hcl:"vm_os_boot"`
+	PostInstallBoot           *string                       `mapstructure:"post_install_boot" cty:"post_install_boot" hcl:"post_install_boot"`
+	VCPU                      *int                          `mapstructure:"vm_vcpu" cty:"vm_vcpu" hcl:"vm_vcpu"`
+	UserData                  *string                       `mapstructure:"vm_user_data" cty:"vm_user_data" hcl:"vm_user_data"`
+	OSKernel                  *string                       `mapstructure:"vm_os_kernel" cty:"vm_os_kernel" hcl:"vm_os_kernel"`
+	OSKernelPath              *string                       `mapstructure:"vm_os_kernel_path" cty:"vm_os_kernel_path" hcl:"vm_os_kernel_path"`
+	OSInitrd                  *string                       `mapstructure:"vm_os_initrd" cty:"vm_os_initrd" hcl:"vm_os_initrd"`
+	OSInitrdPath              *string                       `mapstructure:"vm_os_initrd_path" cty:"vm_os_initrd_path" hcl:"vm_os_initrd_path"`
+	OSKernelDatastoreID       *int                          `mapstructure:"vm_os_kernel_datastore_id" cty:"vm_os_kernel_datastore_id" hcl:"vm_os_kernel_datastore_id"`
+	OSKernelCmd               *string                       `mapstructure:"vm_os_kernel_cmd" cty:"vm_os_kernel_cmd" hcl:"vm_os_kernel_cmd"`
+	OSInstallTimeout          *string                       `mapstructure:"vm_os_install_timeout" cty:"vm_os_install_timeout" hcl:"vm_os_install_timeout"`
+	BootGroupInterval         *string                       `mapstructure:"boot_keygroup_interval" cty:"boot_keygroup_interval" hcl:"boot_keygroup_interval"`
+	BootWait                  *string                       `mapstructure:"boot_wait" cty:"boot_wait" hcl:"boot_wait"`
+	BootCommand               []string                      `mapstructure:"boot_command" cty:"boot_command" hcl:"boot_command"`
+	DisableVNC                *bool                         `mapstructure:"disable_vnc" cty:"disable_vnc" hcl:"disable_vnc"`
+	BootKeyInterval           *string                       `mapstructure:"boot_key_interval" cty:"boot_key_interval" hcl:"boot_key_interval"`
+	VNCPassword               *string                       `mapstructure:"vm_vnc_password,omitempty" cty:"vm_vnc_password" hcl:"vm_vnc_password"`
+	VNCIP                     *string                       `mapstructure:"vnc_ip" required:"false" cty:"vnc_ip" hcl:"vnc_ip"`
+	VNCPort                   *int                          `mapstructure:"vnc_port" required:"false" cty:"vnc_port" hcl:"vnc_port"`
+	BootSteps                 [][]string                    `mapstructure:"boot_steps" required:"false" cty:"boot_steps" hcl:"boot_steps"`
+	VNCScreenshotInterval     *string                       `mapstructure:"vnc_screenshot_interval" required:"false" cty:"vnc_screenshot_interval" hcl:"vnc_screenshot_interval"`
+	VNCScreenshotOnError      *bool                         `mapstructure:"vnc_screenshot_on_error" required:"false" cty:"vnc_screenshot_on_error" hcl:"vnc_screenshot_on_error"`
+	VNCRecord                 *bool                         `mapstructure:"vnc_record" required:"false" cty:"vnc_record" hcl:"vnc_record"`
+	VNCRecordInterval         *string                       `mapstructure:"vnc_record_interval" required:"false" cty:"vnc_record_interval" hcl:"vnc_record_interval"`
+	VNCProxy                  *string                       `mapstructure:"vnc_proxy" required:"false" cty:"vnc_proxy" hcl:"vnc_proxy"`
+	VNCProxyURL               *string                       `mapstructure:"vnc_proxy_url" required:"false" cty:"vnc_proxy_url" hcl:"vnc_proxy_url"`
+	VNCProxyWebsocketURL      *string                       `mapstructure:"vnc_proxy_websocket_url" required:"false" cty:"vnc_proxy_websocket_url" hcl:"vnc_proxy_websocket_url"`
+	VNCBastionHost            *string                       `mapstructure:"vnc_bastion_host" required:"false" cty:"vnc_bastion_host" hcl:"vnc_bastion_host"`
+	VNCBastionPort            *int                          `mapstructure:"vnc_bastion_port" required:"false" cty:"vnc_bastion_port" hcl:"vnc_bastion_port"`
+	VNCBastionUsername        *string                       `mapstructure:"vnc_bastion_username" required:"false" cty:"vnc_bastion_username" hcl:"vnc_bastion_username"`
+	VNCBastionPassword        *string                       `mapstructure:"vnc_bastion_password" required:"false" cty:"vnc_bastion_password" hcl:"vnc_bastion_password"`
+	VNCBastionPrivateKeyFile  *string                       `mapstructure:"vnc_bastion_private_key_file" required:"false" cty:"vnc_bastion_private_key_file" hcl:"vnc_bastion_private_key_file"`
+	VNCBastionAgentAuth       *bool                         `mapstructure:"vnc_bastion_agent_auth" required:"false" cty:"vnc_bastion_agent_auth" hcl:"vnc_bastion_agent_auth"`
+	BootConsole               *string                       `mapstructure:"boot_console" required:"false" cty:"boot_console" hcl:"boot_console"`
+	SerialPort                *int                          `mapstructure:"serial_port" required:"false" cty:"serial_port" hcl:"serial_port"`
+	SerialListen              *string                       `mapstructure:"serial_listen" required:"false" cty:"serial_listen" hcl:"serial_listen"`
+	SerialLogFile             *string                       `mapstructure:"serial_log_file" required:"false" cty:"serial_log_file" hcl:"serial_log_file"`
+	Type                      *string                       `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect        *string                       `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                   *string                       `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                   *int                          `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername               *string                       `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword               *string                       `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName            *string                       `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName   *string                       `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHTemporaryKeyPairType   *string                       `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits   *int                          `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
+	SSHCiphers                []string                      `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys    *bool                         `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos               []string                      `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile         *string                       `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile        *string                       `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                    *bool                         `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                *string                       `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout            *string                       `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth              *bool                         `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding *bool                         `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts      *int                          `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost            *string                       `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort            *int                          `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth       *bool                         `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername        *string                       `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword        *string                       `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive     *bool                         `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile  *string                       `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile *string                       `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod     *string                       `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost              *string                       `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort              *int                          `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername          *string                       `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword          *string                       `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval      *string                       `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout       *string                       `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels          []string                      `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels           []string                      `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey              []byte                        `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey             []byte                        `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                 *string                       `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword             *string                       `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                 *string                       `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy              *bool                         `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                 *int                          `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout              *string                       `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL               *bool                         `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure             *bool                         `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM              *bool                         `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -140,12 +181,28 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"debug":                        &hcldec.AttrSpec{Name: "debug", Type: cty.Bool, Required: false},
 		"eject_iso":                    &hcldec.AttrSpec{Name: "eject_iso", Type: cty.Bool, Required: false},
 		"eject_iso_delay":              &hcldec.AttrSpec{Name: "eject_iso_delay", Type: cty.String, Required: false},
+		"eject_iso_keep":               &hcldec.AttrSpec{Name: "eject_iso_keep", Type: cty.List(cty.String), Required: false},
+		"eject_iso_delete":             &hcldec.AttrSpec{Name: "eject_iso_delete", Type: cty.Bool, Required: false},
 		"snapshot":                     &hcldec.BlockSpec{TypeName: "snapshot", Nested: hcldec.ObjectSpec((*FlatSnapshotConfig)(nil).HCL2Spec())},
 		"image":                        &hcldec.BlockListSpec{TypeName: "image", Nested: hcldec.ObjectSpec((*FlatImageConfig)(nil).HCL2Spec())},
+		"shutdown_methods":             &hcldec.AttrSpec{Name: "shutdown_methods", Type: cty.List(cty.String), Required: false},
+		"keep_vm":                      &hcldec.AttrSpec{Name: "keep_vm", Type: cty.String, Required: false},
+		"output_owner":                 &hcldec.AttrSpec{Name: "output_owner", Type: cty.String, Required: false},
+		"output_group":                 &hcldec.AttrSpec{Name: "output_group", Type: cty.String, Required: false},
+		"output_permissions":           &hcldec.AttrSpec{Name: "output_permissions", Type: cty.String, Required: false},
+		"marketplace_publish":          &hcldec.BlockSpec{TypeName: "marketplace_publish", Nested: hcldec.ObjectSpec((*FlatMarketplacePublishConfig)(nil).HCL2Spec())},
+		"source_vm_id":                 &hcldec.AttrSpec{Name: "source_vm_id", Type: cty.Number, Required: false},
 		"opennebula_url":               &hcldec.AttrSpec{Name: "opennebula_url", Type: cty.String, Required: false},
 		"username":                     &hcldec.AttrSpec{Name: "username", Type: cty.String, Required: false},
 		"password":                     &hcldec.AttrSpec{Name: "password", Type: cty.String, Required: false},
 		"insecure":                     &hcldec.AttrSpec{Name: "insecure", Type: cty.Bool, Required: false},
+		"output_directory":             &hcldec.AttrSpec{Name: "output_directory", Type: cty.String, Required: false},
+		"export":                       &hcldec.AttrSpec{Name: "export", Type: cty.Bool, Required: false},
+		"export_source":                &hcldec.AttrSpec{Name: "export_source", Type: cty.String, Required: false},
+		"export_url":                   &hcldec.AttrSpec{Name: "export_url", Type: cty.String, Required: false},
+		"export_checksum_url":          &hcldec.AttrSpec{Name: "export_checksum_url", Type: cty.String, Required: false},
+		"shutdown_command":             &hcldec.AttrSpec{Name: "shutdown_command", Type: cty.String, Required: false},
+		"shutdown_timeout":             &hcldec.AttrSpec{Name: "shutdown_timeout", Type: cty.String, Required: false},
 		"vm_name":                      &hcldec.AttrSpec{Name: "vm_name", Type: cty.String, Required: false},
 		"vm_cpu":                       &hcldec.AttrSpec{Name: "vm_cpu", Type: cty.Number, Required: false},
 		"vm_cpu_model":                 &hcldec.AttrSpec{Name: "vm_cpu_model", Type: cty.String, Required: false},
@@ -160,8 +217,16 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"vm_nics":                      &hcldec.BlockListSpec{TypeName: "vm_nics", Nested: hcldec.ObjectSpec((*FlatNICConfig)(nil).HCL2Spec())},
 		"vm_os_arch":                   &hcldec.AttrSpec{Name: "vm_os_arch", Type: cty.String, Required: false},
 		"vm_os_boot":                   &hcldec.AttrSpec{Name: "vm_os_boot", Type: cty.String, Required: false},
+		"post_install_boot":            &hcldec.AttrSpec{Name: "post_install_boot", Type: cty.String, Required: false},
 		"vm_vcpu":                      &hcldec.AttrSpec{Name: "vm_vcpu", Type: cty.Number, Required: false},
 		"vm_user_data":                 &hcldec.AttrSpec{Name: "vm_user_data", Type: cty.String, Required: false},
+		"vm_os_kernel":                 &hcldec.AttrSpec{Name: "vm_os_kernel", Type: cty.String, Required: false},
+		"vm_os_kernel_path":            &hcldec.AttrSpec{Name: "vm_os_kernel_path", Type: cty.String, Required: false},
+		"vm_os_initrd":                 &hcldec.AttrSpec{Name: "vm_os_initrd", Type: cty.String, Required: false},
+		"vm_os_initrd_path":            &hcldec.AttrSpec{Name: "vm_os_initrd_path", Type: cty.String, Required: false},
+		"vm_os_kernel_datastore_id":    &hcldec.AttrSpec{Name: "vm_os_kernel_datastore_id", Type: cty.Number, Required: false},
+		"vm_os_kernel_cmd":             &hcldec.AttrSpec{Name: "vm_os_kernel_cmd", Type: cty.String, Required: false},
+		"vm_os_install_timeout":        &hcldec.AttrSpec{Name: "vm_os_install_timeout", Type: cty.String, Required: false},
 		"boot_keygroup_interval":       &hcldec.AttrSpec{Name: "boot_keygroup_interval", Type: cty.String, Required: false},
 		"boot_wait":                    &hcldec.AttrSpec{Name: "boot_wait", Type: cty.String, Required: false},
 		"boot_command":                 &hcldec.AttrSpec{Name: "boot_command", Type: cty.List(cty.String), Required: false},
@@ -171,6 +236,23 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"vnc_ip":                       &hcldec.AttrSpec{Name: "vnc_ip", Type: cty.String, Required: false},
 		"vnc_port":                     &hcldec.AttrSpec{Name: "vnc_port", Type: cty.Number, Required: false},
 		"boot_steps":                   &hcldec.AttrSpec{Name: "boot_steps", Type: cty.List(cty.List(cty.String)), Required: false},
+		"vnc_screenshot_interval":      &hcldec.AttrSpec{Name: "vnc_screenshot_interval", Type: cty.String, Required: false},
+		"vnc_screenshot_on_error":      &hcldec.AttrSpec{Name: "vnc_screenshot_on_error", Type: cty.Bool, Required: false},
+		"vnc_record":                   &hcldec.AttrSpec{Name: "vnc_record", Type: cty.Bool, Required: false},
+		"vnc_record_interval":          &hcldec.AttrSpec{Name: "vnc_record_interval", Type: cty.String, Required: false},
+		"vnc_proxy":                    &hcldec.AttrSpec{Name: "vnc_proxy", Type: cty.String, Required: false},
+		"vnc_proxy_url":                &hcldec.AttrSpec{Name: "vnc_proxy_url", Type: cty.String, Required: false},
+		"vnc_proxy_websocket_url":      &hcldec.AttrSpec{Name: "vnc_proxy_websocket_url", Type: cty.String, Required: false},
+		"vnc_bastion_host":             &hcldec.AttrSpec{Name: "vnc_bastion_host", Type: cty.String, Required: false},
+		"vnc_bastion_port":             &hcldec.AttrSpec{Name: "vnc_bastion_port", Type: cty.Number, Required: false},
+		"vnc_bastion_username":         &hcldec.AttrSpec{Name: "vnc_bastion_username", Type: cty.String, Required: false},
+		"vnc_bastion_password":         &hcldec.AttrSpec{Name: "vnc_bastion_password", Type: cty.String, Required: false},
+		"vnc_bastion_private_key_file": &hcldec.AttrSpec{Name: "vnc_bastion_private_key_file", Type: cty.String, Required: false},
+		"vnc_bastion_agent_auth":       &hcldec.AttrSpec{Name: "vnc_bastion_agent_auth", Type: cty.Bool, Required: false},
+		"boot_console":                 &hcldec.AttrSpec{Name: "boot_console", Type: cty.String, Required: false},
+		"serial_port":                  &hcldec.AttrSpec{Name: "serial_port", Type: cty.Number, Required: false},
+		"serial_listen":                &hcldec.AttrSpec{Name: "serial_listen", Type: cty.String, Required: false},
+		"serial_log_file":              &hcldec.AttrSpec{Name: "serial_log_file", Type: cty.String, Required: false},
 		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
 		"pause_before_connecting":      &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
 		"ssh_host":                     &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
@@ -224,25 +306,58 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	return s
 }
 
+// FlatExportConfig is an auto-generated flat version of ExportConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatExportConfig struct {
+	OutputDir         *string `mapstructure:"output_directory" cty:"output_directory" hcl:"output_directory"`
+	Export            *bool   `mapstructure:"export" cty:"export" hcl:"export"`
+	ExportSource      *string `mapstructure:"export_source" cty:"export_source" hcl:"export_source"`
+	ExportURL         *string `mapstructure:"export_url" cty:"export_url" hcl:"export_url"`
+	ExportChecksumURL *string `mapstructure:"export_checksum_url" cty:"export_checksum_url" hcl:"export_checksum_url"`
+}
+
+// FlatMapstructure returns a new FlatExportConfig.
+// FlatExportConfig is an auto-generated flat version of ExportConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*ExportConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatExportConfig)
+}
+
+// HCL2Spec returns the hcl spec of a ExportConfig.
+// This spec is used by HCL to read the fields of ExportConfig.
+// The decoded values from this spec will then be applied to a FlatExportConfig.
+func (*FlatExportConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"output_directory":    &hcldec.AttrSpec{Name: "output_directory", Type: cty.String, Required: false},
+		"export":              &hcldec.AttrSpec{Name: "export", Type: cty.Bool, Required: false},
+		"export_source":       &hcldec.AttrSpec{Name: "export_source", Type: cty.String, Required: false},
+		"export_url":          &hcldec.AttrSpec{Name: "export_url", Type: cty.String, Required: false},
+		"export_checksum_url": &hcldec.AttrSpec{Name: "export_checksum_url", Type: cty.String, Required: false},
+	}
+	return s
+}
+
 // FlatImageConfig is an auto-generated flat version of ImageConfig.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatImageConfig struct {
-	Image_ID             *int     `mapstructure:"id" cty:"id" hcl:"id"`
-	Image_Name           *string  `mapstructure:"name" cty:"name" hcl:"name"`
-	Image_Type           *string  `mapstructure:"type" cty:"type" hcl:"type"`
-	Image_DatastoreID    *int     `mapstructure:"datastore_id" cty:"datastore_id" hcl:"datastore_id"`
-	Image_Persistent     *bool    `mapstructure:"persistent" cty:"persistent" hcl:"persistent"`
-	Image_Lock           *string  `mapstructure:"lock" cty:"lock" hcl:"lock"`
-	Image_Permissions    *int     `mapstructure:"permissions" cty:"permissions" hcl:"permissions"`
-	Image_Group          *string  `mapstructure:"group" cty:"group" hcl:"group"`
-	Image_Path           *string  `mapstructure:"path" cty:"path" hcl:"path"`
-	Image_DevPrefix      *string  `mapstructure:"dev_prefix" cty:"dev_prefix" hcl:"dev_prefix"`
-	Image_Target         *string  `mapstructure:"target" cty:"target" hcl:"target"`
-	Image_Driver         *string  `mapstructure:"driver" cty:"driver" hcl:"driver"`
-	Image_Format         *string  `mapstructure:"format" cty:"format" hcl:"format"`
-	Image_Size           *int     `mapstructure:"size" cty:"size" hcl:"size"`
-	Image_CloneFromImage *string  `mapstructure:"clone_from_image" cty:"clone_from_image" hcl:"clone_from_image"`
-	Image_Tags           []string `mapstructure:"tags" cty:"tags" hcl:"tags"`
+	Image_ID             *int                      `mapstructure:"id" cty:"id" hcl:"id"`
+	Image_Name           *string                   `mapstructure:"name" cty:"name" hcl:"name"`
+	Image_Type           *string                   `mapstructure:"type" cty:"type" hcl:"type"`
+	Image_DatastoreID    *int                      `mapstructure:"datastore_id" cty:"datastore_id" hcl:"datastore_id"`
+	Image_Persistent     *bool                     `mapstructure:"persistent" cty:"persistent" hcl:"persistent"`
+	Image_Lock           *string                   `mapstructure:"lock" cty:"lock" hcl:"lock"`
+	Image_Permissions    *int                      `mapstructure:"permissions" cty:"permissions" hcl:"permissions"`
+	Image_Group          *string                   `mapstructure:"group" cty:"group" hcl:"group"`
+	Image_Path           *string                   `mapstructure:"path" cty:"path" hcl:"path"`
+	Image_DevPrefix      *string                   `mapstructure:"dev_prefix" cty:"dev_prefix" hcl:"dev_prefix"`
+	Image_Target         *string                   `mapstructure:"target" cty:"target" hcl:"target"`
+	Image_Driver         *string                   `mapstructure:"driver" cty:"driver" hcl:"driver"`
+	Image_Format         *string                   `mapstructure:"format" cty:"format" hcl:"format"`
+	Image_Size           *int                      `mapstructure:"size" cty:"size" hcl:"size"`
+	Image_CloneFromImage *string                   `mapstructure:"clone_from_image" cty:"clone_from_image" hcl:"clone_from_image"`
+	Image_Tags           []string                  `mapstructure:"tags" cty:"tags" hcl:"tags"`
+	Image_Keep           *bool                     `mapstructure:"keep" cty:"keep" hcl:"keep"`
+	Image_MarketplaceApp *FlatMarketplaceAppConfig `mapstructure:"marketplace_app" cty:"marketplace_app" hcl:"marketplace_app"`
 }
 
 // FlatMapstructure returns a new FlatImageConfig.
@@ -273,6 +388,68 @@ func (*FlatImageConfig) HCL2Spec() map[string]hcldec.Spec {
 		"size":             &hcldec.AttrSpec{Name: "size", Type: cty.Number, Required: false},
 		"clone_from_image": &hcldec.AttrSpec{Name: "clone_from_image", Type: cty.String, Required: false},
 		"tags":             &hcldec.AttrSpec{Name: "tags", Type: cty.List(cty.String), Required: false},
+		"keep":             &hcldec.AttrSpec{Name: "keep", Type: cty.Bool, Required: false},
+		"marketplace_app":  &hcldec.BlockSpec{TypeName: "marketplace_app", Nested: hcldec.ObjectSpec((*FlatMarketplaceAppConfig)(nil).HCL2Spec())},
+	}
+	return s
+}
+
+// FlatMarketplaceAppConfig is an auto-generated flat version of MarketplaceAppConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatMarketplaceAppConfig struct {
+	App_ID          *int    `mapstructure:"id" cty:"id" hcl:"id"`
+	App_Name        *string `mapstructure:"name" cty:"name" hcl:"name"`
+	App_Marketplace *string `mapstructure:"marketplace" cty:"marketplace" hcl:"marketplace"`
+}
+
+// FlatMapstructure returns a new FlatMarketplaceAppConfig.
+// FlatMarketplaceAppConfig is an auto-generated flat version of MarketplaceAppConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*MarketplaceAppConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatMarketplaceAppConfig)
+}
+
+// HCL2Spec returns the hcl spec of a MarketplaceAppConfig.
+// This spec is used by HCL to read the fields of MarketplaceAppConfig.
+// The decoded values from this spec will then be applied to a FlatMarketplaceAppConfig.
+func (*FlatMarketplaceAppConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"id":          &hcldec.AttrSpec{Name: "id", Type: cty.Number, Required: false},
+		"name":        &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"marketplace": &hcldec.AttrSpec{Name: "marketplace", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatMarketplacePublishConfig is an auto-generated flat version of MarketplacePublishConfig.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatMarketplacePublishConfig struct {
+	Publish_Marketplace *string           `mapstructure:"marketplace" cty:"marketplace" hcl:"marketplace"`
+	Publish_Name        *string           `mapstructure:"name" cty:"name" hcl:"name"`
+	Publish_Version     *string           `mapstructure:"version" cty:"version" hcl:"version"`
+	Publish_Description *string           `mapstructure:"description" cty:"description" hcl:"description"`
+	Publish_Publisher   *string           `mapstructure:"publisher" cty:"publisher" hcl:"publisher"`
+	Publish_AppTemplate map[string]string `mapstructure:"app_template" cty:"app_template" hcl:"app_template"`
+}
+
+// FlatMapstructure returns a new FlatMarketplacePublishConfig.
+// FlatMarketplacePublishConfig is an auto-generated flat version of MarketplacePublishConfig.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*MarketplacePublishConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatMarketplacePublishConfig)
+}
+
+// HCL2Spec returns the hcl spec of a MarketplacePublishConfig.
+// This spec is used by HCL to read the fields of MarketplacePublishConfig.
+// The decoded values from this spec will then be applied to a FlatMarketplacePublishConfig.
+func (*FlatMarketplacePublishConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"marketplace":  &hcldec.AttrSpec{Name: "marketplace", Type: cty.String, Required: false},
+		"name":         &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"version":      &hcldec.AttrSpec{Name: "version", Type: cty.String, Required: false},
+		"description":  &hcldec.AttrSpec{Name: "description", Type: cty.String, Required: false},
+		"publisher":    &hcldec.AttrSpec{Name: "publisher", Type: cty.String, Required: false},
+		"app_template": &hcldec.AttrSpec{Name: "app_template", Type: cty.Map(cty.String), Required: false},
 	}
 	return s
 }
@@ -303,8 +480,11 @@ func (*FlatNICConfig) HCL2Spec() map[string]hcldec.Spec {
 // FlatSnapshotConfig is an auto-generated flat version of SnapshotConfig.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatSnapshotConfig struct {
-	Snapshot_Name        *string `mapstructure:"name" cty:"name" hcl:"name"`
-	Snapshot_DatastoreID *int    `mapstructure:"datastore_id" cty:"datastore_id" hcl:"datastore_id"`
+	Snapshot_Name           *string `mapstructure:"name" cty:"name" hcl:"name"`
+	Snapshot_DatastoreID    *int    `mapstructure:"datastore_id" cty:"datastore_id" hcl:"datastore_id"`
+	Snapshot_Family         *string `mapstructure:"family" cty:"family" hcl:"family"`
+	Snapshot_KeepLast       *int    `mapstructure:"keep_last" cty:"keep_last" hcl:"keep_last"`
+	Snapshot_RotationDryRun *bool   `mapstructure:"rotation_dry_run" cty:"rotation_dry_run" hcl:"rotation_dry_run"`
 }
 
 // FlatMapstructure returns a new FlatSnapshotConfig.
@@ -319,8 +499,11 @@ func (*SnapshotConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hclde
 // The decoded values from this spec will then be applied to a FlatSnapshotConfig.
 func (*FlatSnapshotConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"name":         &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
-		"datastore_id": &hcldec.AttrSpec{Name: "datastore_id", Type: cty.Number, Required: false},
+		"name":             &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"datastore_id":     &hcldec.AttrSpec{Name: "datastore_id", Type: cty.Number, Required: false},
+		"family":           &hcldec.AttrSpec{Name: "family", Type: cty.String, Required: false},
+		"keep_last":        &hcldec.AttrSpec{Name: "keep_last", Type: cty.Number, Required: false},
+		"rotation_dry_run": &hcldec.AttrSpec{Name: "rotation_dry_run", Type: cty.Bool, Required: false},
 	}
 	return s
 }
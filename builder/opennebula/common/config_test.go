@@ -0,0 +1,155 @@
+package opennebula
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRawConfig returns the minimal raw configuration of a build, with raw
+// merged in.
+func testRawConfig(raw map[string]interface{}) map[string]interface{} {
+	config := map[string]interface{}{
+		"opennebula_url": "https://one.example.com:2633/RPC2",
+		"username":       "oneadmin",
+		"password":       "opennebula",
+		"communicator":   "none",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	return config
+}
+
+// checkPrepareError runs Prepare on the raw configuration, which must fail
+// with an error containing expected, or succeed if expected is empty.
+func checkPrepareError(t *testing.T, raw map[string]interface{}, expected string) *Config {
+	t.Helper()
+	var c Config
+	_, err := c.Prepare(testRawConfig(raw))
+	switch {
+	case expected == "" && err != nil:
+		t.Fatalf("Prepare: %s", err)
+	case expected != "" && err == nil:
+		t.Fatalf("Prepare succeeded, expected an error containing %q", expected)
+	case expected != "" && !strings.Contains(err.Error(), expected):
+		t.Fatalf("Prepare: %s, expected an error containing %q", err, expected)
+	}
+	return &c
+}
+
+func TestConfigPrepare_shutdownMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected []string
+		err      string
+	}{
+		{"default", nil, []string{"poweroff", "poweroff_hard"}, ""},
+		{"default with command", map[string]interface{}{"shutdown_command": "shutdown -P now"}, []string{"command", "poweroff", "poweroff_hard"}, ""},
+		{"explicit", map[string]interface{}{"shutdown_methods": []string{"poweroff_hard"}}, []string{"poweroff_hard"}, ""},
+		{"command without shutdown_command", map[string]interface{}{"shutdown_methods": []string{"command"}}, nil, "requires shutdown_command"},
+		{"invalid", map[string]interface{}{"shutdown_methods": []string{"reboot"}}, nil, "invalid shutdown method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := checkPrepareError(t, tt.raw, tt.err)
+			if tt.err == "" && !reflect.DeepEqual(c.ShutdownMethods, tt.expected) {
+				t.Errorf("shutdown_methods = %v, expected %v", c.ShutdownMethods, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfigPrepare_keepVM(t *testing.T) {
+	if c := checkPrepareError(t, nil, ""); c.KeepVM != "never" {
+		t.Errorf("keep_vm = %q, expected never", c.KeepVM)
+	}
+	checkPrepareError(t, map[string]interface{}{"keep_vm": "on_failure"}, "")
+	checkPrepareError(t, map[string]interface{}{"keep_vm": "sometimes"}, "invalid keep_vm")
+}
+
+func TestConfigPrepare_vncProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+		err      string
+	}{
+		{"sunstone", map[string]interface{}{"vnc_proxy": "sunstone"}, "https://one.example.com:9869", ""},
+		{"fireedge", map[string]interface{}{"vnc_proxy": "fireedge"}, "https://one.example.com:2616/fireedge", ""},
+		{"explicit url", map[string]interface{}{"vnc_proxy": "fireedge", "vnc_proxy_url": "https://sunstone.example.com/fireedge"}, "https://sunstone.example.com/fireedge", ""},
+		{"invalid", map[string]interface{}{"vnc_proxy": "guacamole"}, "", "invalid vnc_proxy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := checkPrepareError(t, tt.raw, tt.err)
+			if tt.err == "" && c.VNCProxyURL != tt.expected {
+				t.Errorf("vnc_proxy_url = %q, expected %q", c.VNCProxyURL, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfigPrepare_vncBastion(t *testing.T) {
+	sshBastion := map[string]interface{}{
+		"communicator":         "ssh",
+		"ssh_username":         "ubuntu",
+		"ssh_bastion_host":     "jump.example.com",
+		"ssh_bastion_port":     2222,
+		"ssh_bastion_username": "packer",
+		"ssh_bastion_password": "secret",
+	}
+	withSSHBastion := func(raw map[string]interface{}) map[string]interface{} {
+		config := map[string]interface{}{}
+		for k, v := range sshBastion {
+			config[k] = v
+		}
+		for k, v := range raw {
+			config[k] = v
+		}
+		return config
+	}
+
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		host     string
+		port     int
+		username string
+		password string
+		err      string
+	}{
+		{"ssh bastion", withSSHBastion(nil), "jump.example.com", 2222, "packer", "secret", ""},
+		{"vnc bastion", withSSHBastion(map[string]interface{}{"vnc_bastion_host": "vnc-jump.example.com", "vnc_bastion_username": "vnc"}), "vnc-jump.example.com", 22, "vnc", "secret", ""},
+		{"vnc proxy", withSSHBastion(map[string]interface{}{"vnc_proxy": "sunstone"}), "", 0, "", "", ""},
+		{"no credentials", map[string]interface{}{"vnc_bastion_host": "jump.example.com"}, "", 0, "", "", "vnc_bastion_host requires"},
+		{"vnc proxy and bastion", map[string]interface{}{"vnc_bastion_host": "jump.example.com", "vnc_bastion_password": "secret", "vnc_proxy": "sunstone"}, "", 0, "", "", "cannot be combined with vnc_proxy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := checkPrepareError(t, tt.raw, tt.err)
+			if tt.err != "" {
+				return
+			}
+			if c.VNCBastionHost != tt.host || c.VNCBastionPort != tt.port || c.VNCBastionUsername != tt.username || c.VNCBastionPassword != tt.password {
+				t.Errorf("VNC bastion %s@%s:%d with password %q, expected %s@%s:%d with %q",
+					c.VNCBastionUsername, c.VNCBastionHost, c.VNCBastionPort, c.VNCBastionPassword, tt.username, tt.host, tt.port, tt.password)
+			}
+		})
+	}
+}
+
+func TestConfigPrepare_serialConsole(t *testing.T) {
+	serial := map[string]interface{}{"boot_console": "serial", "serial_port": 4555}
+	if c := checkPrepareError(t, serial, ""); c.SerialListen != "0.0.0.0" {
+		t.Errorf("serial_listen = %q, expected 0.0.0.0", c.SerialListen)
+	}
+	serial["serial_listen"] = "192.0.2.20"
+	if c := checkPrepareError(t, serial, ""); c.SerialListen != "192.0.2.20" {
+		t.Errorf("serial_listen = %q, expected 192.0.2.20", c.SerialListen)
+	}
+	serial["serial_listen"] = "hypervisor' host='0.0.0.0"
+	checkPrepareError(t, serial, "invalid serial_listen")
+	checkPrepareError(t, map[string]interface{}{"boot_console": "serial"}, "serial_port must be specified")
+}
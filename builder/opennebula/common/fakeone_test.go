@@ -0,0 +1,102 @@
+package opennebula
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+func TestMain(m *testing.M) {
+	// The fake frontend moves resources to their next state on every poll
+	pollInterval = time.Millisecond
+	// The fake VNC server answers as soon as the screen changes
+	screenWaitPollInterval = time.Millisecond
+	os.Exit(m.Run())
+}
+
+// testWriter writes the output of the ui to the test log.
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+func testUi(t *testing.T) packersdk.Ui {
+	return &packersdk.BasicUi{
+		Reader:      strings.NewReader(""),
+		Writer:      testWriter{t},
+		ErrorWriter: testWriter{t},
+	}
+}
+
+// testConfig returns a configuration connected to srv.
+func testConfig(t *testing.T, srv *fakeone.Server) *Config {
+	config := &Config{}
+	config.OpenNebulaURL = srv.URL
+	config.Username = "oneadmin"
+	config.Password = "opennebula"
+	client, controller, err := NewOpenNebulaConnect(config.OpenNebulaURL, config.Username, config.Password, false)
+	if err != nil {
+		t.Fatalf("connecting to the fake frontend: %s", err)
+	}
+	config.Client = client
+	config.Controller = controller
+
+	config.VMTemplateConfig = VMTemplateConfig{
+		Name:         "packer-test",
+		CPU:          1,
+		VCPU:         2,
+		Memory:       1024,
+		GraphicsType: "VNC",
+	}
+	config.KeepVM = "never"
+	return config
+}
+
+// newTestState returns a fake frontend and the state bag of a build
+// connected to it.
+func newTestState(t *testing.T) (*fakeone.Server, *Config, multistep.StateBag) {
+	srv := fakeone.New(t)
+	config := testConfig(t, srv)
+
+	state := new(multistep.BasicStateBag)
+	state.Put("OpenNebulaController", config.Controller)
+	state.Put("config", config)
+	state.Put("ui", testUi(t))
+	state.Put("debug", false)
+	return srv, config, state
+}
+
+// startVM creates a running VM with the given disks, as StepCreateVM does,
+// and returns it.
+func startVM(t *testing.T, config *Config, state multistep.StateBag, imageIDs ...int) *vm.VM {
+	state.Put("ImageIDs", imageIDs)
+	step := &StepCreateVM{
+		VMTemplateConfig:  config.VMTemplateConfig,
+		OpenNebulaConnect: config.OpenNebulaConnect,
+		KeepVM:            config.KeepVM,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("creating the VM: %s", action)
+	}
+	return state.Get("VM_Info").(*vm.VM)
+}
+
+// diskImages returns the image IDs of the disks of the VM, by type.
+func diskImages(v fakeone.VM) map[string][]int {
+	disks := map[string][]int{}
+	for _, disk := range v.Template.GetVectors("DISK") {
+		diskType, _ := disk.GetStr("TYPE")
+		imageID, _ := disk.GetInt("IMAGE_ID")
+		disks[diskType] = append(disks[diskType], imageID)
+	}
+	return disks
+}
@@ -0,0 +1,123 @@
+//go:generate packer-sdc struct-markdown
+package opennebula
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// OutputConfig holds the ownership and permissions given to the images and
+// VM templates a build or a post-processor creates.
+type OutputConfig struct {
+	// User (name or ID) that owns the created images and templates.
+	OutputOwner string `mapstructure:"output_owner"`
+	// Group (name or ID) of the created images and templates.
+	OutputGroup string `mapstructure:"output_group"`
+	// Octal permissions of the created images and templates, like `chmod`,
+	// e.g. `644`.
+	OutputPermissions string `mapstructure:"output_permissions"`
+}
+
+func (c *OutputConfig) Prepare() []error {
+	var errs []error
+	if c.OutputPermissions != "" {
+		if _, err := parsePermissions(c.OutputPermissions); err != nil {
+			errs = append(errs, fmt.Errorf("output_permissions: %s", err))
+		}
+	}
+	return errs
+}
+
+// IsSet reports whether an owner, group or permissions have been configured.
+func (c *OutputConfig) IsSet() bool {
+	return c.OutputOwner != "" || c.OutputGroup != "" || c.OutputPermissions != ""
+}
+
+// Apply sets the configured owner, group and permissions of the images and
+// templates.
+func (c *OutputConfig) Apply(controller *goca.Controller, ui packersdk.Ui, imageIDs, templateIDs []int) error {
+	if !c.IsSet() {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	var err error
+	if c.OutputOwner != "" {
+		// The owner may be given either by ID or by name
+		if uid, err = strconv.Atoi(c.OutputOwner); err != nil {
+			if uid, err = controller.Users().ByName(c.OutputOwner); err != nil {
+				return fmt.Errorf("Error getting user '%s': %s", c.OutputOwner, err)
+			}
+		}
+	}
+	if c.OutputGroup != "" {
+		if gid, err = strconv.Atoi(c.OutputGroup); err != nil {
+			if gid, err = controller.Groups().ByName(c.OutputGroup); err != nil {
+				return fmt.Errorf("Error getting group '%s': %s", c.OutputGroup, err)
+			}
+		}
+	}
+
+	var perm shared.Permissions
+	if c.OutputPermissions != "" {
+		if perm, err = parsePermissions(c.OutputPermissions); err != nil {
+			return fmt.Errorf("Invalid output permissions: %s", err)
+		}
+	}
+
+	for _, imageID := range imageIDs {
+		ui.Say(fmt.Sprintf("Setting ownership and permissions of image ID %d...", imageID))
+		if uid != -1 || gid != -1 {
+			if err := controller.Image(imageID).Chown(uid, gid); err != nil {
+				return fmt.Errorf("Failed to change owner of image ID %d: %s", imageID, err)
+			}
+		}
+		if c.OutputPermissions != "" {
+			if err := controller.Image(imageID).Chmod(perm); err != nil {
+				return fmt.Errorf("Failed to change permissions of image ID %d: %s", imageID, err)
+			}
+		}
+	}
+
+	for _, templateID := range templateIDs {
+		ui.Say(fmt.Sprintf("Setting ownership and permissions of template ID %d...", templateID))
+		if uid != -1 || gid != -1 {
+			if err := controller.Template(templateID).Chown(uid, gid); err != nil {
+				return fmt.Errorf("Failed to change owner of template ID %d: %s", templateID, err)
+			}
+		}
+		if c.OutputPermissions != "" {
+			if err := controller.Template(templateID).Chmod(perm); err != nil {
+				return fmt.Errorf("Failed to change permissions of template ID %d: %s", templateID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parsePermissions converts octal permissions like "644" into OpenNebula
+// permissions, where 4 is USE, 2 is MANAGE and 1 is ADMIN.
+func parsePermissions(octal string) (shared.Permissions, error) {
+	if len(octal) != 3 {
+		return shared.Permissions{}, fmt.Errorf("expected 3 octal digits, got %q", octal)
+	}
+
+	var bits [3]int8
+	for i, c := range octal {
+		if c < '0' || c > '7' {
+			return shared.Permissions{}, fmt.Errorf("invalid octal digit %q in %q", c, octal)
+		}
+		bits[i] = int8(c - '0')
+	}
+
+	return shared.Permissions{
+		OwnerU: bits[0] >> 2 & 1, OwnerM: bits[0] >> 1 & 1, OwnerA: bits[0] & 1,
+		GroupU: bits[1] >> 2 & 1, GroupM: bits[1] >> 1 & 1, GroupA: bits[1] & 1,
+		OtherU: bits[2] >> 2 & 1, OtherM: bits[2] >> 1 & 1, OtherA: bits[2] & 1,
+	}, nil
+}
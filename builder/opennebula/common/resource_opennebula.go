@@ -1,11 +1,13 @@
 package opennebula
 
 import (
+	"encoding/base64"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
@@ -15,6 +17,9 @@ const (
 	defaultTimeout    = time.Duration(defaultMinTimeout) * time.Minute
 )
 
+// pollInterval is the delay between two checks of the state of a resource.
+var pollInterval = 10 * time.Second
+
 // GetVMState возвращает текущее состояние виртуальной машины.
 func GetVMState(ID int, state multistep.StateBag) (string, string, error) {
 	vmInfos, err := state.Get("OpenNebulaController").(*goca.Controller).VM(ID).Info(false)
@@ -89,38 +94,119 @@ func WaitForResourceState(ID int, desiredState string, resourceType string, stat
 				return nil
 			}
 
+		case "marketapp":
+			appInfos, err := state.Get("OpenNebulaController").(*goca.Controller).MarketPlaceApp(ID).Info(false)
+			if err != nil {
+				return err
+			}
+
+			appState, err := appInfos.State()
+			if err != nil {
+				return err
+			}
+
+			ui.Say(fmt.Sprintf("%s (ID:%d, name:%s) is currently in state %s", resourceType, appInfos.ID, appInfos.Name, appState))
+
+			if appState.String() == desiredState {
+				return nil
+			}
+			if appState == marketplaceapp.Error {
+				return fmt.Errorf("%s (ID:%d) entered state %s", resourceType, appInfos.ID, appState)
+			}
+
 		default:
 			return fmt.Errorf("Unsupported resource type: %s", resourceType)
 		}
 
-		time.Sleep(10 * time.Second) // Подождать перед следующей попыткой
+		time.Sleep(pollInterval) // Подождать перед следующей попыткой
 	}
 }
 
 // CloneImage клонирует указанный образ в OpenNebula и возвращает ID нового.
+// ID возвращается и при ошибке ожидания, чтобы образ можно было удалить.
 func CloneImage(sourceImageID int, targetImageName string, targetDatastoreID int, state multistep.StateBag) (int, error) {
 	ui := state.Get("ui").(packersdk.Ui)
 
 	// Получение контроллера OpenNebula из состояния
 	controller, ok := state.Get("OpenNebulaController").(*goca.Controller)
 	if !ok {
-		return 0, fmt.Errorf("Failed to convert OpenNebulaController to *goca.Controller")
+		return -1, fmt.Errorf("Failed to convert OpenNebulaController to *goca.Controller")
 	}
 
 	// Метод Clone() контроллера образа OpenNebula
 	cloneID, err := controller.Image(sourceImageID).Clone(targetImageName, targetDatastoreID)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Error cloning image ID %d: %s", sourceImageID, err))
-		return 0, err
+		return -1, err
 	}
 
 	// Ожидание завершения клонирования
 	err = WaitForResourceState(cloneID, "READY", "image", state, defaultTimeout)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Error waiting for cloned image to become READY: %s", err))
-		return 0, err
+		return cloneID, err
 	}
 
 	ui.Say(fmt.Sprintf("Image cloned successfully. New Image ID: %d", cloneID))
 	return cloneID, nil
 }
+
+// templateEscaper escapes a value to be written between the double quotes of
+// a template attribute. goca's templates escape quotes before backslashes,
+// which doubles the backslash of every escaped quote.
+var templateEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// ExportMarketplaceApp exports a Marketplace appliance into the given datastore
+// as a new image and returns its ID once the image is READY. The ID is -1
+// if no image was created.
+func ExportMarketplaceApp(appID int, targetImageName string, targetDatastoreID int, state multistep.StateBag) (int, error) {
+	ui := state.Get("ui").(packersdk.Ui)
+
+	controller, ok := state.Get("OpenNebulaController").(*goca.Controller)
+	if !ok {
+		return -1, fmt.Errorf("Failed to convert OpenNebulaController to *goca.Controller")
+	}
+
+	app, err := controller.MarketPlaceApp(appID).Info(false)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error getting Marketplace appliance ID %d: %s", appID, err))
+		return -1, err
+	}
+
+	appState, err := app.State()
+	if err != nil {
+		return -1, err
+	}
+	if appState != marketplaceapp.Ready {
+		return -1, fmt.Errorf("Marketplace appliance %d (%s) is in state %s, expected READY", app.ID, app.Name, appState)
+	}
+
+	if targetImageName == "" {
+		targetImageName = app.Name
+	}
+
+	// Same as `onemarketapp export`: the image template shipped with the
+	// appliance, plus the image name and a reference to the appliance.
+	appTemplate, err := base64.StdEncoding.DecodeString(app.AppTemplate64)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to decode template of Marketplace appliance %d: %s", app.ID, err)
+	}
+	tpl := fmt.Sprintf("%s\nNAME=\"%s\"\nFROM_APP=\"%d\"\n", appTemplate, templateEscaper.Replace(targetImageName), app.ID)
+
+	ui.Say(fmt.Sprintf("Exporting Marketplace appliance %d (%s) to datastore %d...", app.ID, app.Name, targetDatastoreID))
+	imageID, err := controller.Images().Create(tpl, uint(targetDatastoreID))
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error exporting Marketplace appliance ID %d: %s", app.ID, err))
+		return -1, err
+	}
+
+	// Appliances are downloaded from the marketplace, which may take a while
+	err = WaitForResourceState(imageID, "READY", "image", state, defaultTimeout)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error waiting for exported image to become READY: %s", err))
+		return imageID, err
+	}
+
+	ui.Say(fmt.Sprintf("Marketplace appliance exported successfully. New Image ID: %d", imageID))
+	return imageID, nil
+}
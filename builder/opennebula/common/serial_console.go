@@ -0,0 +1,140 @@
+package opennebula
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+)
+
+// serialConsoleRaw returns the RAW libvirt data exposing the first serial
+// port of the VM as a raw TCP socket on listen:port of the host.
+func serialConsoleRaw(listen string, port int) string {
+	return fmt.Sprintf("<devices><serial type='tcp'><source mode='bind' host='%s' service='%d'/>"+
+		"<protocol type='raw'/><target port='0'/></serial></devices>", listen, port)
+}
+
+// serialSequences maps the special keys of the boot command to the bytes a
+// terminal sends for them.
+var serialSequences = map[string]string{
+	"bs":       "\x7f",
+	"del":      "\x1b[3~",
+	"down":     "\x1b[B",
+	"end":      "\x1b[F",
+	"enter":    "\r",
+	"esc":      "\x1b",
+	"f1":       "\x1bOP",
+	"f2":       "\x1bOQ",
+	"f3":       "\x1bOR",
+	"f4":       "\x1bOS",
+	"f5":       "\x1b[15~",
+	"f6":       "\x1b[17~",
+	"f7":       "\x1b[18~",
+	"f8":       "\x1b[19~",
+	"f9":       "\x1b[20~",
+	"f10":      "\x1b[21~",
+	"f11":      "\x1b[23~",
+	"f12":      "\x1b[24~",
+	"home":     "\x1b[H",
+	"insert":   "\x1b[2~",
+	"left":     "\x1b[D",
+	"pagedown": "\x1b[6~",
+	"pageup":   "\x1b[5~",
+	"return":   "\r",
+	"right":    "\x1b[C",
+	"spacebar": " ",
+	"tab":      "\t",
+	"up":       "\x1b[A",
+}
+
+// serialDriver types the boot command as text on a serial console. Control
+// and alt held with a key produce the control character and the escape
+// prefix a terminal would send; other modifiers are ignored.
+type serialDriver struct {
+	w        io.Writer
+	interval time.Duration
+	ctrl     bool
+	alt      bool
+}
+
+var _ bootcommand.BCDriver = (*serialDriver)(nil)
+
+func newSerialDriver(w io.Writer, interval time.Duration) *serialDriver {
+	if interval == 0 {
+		interval = 10 * time.Millisecond
+	}
+	return &serialDriver{w: w, interval: interval}
+}
+
+func (d *serialDriver) SendKey(key rune, action bootcommand.KeyAction) error {
+	if action&(bootcommand.KeyOn|bootcommand.KeyPress) == 0 {
+		return nil
+	}
+
+	var b []byte
+	if d.alt {
+		b = append(b, 0x1b)
+	}
+	if d.ctrl && key < utf8.RuneSelf {
+		b = append(b, byte(key)&0x1f)
+	} else {
+		b = utf8.AppendRune(b, key)
+	}
+	return d.write(b)
+}
+
+func (d *serialDriver) SendSpecial(special string, action bootcommand.KeyAction) error {
+	special = strings.ToLower(special)
+	switch special {
+	case "leftctrl", "rightctrl":
+		d.ctrl = action != bootcommand.KeyOff
+		return nil
+	case "leftalt", "rightalt":
+		d.alt = action != bootcommand.KeyOff
+		return nil
+	case "leftshift", "rightshift", "leftsuper", "rightsuper", "menu":
+		return nil
+	}
+
+	if action == bootcommand.KeyOff {
+		return nil
+	}
+	seq, ok := serialSequences[special]
+	if !ok {
+		return fmt.Errorf("special %s not supported on the serial console", special)
+	}
+	return d.write([]byte(seq))
+}
+
+func (d *serialDriver) Flush() error { return nil }
+
+func (d *serialDriver) write(b []byte) error {
+	if _, err := d.w.Write(b); err != nil {
+		return err
+	}
+	time.Sleep(d.interval)
+	return nil
+}
+
+// logSerialConsole copies the output of the console to path until conn is
+// closed.
+func logSerialConsole(conn net.Conn, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer f.Close()
+		if _, err := io.Copy(f, conn); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("[WARN] Failed to log the serial console: %s", err)
+		}
+	}()
+	return nil
+}
@@ -2,22 +2,41 @@ package opennebula
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/rand"
 	"fmt"
+	"log"
+	"net"
 	"strconv"
 	"time"
 
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
 	vmk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm/keys"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
 type StepCreateVM struct {
 	//config Config
 	VMTemplateConfig  VMTemplateConfig
 	OpenNebulaConnect OpenNebulaConnect
+	// KeepVM is one of "always", "on_failure" or "never".
+	KeepVM string
+	// OnError is the value of the -on-error flag.
+	OnError string
+	// VNCPassword is set as GRAPHICS/PASSWD. When empty and EnableVNC is
+	// set, a random password is generated.
+	VNCPassword string
+	// SerialPort, when set, exposes the serial console of the VM as a raw
+	// TCP socket on this port of the host, listening on SerialListen. The
+	// boot command is then typed on it, so the VM needs no VNC console.
+	SerialPort   int
+	SerialListen string
+	// GeneratedData receives the build variables describing the VM.
+	GeneratedData *packerbuilderdata.GeneratedData
 }
 
 func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -30,41 +49,52 @@ func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multis
 		return multistep.ActionHalt
 	}
 
-	tpl := vm.NewTemplate()
-
-	tpl.Add(vmk.Name, s.VMTemplateConfig.Name)
-	tpl.CPU(s.VMTemplateConfig.CPU)
-	tpl.Memory(s.VMTemplateConfig.Memory)
-	tpl.VCPU(s.VMTemplateConfig.VCPU)
-	tpl.CPUModel(s.VMTemplateConfig.CPUModel)
-
-	// Add disks based on the provided image IDs or names
-	for _, imageID := range imageIDs {
-		disk := tpl.AddDisk()
-		disk.Add(shared.ImageID, imageID)
-		// Add other disk-related configurations if needed
+	vncPassword := s.VNCPassword
+	if vncPassword == "" && s.VMTemplateConfig.EnableVNC {
+		var err error
+		vncPassword, err = randomVNCPassword()
+		if err != nil {
+			ui.Error(fmt.Sprintf("Failed to generate VNC password: %s", err))
+			return multistep.ActionHalt
+		}
 	}
-
-	tpl.AddIOGraphic(vmk.GraphicType, s.VMTemplateConfig.GraphicsType)
-	tpl.AddIOGraphic(vmk.Keymap, s.VMTemplateConfig.GraphicsKeymap)
-	tpl.AddIOGraphic(vmk.Listen, s.VMTemplateConfig.GraphicsListen)
-
-	for _, nicConf := range s.VMTemplateConfig.NICs {
-		nic := tpl.AddNIC()
-		nic.Add(shared.Network, nicConf.Network)
+	if vncPassword != "" {
+		packersdk.LogSecretFilter.Set(vncPassword)
+		state.Put("vncPassword", vncPassword)
 	}
 
-	tpl.AddCtx(vmk.SetHostname, "$NAME")
-	tpl.AddCtx(vmk.SSHPubKey, "$USER[SSH_PUBLIC_KEY]")
-	tpl.AddCtx(vmk.NetworkCtx, "YES")
-	tpl.AddCtx("USER_DATA", base64.StdEncoding.EncodeToString([]byte(s.VMTemplateConfig.UserData)))
-	tpl.AddCtx("USER_DATA_ENCODING", "base64")
-	tpl.AddCtx("AUTOSTART", "true")
-	tpl.AddOS(vmk.Arch, s.VMTemplateConfig.OSArch)
-	tpl.AddOS(vmk.Boot, s.VMTemplateConfig.OSBoot)
+	params := vmTemplateParams{
+		ImageIDs:      imageIDs,
+		VNCPassword:   vncPassword,
+		SerialPort:    s.SerialPort,
+		SerialListen:  s.SerialListen,
+		KernelImageID: -1,
+		InitrdImageID: -1,
+	}
+	if kernelID, ok := state.GetOk("kernelImageID"); ok {
+		params.KernelImageID = kernelID.(int)
+		if initrdID, ok := state.GetOk("initrdImageID"); ok {
+			params.InitrdImageID = initrdID.(int)
+		}
+		if s.VMTemplateConfig.OSKernelCmd != "" {
+			kernelCmd, err := s.renderKernelCmd(state)
+			if err != nil {
+				ui.Error(fmt.Sprintf("Failed to render vm_os_kernel_cmd: %s", err))
+				return multistep.ActionHalt
+			}
+			params.KernelCmd = kernelCmd
+		}
+	}
+	tpl := s.VMTemplateConfig.template(params)
 
 	controller := s.OpenNebulaConnect.Controller
-	//ui.Say(tpl.String())
+
+	// The VNC password is left out of the logged template
+	logged := params
+	if logged.VNCPassword != "" {
+		logged.VNCPassword = "<sensitive>"
+	}
+	log.Printf("[DEBUG] OpenNebula VM template:\n%s", s.VMTemplateConfig.template(logged).String())
 
 	vmID, err := controller.VMs().Create(tpl.String(), false)
 	if err != nil {
@@ -88,26 +118,96 @@ func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multis
 	}
 	//ui.Say(fmt.Sprintf("VM Info: %s", vm))
 	state.Put("VM_Info", vm)
+	s.putGeneratedData(vm, imageIDs[0])
 
 	vncPortStr, err := vm.Template.GetIOGraphic(vmk.Port)
-	if err != nil {
-		ui.Error(fmt.Sprintf("Failed to get VNC port: %s", err))
-		return multistep.ActionHalt
+	if _, kernelBoot := state.GetOk("kernelImageID"); err != nil && (kernelBoot || s.SerialPort != 0) {
+		// Nothing is typed over VNC into a VM booting a kernel directly or
+		// typed into over its serial console
+		ui.Say("OpenNebula VM has no VNC console.")
+	} else {
+		if err != nil {
+			ui.Error(fmt.Sprintf("Failed to get VNC port: %s", err))
+			return multistep.ActionHalt
+		}
+
+		vncPort, err := strconv.Atoi(vncPortStr)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Failed to convert VNC port to int: %s", err))
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("OpenNebula VM VncPort: %d", vncPort))
+		state.Put("vncPort", vncPort)
 	}
 
-	vncPort, err := strconv.Atoi(vncPortStr)
-	if err != nil {
-		ui.Error(fmt.Sprintf("Failed to convert VNC port to int: %s", err))
-		return multistep.ActionHalt
+	// OpenNebula may set the password itself, e.g. with RANDOM_PASSWD
+	if _, ok := state.GetOk("vncPassword"); !ok {
+		if passwd, err := vm.Template.GetIOGraphic(vmk.Passwd); err == nil && passwd != "" {
+			packersdk.LogSecretFilter.Set(passwd)
+			state.Put("vncPassword", passwd)
+		}
 	}
-	ui.Say(fmt.Sprintf("OpenNebula VM VncPort: %d", vncPort))
-	state.Put("vncPort", vncPort)
 
 	ui.Say("OpenNebula VM is now running.")
 
 	return multistep.ActionContinue
 }
 
+// putGeneratedData exposes the VM, where it runs and the image it was
+// created from as build variables.
+func (s *StepCreateVM) putGeneratedData(vmInfo *vm.VM, sourceImageID int) {
+	if s.GeneratedData == nil {
+		return
+	}
+	s.GeneratedData.Put("OpenNebulaVMID", vmInfo.ID)
+	if len(vmInfo.HistoryRecords) > 0 {
+		record := vmInfo.HistoryRecords[len(vmInfo.HistoryRecords)-1]
+		s.GeneratedData.Put("OpenNebulaHost", record.Hostname)
+		s.GeneratedData.Put("ClusterID", record.CID)
+	}
+	if nics := vmInfo.Template.GetNICs(); len(nics) > 0 {
+		if ip, err := nics[0].Get(shared.IP); err == nil {
+			s.GeneratedData.Put("VMIP", ip)
+		}
+	}
+	s.GeneratedData.Put("SourceImageID", sourceImageID)
+	if img, err := s.OpenNebulaConnect.Controller.Image(sourceImageID).Info(false); err == nil {
+		s.GeneratedData.Put("SourceImageName", img.Name)
+	}
+}
+
+// renderKernelCmd interpolates the kernel command line with the address of
+// the HTTP server of the build.
+func (s *StepCreateVM) renderKernelCmd(state multistep.StateBag) (string, error) {
+	c := state.Get("config").(*Config)
+	httpIP, err := resolveHTTPIP(c)
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine host IP: %s", err)
+	}
+	httpPort, _ := state.Get("http_port").(int)
+
+	ctx := c.Ctx
+	ctx.Data = map[string]interface{}{
+		"HTTPIP":   httpIP,
+		"HTTPPort": httpPort,
+	}
+	return interpolate.Render(s.VMTemplateConfig.OSKernelCmd, &ctx)
+}
+
+// randomVNCPassword returns a random password of 8 characters, the most the
+// VNC authentication uses.
+func randomVNCPassword() (string, error) {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b), nil
+}
+
 func (s *StepCreateVM) Cleanup(state multistep.StateBag) {
 	ui := state.Get("ui").(packersdk.Ui)
 	ui.Say("Cleaning up OpenNebula VM...")
@@ -123,9 +223,15 @@ func (s *StepCreateVM) Cleanup(state multistep.StateBag) {
 		return
 	}
 
+	controller := s.OpenNebulaConnect.Controller
+
+	if s.shouldKeepVM(state) {
+		s.keepVM(vmID, state)
+		return
+	}
+
 	ui.Say(fmt.Sprintf("Deleting OpenNebula VM with ID: %d", vmID))
 
-	controller := s.OpenNebulaConnect.Controller
 	err := controller.VM(vmID).TerminateHard()
 	if err != nil {
 		ui.Error(fmt.Sprintf("Error deleting the OpenNebula VM: %s", err))
@@ -139,3 +245,52 @@ func (s *StepCreateVM) Cleanup(state multistep.StateBag) {
 		ui.Say("OpenNebula VM deleted successfully.")
 	}
 }
+
+// shouldKeepVM reports whether the VM is left in place for debugging.
+func (s *StepCreateVM) shouldKeepVM(state multistep.StateBag) bool {
+	_, halted := state.GetOk(multistep.StateHalted)
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, errored := state.GetOk("error")
+	failed := halted || cancelled || errored
+
+	switch {
+	case s.KeepVM == "always":
+		return true
+	case s.KeepVM == "on_failure" && failed:
+		return true
+	case failed && (s.OnError == "abort" || s.OnError == "ask"):
+		// With "ask" the cleanup only runs when the user chose to abort
+		_, aborted := state.GetOk("aborted")
+		return s.OnError == "abort" || aborted
+	}
+	return false
+}
+
+// keepVM labels the VM and prints how to reach it instead of terminating it.
+func (s *StepCreateVM) keepVM(vmID int, state multistep.StateBag) {
+	ui := state.Get("ui").(packersdk.Ui)
+	controller := s.OpenNebulaConnect.Controller
+
+	err := controller.VM(vmID).Update(`LABELS="packer/kept"`, parameters.Merge)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to label the OpenNebula VM: %s", err))
+	}
+	state.Put("vmKept", true)
+
+	ui.Say(fmt.Sprintf("Keeping OpenNebula VM with ID: %d", vmID))
+
+	vmInfo, err := controller.VM(vmID).Info(false)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to fetch VM information: %s", err))
+		return
+	}
+	vmState, lcmState, _ := vmInfo.StateString()
+	ui.Message(fmt.Sprintf("State: %s/%s", vmState, lcmState))
+	if len(vmInfo.HistoryRecords) > 0 {
+		host := vmInfo.HistoryRecords[len(vmInfo.HistoryRecords)-1].Hostname
+		ui.Message(fmt.Sprintf("Host: %s", host))
+		if vncPort, ok := state.Get("vncPort").(int); ok {
+			ui.Message(fmt.Sprintf("VNC: %s", net.JoinHostPort(host, strconv.Itoa(vncPort))))
+		}
+	}
+}
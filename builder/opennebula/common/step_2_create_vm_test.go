@@ -0,0 +1,212 @@
+package opennebula
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+func TestStepCreateVM(t *testing.T) {
+	srv, config, state := newTestState(t)
+	imageID := srv.AddImage("ubuntu", "OS", 1)
+	state.Put("ImageIDs", []int{imageID})
+
+	config.VMTemplateConfig.EnableVNC = true
+	config.VMTemplateConfig.UserData = "#cloud-config\n"
+	config.VMTemplateConfig.NICs = []NICConfig{{Network: "public"}}
+	step := &StepCreateVM{
+		VMTemplateConfig:  config.VMTemplateConfig,
+		OpenNebulaConnect: config.OpenNebulaConnect,
+		KeepVM:            "never",
+		GeneratedData:     &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	vmID := state.Get("vmID").(int)
+	v, _ := srv.VM(vmID)
+	if v.Name != "packer-test" || v.State != vm.Active || v.LCMState != vm.Running {
+		t.Fatalf("VM %q is %s/%s, expected packer-test to be RUNNING", v.Name, v.State, v.LCMState)
+	}
+	if cpu, _ := v.Template.GetFloat("CPU"); cpu != 1 {
+		t.Errorf("CPU = %v, expected 1", cpu)
+	}
+	if memory, _ := v.Template.GetInt("MEMORY"); memory != 1024 {
+		t.Errorf("MEMORY = %d, expected 1024", memory)
+	}
+	if disks := diskImages(v); len(disks["FILE"]) != 1 || disks["FILE"][0] != imageID {
+		t.Errorf("disks = %v, expected image %d", disks, imageID)
+	}
+	if network, _ := v.Template.GetStrFromVec("NIC", "NETWORK"); network != "public" {
+		t.Errorf("NIC network = %q, expected public", network)
+	}
+	userData, _ := v.Template.GetStrFromVec("CONTEXT", "USER_DATA")
+	if decoded, _ := base64.StdEncoding.DecodeString(userData); string(decoded) != "#cloud-config\n" {
+		t.Errorf("USER_DATA = %q", decoded)
+	}
+
+	if port := state.Get("vncPort").(int); port != 5900+vmID {
+		t.Errorf("vncPort = %d, expected %d", port, 5900+vmID)
+	}
+	password := state.Get("vncPassword").(string)
+	if len(password) != 8 {
+		t.Errorf("generated VNC password %q, expected 8 characters", password)
+	}
+	if passwd, _ := v.Template.GetStrFromVec("GRAPHICS", "PASSWD"); passwd != password {
+		t.Errorf("GRAPHICS/PASSWD = %q, expected the generated password", passwd)
+	}
+
+	generated := state.Get("generated_data").(map[string]interface{})
+	expected := map[string]interface{}{
+		"OpenNebulaVMID":  vmID,
+		"OpenNebulaHost":  fakeone.Host,
+		"ClusterID":       fakeone.ClusterID,
+		"SourceImageID":   imageID,
+		"SourceImageName": "ubuntu",
+		"VMIP":            "10.0.0.2",
+	}
+	for key, value := range expected {
+		if generated[key] != value {
+			t.Errorf("generated %s = %v, expected %v", key, generated[key], value)
+		}
+	}
+
+	step.Cleanup(state)
+	v, _ = srv.VM(vmID)
+	if v.State != vm.Done {
+		t.Errorf("VM is %s after cleanup, expected DONE", v.State)
+	}
+}
+
+func TestStepCreateVM_keepVM(t *testing.T) {
+	srv, config, state := newTestState(t)
+	v := startVM(t, config, state, srv.AddImage("ubuntu", "OS", 1))
+	state.Put("error", context.Canceled)
+
+	step := &StepCreateVM{OpenNebulaConnect: config.OpenNebulaConnect, KeepVM: "on_failure"}
+	step.Cleanup(state)
+
+	kept, _ := srv.VM(v.ID)
+	if kept.State != vm.Active {
+		t.Errorf("kept VM is %s, expected it to keep running", kept.State)
+	}
+	if labels, _ := kept.UserTemplate.GetStr("LABELS"); labels != "packer/kept" {
+		t.Errorf("LABELS = %q, expected packer/kept", labels)
+	}
+	if kept, _ := state.Get("vmKept").(bool); !kept {
+		t.Error("vmKept is not set")
+	}
+	if actions := srv.Actions(v.ID); len(actions) != 0 {
+		t.Errorf("actions %v on the kept VM", actions)
+	}
+}
+
+func TestStepCreateVM_allocateError(t *testing.T) {
+	srv, config, state := newTestState(t)
+	state.Put("ImageIDs", []int{srv.AddImage("ubuntu", "OS", 1)})
+	srv.FailNext("one.vm.allocate", "quota exceeded")
+
+	step := &StepCreateVM{VMTemplateConfig: config.VMTemplateConfig, OpenNebulaConnect: config.OpenNebulaConnect}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if vms := srv.VMs(); len(vms) != 0 {
+		t.Errorf("%d VMs created", len(vms))
+	}
+}
+
+func TestStepCreateVM_kernel(t *testing.T) {
+	srv, config, state := newTestState(t)
+	kernelID := srv.AddImage("vmlinuz", "KERNEL", 2)
+	initrdID := srv.AddImage("initrd", "RAMDISK", 2)
+	state.Put("ImageIDs", []int{srv.AddImage("disk", "DATABLOCK", 1)})
+	state.Put("kernelImageID", kernelID)
+	state.Put("initrdImageID", initrdID)
+	state.Put("http_port", 8100)
+
+	config.HTTPAddress = "192.0.2.10"
+	config.VMTemplateConfig.OSKernelCmd = "ds=nocloud-net;s=http://{{ .HTTPIP }}:{{ .HTTPPort }}/"
+	step := &StepCreateVM{VMTemplateConfig: config.VMTemplateConfig, OpenNebulaConnect: config.OpenNebulaConnect}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	v, _ := srv.VM(state.Get("vmID").(int))
+	expected := map[string]string{
+		"KERNEL_DS":  fmt.Sprintf("$FILE[IMAGE_ID=%d]", kernelID),
+		"INITRD_DS":  fmt.Sprintf("$FILE[IMAGE_ID=%d]", initrdID),
+		"KERNEL_CMD": "ds=nocloud-net;s=http://192.0.2.10:8100/",
+	}
+	for key, value := range expected {
+		if got, _ := v.Template.GetStrFromVec("OS", key); got != value {
+			t.Errorf("OS/%s = %q, expected %q", key, got, value)
+		}
+	}
+}
+
+// A VM typed into over its serial console needs no VNC console.
+func TestStepCreateVM_serialConsole(t *testing.T) {
+	srv, config, state := newTestState(t)
+	state.Put("ImageIDs", []int{srv.AddImage("ubuntu", "OS", 1)})
+
+	config.VMTemplateConfig.GraphicsType = ""
+	step := &StepCreateVM{
+		VMTemplateConfig:  config.VMTemplateConfig,
+		OpenNebulaConnect: config.OpenNebulaConnect,
+		SerialPort:        4555,
+		SerialListen:      "192.0.2.20",
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	if port, ok := state.GetOk("vncPort"); ok {
+		t.Errorf("vncPort = %v, expected none", port)
+	}
+	v, _ := srv.VM(state.Get("vmID").(int))
+	if data, _ := v.Template.GetStrFromVec("RAW", "DATA"); data != serialConsoleRaw("192.0.2.20", 4555) {
+		t.Errorf("RAW/DATA = %q, expected the serial console", data)
+	}
+}
+
+func TestStepCreateVM_shouldKeepVM(t *testing.T) {
+	tests := []struct {
+		keepVM  string
+		onError string
+		failed  bool
+		aborted bool
+		keep    bool
+	}{
+		{"never", "cleanup", false, false, false},
+		{"never", "cleanup", true, false, false},
+		{"always", "cleanup", false, false, true},
+		{"on_failure", "cleanup", false, false, false},
+		{"on_failure", "cleanup", true, false, true},
+		{"never", "abort", true, false, true},
+		{"never", "abort", false, false, false},
+		{"never", "ask", true, false, false},
+		{"never", "ask", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s/failed=%t/aborted=%t", tt.keepVM, tt.onError, tt.failed, tt.aborted), func(t *testing.T) {
+			state := new(multistep.BasicStateBag)
+			if tt.failed {
+				state.Put(multistep.StateHalted, true)
+			}
+			if tt.aborted {
+				state.Put("aborted", true)
+			}
+			step := &StepCreateVM{KeepVM: tt.keepVM, OnError: tt.onError}
+			if keep := step.shouldKeepVM(state); keep != tt.keep {
+				t.Errorf("shouldKeepVM = %t, expected %t", keep, tt.keep)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -74,6 +75,13 @@ func (s *StepCloneDisk) Run(ctx context.Context, state multistep.StateBag) multi
 				ui.Error(fmt.Sprintf("Error waiting for the image to become READY: %s", err))
 				return multistep.ActionHalt
 			}
+			if family := config.SnapshotConfig.Snapshot_Family; family != "" {
+				err = controller.Image(cloneID).Update(fmt.Sprintf("%s=\"%s\"", imageFamilyAttr, family), parameters.Merge)
+				if err != nil {
+					ui.Error(fmt.Sprintf("Failed to set the family of image ID %d: %s", cloneID, err))
+					return multistep.ActionHalt
+				}
+			}
 			state.Put("ClonedDiskIDs", append(state.Get("ClonedDiskIDs").([]int), cloneID))
 		}
 	}
@@ -0,0 +1,55 @@
+package opennebula
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepCloneDisk(t *testing.T) {
+	srv, config, state := newTestState(t)
+	v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1), srv.AddImage("installer", "CDROM", 1))
+	srv.SetVMState(v.ID, vm.Poweroff, vm.LcmInit)
+
+	config.SnapshotConfig.Snapshot_Name = "ubuntu-22.04"
+	config.SnapshotConfig.Snapshot_Family = "ubuntu"
+	step := &StepCloneDisk{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	// The installer is not saved
+	cloned := state.Get("ClonedDiskIDs").([]int)
+	if len(cloned) != 1 {
+		t.Fatalf("ClonedDiskIDs = %v, expected the disk only", cloned)
+	}
+	img, _ := srv.Image(cloned[0])
+	if img.Name != "ubuntu-22.04" || img.State != image.Ready {
+		t.Errorf("saved image %q is %s, expected ubuntu-22.04 to be READY", img.Name, img.State)
+	}
+	if family, _ := img.Template.GetStr(imageFamilyAttr); family != "ubuntu" {
+		t.Errorf("%s = %q, expected ubuntu", imageFamilyAttr, family)
+	}
+	if saved, _ := srv.VM(v.ID); saved.State != vm.Poweroff {
+		t.Errorf("VM is %s after saving, expected POWEROFF", saved.State)
+	}
+}
+
+func TestStepCloneDisk_saveasError(t *testing.T) {
+	srv, config, state := newTestState(t)
+	v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1))
+	srv.SetVMState(v.ID, vm.Poweroff, vm.LcmInit)
+	srv.FailNext("one.vm.disksaveas", "not enough space in the datastore")
+
+	config.SnapshotConfig.Snapshot_Name = "ubuntu-22.04"
+	step := &StepCloneDisk{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if cloned, _ := state.Get("ClonedDiskIDs").([]int); len(cloned) != 0 {
+		t.Errorf("ClonedDiskIDs = %v, expected none", cloned)
+	}
+}
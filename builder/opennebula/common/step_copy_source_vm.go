@@ -0,0 +1,109 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepCopySourceVM saves the disks of an existing VM as images, which the
+// build VM boots from. A RUNNING VM is copied hot, without taking it down.
+// The copies are deleted during cleanup unless the build VM is kept.
+type StepCopySourceVM struct {
+	VMID int
+
+	imageIDs []int
+}
+
+func (s *StepCopySourceVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+	controller := config.Controller
+
+	if state.Get("ImageIDs") == nil {
+		state.Put("ImageIDs", []int{})
+	}
+
+	vmInfo, err := controller.VM(s.VMID).Info(false)
+	if err != nil {
+		err := fmt.Errorf("Failed to fetch source VM %d: %s", s.VMID, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	vmState, lcmState, err := vmInfo.StateString()
+	if err != nil {
+		err := fmt.Errorf("Failed to get the state of source VM %d: %s", s.VMID, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	ui.Say(fmt.Sprintf("Copying the disks of source VM %d (%s, %s)...", s.VMID, vmState, lcmState))
+
+	for _, disk := range vmInfo.Template.GetDisks() {
+		diskID, _ := disk.GetInt("DISK_ID")
+		diskType, _ := disk.GetStr("TYPE")
+		if diskType == "CDROM" {
+			continue
+		}
+
+		name := fmt.Sprintf("%s-source-%d-disk-%d", config.VMTemplateConfig.Name, s.VMID, diskID)
+		imageID, err := controller.VM(s.VMID).Disk(diskID).Saveas(name, "", -1)
+		if err != nil {
+			err := fmt.Errorf("Failed to copy disk %d of source VM %d: %s", diskID, s.VMID, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		// Delete the copy during cleanup even if it never becomes READY
+		s.imageIDs = append(s.imageIDs, imageID)
+
+		err = WaitForResourceState(imageID, "READY", "image", state, 30*time.Minute)
+		if err != nil {
+			err := fmt.Errorf("Error waiting for the copy of disk %d to become READY: %s", diskID, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Disk %d copied to image ID: %d", diskID, imageID))
+		state.Put("ImageIDs", append(state.Get("ImageIDs").([]int), imageID))
+	}
+
+	if len(state.Get("ImageIDs").([]int)) == 0 {
+		err := fmt.Errorf("Source VM %d has no disk to copy", s.VMID)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepCopySourceVM) Cleanup(state multistep.StateBag) {
+	if len(s.imageIDs) == 0 {
+		return
+	}
+	ui := state.Get("ui").(packersdk.Ui)
+
+	// The copies are still attached to the kept VM
+	if kept, ok := state.Get("vmKept").(bool); ok && kept {
+		ui.Say("Skipping cleanup of the source VM disk copies as the VM is kept.")
+		return
+	}
+
+	ui.Say("Cleaning up the source VM disk copies...")
+	controller := state.Get("config").(*Config).Controller
+	for _, imageID := range s.imageIDs {
+		ui.Say(fmt.Sprintf("Deleting OpenNebula image ID: %d", imageID))
+		err := WaitForResourceState(imageID, "READY", "image", state, 5*time.Minute)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error waiting for the image to become READY: %s", err))
+		}
+		if err := controller.Image(imageID).Delete(); err != nil {
+			ui.Error(fmt.Sprintf("Error deleting image ID %d: %s", imageID, err))
+		}
+	}
+}
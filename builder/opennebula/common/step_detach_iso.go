@@ -3,6 +3,8 @@ package opennebula
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
@@ -10,11 +12,11 @@ import (
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
-// StepDetachISO detaches the currently attached ISO file from a virtual machine if any.
+// StepDetachISO detaches the currently attached ISO files from a virtual machine if any.
 type StepDetachISO struct {
 }
 
-// Run executes the step to detach the ISO file.
+// Run executes the step to detach the ISO files.
 func (s *StepDetachISO) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
 	config := state.Get("config").(*Config)
@@ -45,36 +47,109 @@ func (s *StepDetachISO) Run(ctx context.Context, state multistep.StateBag) multi
 			ui.Error(fmt.Sprintf("Failed to power off VM manually: %s", err))
 			return multistep.ActionHalt
 		}
+		err = WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, 5*time.Minute)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error waiting for the VM to be powered off: %s", err))
+			return multistep.ActionHalt
+		}
+	}
+
+	keep := map[string]bool{}
+	for _, k := range config.EjectISOKeep {
+		keep[k] = true
 	}
 
 	ui.Say("Detaching ISO from the VM...")
 
-	// Detach the ISO file from the VM
-	for disk_ID, disk := range vmInfoRaw.Template.GetDisks() {
+	// Detach the ISO files from the VM, one at a time as OpenNebula only
+	// allows a single hotplug operation per VM.
+	var detachedImageIDs []int
+	for _, disk := range vmInfoRaw.Template.GetDisks() {
 		disk_type, _ := disk.GetStr("TYPE")
-		if disk_type == "CDROM" {
-			err := controller.VM(vmInfoRaw.ID).Disk(disk_ID).Detach()
-			if err != nil {
-				ui.Error(fmt.Sprintf("Failed to detach ISO: %s", err))
-				return multistep.ActionHalt
-			}
-			ui.Say(fmt.Sprintf("ISO %d detached successfully.", disk_ID))
+		if disk_type != "CDROM" {
+			continue
 		}
-	}
 
-	ui.Say("Waiting for the VM to be powered off...")
+		disk_ID, err := disk.GetInt("DISK_ID")
+		if err != nil {
+			ui.Error(fmt.Sprintf("Failed to get DISK_ID of the CDROM: %s", err))
+			return multistep.ActionHalt
+		}
+		image_ID, idErr := disk.GetInt("IMAGE_ID")
+		image_Name, _ := disk.GetStr("IMAGE")
+		if keep[image_Name] || (idErr == nil && keep[strconv.Itoa(image_ID)]) {
+			ui.Say(fmt.Sprintf("Keeping CDROM %d (%s) attached.", disk_ID, image_Name))
+			continue
+		}
 
-	// Wait for the VM to be in POWEROFF state
-	err = WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, 5*time.Minute)
+		err = controller.VM(vmInfoRaw.ID).Disk(disk_ID).Detach()
+		if err != nil {
+			ui.Error(fmt.Sprintf("Failed to detach ISO: %s", err))
+			return multistep.ActionHalt
+		}
+
+		// Wait for the hotplug operation to finish
+		err = WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, 5*time.Minute)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error waiting for the ISO to be detached: %s", err))
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("ISO %d (%s) detached successfully.", disk_ID, image_Name))
+
+		if idErr == nil {
+			detachedImageIDs = append(detachedImageIDs, image_ID)
+		}
+	}
+
+	// Refresh the VM information so later steps see the current disks
+	vmInfo, err := controller.VM(vmInfoRaw.ID).Info(false)
 	if err != nil {
-		ui.Error(fmt.Sprintf("Error waiting for the VM to be powered off: %s", err))
+		ui.Error(fmt.Sprintf("Failed to fetch VM information: %s", err))
 		return multistep.ActionHalt
 	}
+	state.Put("VM_Info", vmInfo)
+
+	if config.EjectISODelete {
+		s.deleteImages(detachedImageIDs, state)
+	}
 
 	ui.Say("ISO detached successfully.")
 	return multistep.ActionContinue
 }
 
+// deleteImages deletes the detached ISO images created by the build, which
+// are then no longer deleted by StepProcessImages. Pre-existing images are
+// left alone.
+func (s *StepDetachISO) deleteImages(imageIDs []int, state multistep.StateBag) {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+
+	for _, imageID := range imageIDs {
+		createdImageIDs, _ := state.Get("CreatedImageIDs").([]int)
+		if !slices.Contains(createdImageIDs, imageID) {
+			ui.Say(fmt.Sprintf("Keeping ISO image ID %d, it was not created by the build.", imageID))
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Deleting ISO image ID: %d", imageID))
+		err := WaitForResourceState(imageID, "READY", "image", state, 5*time.Minute)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error waiting for the image to become READY: %s", err))
+			continue
+		}
+		err = config.Controller.Image(imageID).Delete()
+		if err != nil {
+			// The build can go on with the ISO left in the datastore
+			ui.Error(fmt.Sprintf("Error deleting image ID %d: %s", imageID, err))
+			continue
+		}
+
+		state.Put("CreatedImageIDs", slices.DeleteFunc(slices.Clone(createdImageIDs), func(id int) bool {
+			return id == imageID
+		}))
+	}
+}
+
 // Cleanup performs cleanup tasks if necessary.
 func (s *StepDetachISO) Cleanup(state multistep.StateBag) {
 	// Cleanup, if necessary
@@ -0,0 +1,115 @@
+package opennebula
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepDetachISO_disabled(t *testing.T) {
+	srv, config, state := newTestState(t)
+	startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1), srv.AddImage("installer", "CDROM", 1))
+
+	step := &StepDetachISO{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+	if calls := srv.Calls("one.vm.detach"); len(calls) != 0 {
+		t.Errorf("%d disks detached", len(calls))
+	}
+}
+
+func TestStepDetachISO(t *testing.T) {
+	srv, config, state := newTestState(t)
+	diskID := srv.AddImage("disk", "DATABLOCK", 1)
+	installerID := srv.AddImage("installer", "CDROM", 1)
+	driversID := srv.AddImage("drivers", "CDROM", 1)
+	v := startVM(t, config, state, diskID, installerID, driversID)
+	srv.SetVMState(v.ID, vm.Poweroff, vm.LcmInit)
+	state.Put("CreatedImageIDs", []int{installerID})
+
+	config.EjectISO = true
+	config.EjectISOKeep = []string{"drivers"}
+	config.EjectISODelete = true
+	step := &StepDetachISO{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	detached, _ := srv.VM(v.ID)
+	expected := map[string][]int{"FILE": {diskID}, "CDROM": {driversID}}
+	if disks := diskImages(detached); !reflect.DeepEqual(disks, expected) {
+		t.Errorf("disks = %v, expected %v", disks, expected)
+	}
+	if disks := state.Get("VM_Info").(*vm.VM).Template.GetDisks(); len(disks) != 2 {
+		t.Errorf("VM_Info has %d disks, expected it to be refreshed", len(disks))
+	}
+
+	// The detached ISO is deleted and no longer cleaned up
+	if _, ok := srv.Image(installerID); ok {
+		t.Error("detached ISO not deleted")
+	}
+	if _, ok := srv.Image(driversID); !ok {
+		t.Error("kept ISO deleted")
+	}
+	if created, _ := state.Get("CreatedImageIDs").([]int); len(created) != 0 {
+		t.Errorf("CreatedImageIDs = %v, expected the deleted ISO to be removed", created)
+	}
+}
+
+func TestStepDetachISO_powerOffHard(t *testing.T) {
+	srv, config, state := newTestState(t)
+	v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1), srv.AddImage("installer", "CDROM", 1))
+
+	// The guest never shuts itself down
+	config.EjectISO = true
+	config.EjectISODelay = time.Nanosecond
+	step := &StepDetachISO{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	if actions := srv.Actions(v.ID); !reflect.DeepEqual(actions, []string{"poweroff-hard"}) {
+		t.Errorf("actions = %v, expected poweroff-hard", actions)
+	}
+	detached, _ := srv.VM(v.ID)
+	if disks := diskImages(detached); len(disks["CDROM"]) != 0 {
+		t.Errorf("CDROMs %v still attached", disks["CDROM"])
+	}
+}
+
+func TestStepDetachISO_keepExistingImages(t *testing.T) {
+	srv, config, state := newTestState(t)
+	diskID := srv.AddImage("disk", "DATABLOCK", 1)
+	installerID := srv.AddImage("installer", "CDROM", 1)
+	sharedID := srv.AddImage("ubuntu-22.04-live-server", "CDROM", 1)
+	v := startVM(t, config, state, diskID, installerID, sharedID)
+	srv.SetVMState(v.ID, vm.Poweroff, vm.LcmInit)
+	// The shared ISO was attached by name, not created by the build
+	state.Put("CreatedImageIDs", []int{diskID, installerID})
+
+	config.EjectISO = true
+	config.EjectISODelete = true
+	step := &StepDetachISO{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	detached, _ := srv.VM(v.ID)
+	if disks := diskImages(detached); len(disks["CDROM"]) != 0 {
+		t.Errorf("CDROMs %v still attached", disks["CDROM"])
+	}
+	if _, ok := srv.Image(installerID); ok {
+		t.Error("ISO created by the build not deleted")
+	}
+	if _, ok := srv.Image(sharedID); !ok {
+		t.Error("pre-existing ISO deleted")
+	}
+	if created := state.Get("CreatedImageIDs").([]int); !reflect.DeepEqual(created, []int{diskID}) {
+		t.Errorf("CreatedImageIDs = %v, expected %v", created, []int{diskID})
+	}
+}
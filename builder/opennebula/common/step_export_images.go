@@ -0,0 +1,267 @@
+package opennebula
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+// StepExportImages downloads the saved images into the output directory.
+type StepExportImages struct {
+	Export ExportConfig
+}
+
+// exportSource describes a single file to download.
+type exportSource struct {
+	Name     string
+	Format   string
+	URL      string
+	HashName string
+	Hash     hash.Hash
+	Checksum string
+	// SizeMB is the size OpenNebula reports for the image, in MB.
+	SizeMB int
+}
+
+// Run executes the step to download the saved images.
+func (s *StepExportImages) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+
+	if !s.Export.Export {
+		return multistep.ActionContinue
+	}
+	client := newHTTPClient(config.Insecure)
+
+	var sources []exportSource
+	var err error
+	switch s.Export.ExportSource {
+	case "marketplace":
+		sources, err = s.marketplaceSources(state)
+	default:
+		sources, err = s.httpSources(client, state)
+	}
+	if err != nil {
+		err := fmt.Errorf("Error preparing image export: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if err := os.MkdirAll(s.Export.OutputDir, 0755); err != nil {
+		err := fmt.Errorf("Error creating output directory: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("ExportedFiles", []string{})
+	for _, src := range sources {
+		format := src.Format
+		if format == "" {
+			format = "img"
+		}
+		path := filepath.Join(s.Export.OutputDir, fmt.Sprintf("%s.%s", src.Name, strings.ToLower(format)))
+
+		ui.Say(fmt.Sprintf("Downloading %s to %s...", src.URL, path))
+		sum, err := downloadFile(ctx, client, src, path)
+		if err != nil {
+			os.Remove(path)
+			err := fmt.Errorf("Error downloading image %s: %s", src.Name, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		state.Put("ExportedFiles", append(state.Get("ExportedFiles").([]string), path))
+
+		if src.Checksum != "" {
+			ui.Say(fmt.Sprintf("Image %s downloaded and verified.", src.Name))
+			continue
+		}
+		// Nothing to verify against, the checksum is kept for later checks
+		sumPath := path + "." + src.HashName
+		if err := os.WriteFile(sumPath, []byte(fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))), 0644); err != nil {
+			err := fmt.Errorf("Error writing checksum of image %s: %s", src.Name, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Image %s downloaded, %s checksum %s written to %s.", src.Name, src.HashName, sum, sumPath))
+		state.Put("ExportedFiles", append(state.Get("ExportedFiles").([]string), sumPath))
+	}
+
+	return multistep.ActionContinue
+}
+
+// marketplaceSources downloads the appliances created by
+// StepPublishMarketplaceApp, verified with the MD5 computed by OpenNebula.
+func (s *StepExportImages) marketplaceSources(state multistep.StateBag) ([]exportSource, error) {
+	config := state.Get("config").(*Config)
+
+	var sources []exportSource
+	appIDs, _ := state.Get("MarketplaceAppIDs").([]int)
+	for _, appID := range appIDs {
+		app, err := config.Controller.MarketPlaceApp(appID).Info(false)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(app.Source, "http://") && !strings.HasPrefix(app.Source, "https://") {
+			return nil, fmt.Errorf("Marketplace appliance %d is not served over HTTP (source %q)", app.ID, app.Source)
+		}
+		sources = append(sources, exportSource{
+			Name:     app.Name,
+			Format:   app.Format,
+			URL:      app.Source,
+			HashName: "md5",
+			Hash:     md5.New(),
+			Checksum: app.MD5,
+			SizeMB:   app.Size,
+		})
+	}
+
+	return sources, nil
+}
+
+// httpSources downloads the saved images from export_url, verified with the
+// SHA256 checksum served at export_checksum_url if set.
+func (s *StepExportImages) httpSources(client *http.Client, state multistep.StateBag) ([]exportSource, error) {
+	config := state.Get("config").(*Config)
+
+	var sources []exportSource
+	imageIDs, _ := state.Get("ClonedDiskIDs").([]int)
+	for _, imageID := range imageIDs {
+		img, err := config.Controller.Image(imageID).Info(false)
+		if err != nil {
+			return nil, err
+		}
+
+		ictx := config.Ctx
+		ictx.Data = map[string]interface{}{
+			"ImageID": img.ID,
+			"Name":    img.Name,
+			"Source":  img.Source,
+			"Format":  img.Format,
+		}
+		url, err := interpolate.Render(s.Export.ExportURL, &ictx)
+		if err != nil {
+			return nil, fmt.Errorf("Error rendering export_url: %s", err)
+		}
+
+		src := exportSource{
+			Name:     fmt.Sprintf("%s-%d", img.Name, img.ID),
+			Format:   img.Format,
+			URL:      url,
+			HashName: "sha256",
+			Hash:     sha256.New(),
+			SizeMB:   img.Size,
+		}
+
+		if s.Export.ExportChecksumURL != "" {
+			checksumURL, err := interpolate.Render(s.Export.ExportChecksumURL, &ictx)
+			if err != nil {
+				return nil, fmt.Errorf("Error rendering export_checksum_url: %s", err)
+			}
+			if src.Checksum, err = fetchChecksum(client, checksumURL); err != nil {
+				return nil, err
+			}
+		}
+		sources = append(sources, src)
+	}
+
+	return sources, nil
+}
+
+// fetchChecksum reads the first field of a checksum file, as written by
+// sha256sum.
+func fetchChecksum(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error getting checksum from %s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("Empty checksum file at %s", url)
+	}
+	return fields[0], nil
+}
+
+// downloadFile writes src to path, verifies its size against the one
+// reported by OpenNebula and its checksum against the expected one, if any,
+// and returns the checksum.
+func downloadFile(ctx context.Context, client *http.Client, src exportSource, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	size, err := io.Copy(io.MultiWriter(f, src.Hash), resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if err := checkSize(size, src.Format, src.SizeMB); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(src.Hash.Sum(nil))
+	if src.Checksum != "" && !strings.EqualFold(sum, src.Checksum) {
+		return "", fmt.Errorf("%s checksum mismatch: got %s, expected %s", src.HashName, sum, src.Checksum)
+	}
+
+	return sum, f.Close()
+}
+
+// checkSize verifies the size of a downloaded raw file against the size in
+// MB OpenNebula reports for the image. That is the virtual size, which files
+// in other formats do not match: they may be sparse, or larger than the disk
+// with internal snapshots and metadata.
+func checkSize(size int64, format string, sizeMB int) error {
+	if sizeMB <= 0 || !strings.EqualFold(format, "raw") {
+		return nil
+	}
+	const mb = 1024 * 1024
+	// OpenNebula rounds the size up to the next MB
+	if downloadedMB := (size + mb - 1) / mb; downloadedMB != int64(sizeMB) {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d MB", size, sizeMB)
+	}
+	return nil
+}
+
+// Cleanup performs cleanup tasks if necessary.
+func (s *StepExportImages) Cleanup(state multistep.StateBag) {
+	// Exported files are build outputs and are kept
+}
@@ -0,0 +1,190 @@
+package opennebula
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+// testDisk is a raw disk of 1 MB.
+var testDisk = bytes.Repeat([]byte{0xeb}, 1024*1024)
+
+// newExportServer serves testDisk at /disk and its SHA256 checksum, or
+// checksum if set, at /disk.sha256 over TLS.
+func newExportServer(t *testing.T, checksum string) *httptest.Server {
+	if checksum == "" {
+		sum := sha256.Sum256(testDisk)
+		checksum = hex.EncodeToString(sum[:])
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/disk", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testDisk)
+	})
+	mux.HandleFunc("/disk.sha256", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  disk\n", checksum)
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// addSavedImage adds a saved image with the size in MB and the format.
+func addSavedImage(t *testing.T, config *Config, state multistep.StateBag, id, sizeMB int, format string) {
+	t.Helper()
+	tpl := fmt.Sprintf("SIZE=\"%d\"\nFORMAT=\"%s\"", sizeMB, format)
+	if err := config.Controller.Image(id).Update(tpl, parameters.Merge); err != nil {
+		t.Fatal(err)
+	}
+	state.Put("ClonedDiskIDs", []int{id})
+}
+
+func TestStepExportImages_http(t *testing.T) {
+	tests := []struct {
+		name        string
+		checksum    string
+		checksumURL bool
+		sizeMB      int
+		format      string
+		ok          bool
+	}{
+		{"verified", "", true, 1, "raw", true},
+		{"checksum mismatch", "0123", true, 1, "raw", false},
+		{"raw size mismatch", "", true, 2, "raw", false},
+		{"smaller qcow2", "", true, 2, "qcow2", true},
+		{"no checksum", "", false, 1, "raw", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, config, state := newTestState(t)
+			export := newExportServer(t, tt.checksum)
+			config.Insecure = true
+			addSavedImage(t, config, state, srv.AddImage("ubuntu", "OS", 1), tt.sizeMB, tt.format)
+
+			exportConfig := ExportConfig{
+				OutputDir:    t.TempDir(),
+				Export:       true,
+				ExportSource: "http",
+				ExportURL:    export.URL + "/disk",
+			}
+			if tt.checksumURL {
+				exportConfig.ExportChecksumURL = export.URL + "/disk.sha256"
+			}
+			step := &StepExportImages{Export: exportConfig}
+			action := step.Run(context.Background(), state)
+			if !tt.ok {
+				if action != multistep.ActionHalt {
+					t.Fatalf("Run: %s, expected a halt", action)
+				}
+				if files, _ := os.ReadDir(exportConfig.OutputDir); len(files) != 0 {
+					t.Errorf("%d files left after a failed download", len(files))
+				}
+				return
+			}
+			if action != multistep.ActionContinue {
+				t.Fatalf("Run: %s", action)
+			}
+
+			path := filepath.Join(exportConfig.OutputDir, "ubuntu-0."+tt.format)
+			expected := []string{path}
+			if !tt.checksumURL {
+				expected = append(expected, path+".sha256")
+			}
+			if files := state.Get("ExportedFiles").([]string); fmt.Sprint(files) != fmt.Sprint(expected) {
+				t.Fatalf("ExportedFiles = %v, expected %v", files, expected)
+			}
+			if data, _ := os.ReadFile(path); !bytes.Equal(data, testDisk) {
+				t.Errorf("downloaded %d bytes, expected the disk", len(data))
+			}
+			if !tt.checksumURL {
+				sum := sha256.Sum256(testDisk)
+				line := fmt.Sprintf("%s  ubuntu-0.raw\n", hex.EncodeToString(sum[:]))
+				if data, _ := os.ReadFile(path + ".sha256"); string(data) != line {
+					t.Errorf("checksum file %q, expected %q", data, line)
+				}
+			}
+		})
+	}
+}
+
+// The HTTP client honours insecure like the OpenNebula client.
+func TestStepExportImages_tlsVerification(t *testing.T) {
+	srv, config, state := newTestState(t)
+	export := newExportServer(t, "")
+	addSavedImage(t, config, state, srv.AddImage("ubuntu", "OS", 1), 1, "raw")
+
+	step := &StepExportImages{Export: ExportConfig{
+		OutputDir:    t.TempDir(),
+		Export:       true,
+		ExportSource: "http",
+		ExportURL:    export.URL + "/disk",
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected the self-signed certificate to be rejected", action)
+	}
+}
+
+func TestStepExportImages_marketplace(t *testing.T) {
+	srv, config, state := newTestState(t)
+	export := newExportServer(t, "")
+	config.Insecure = true
+	sum := md5.Sum(testDisk)
+	appID := srv.AddMarketplaceApp("ubuntu", 1, "")
+	srv.SetMarketplaceAppSource(appID, export.URL+"/disk", hex.EncodeToString(sum[:]), 1, "raw")
+	state.Put("MarketplaceAppIDs", []int{appID})
+
+	outputDir := t.TempDir()
+	step := &StepExportImages{Export: ExportConfig{
+		OutputDir:    outputDir,
+		Export:       true,
+		ExportSource: "marketplace",
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+	expected := []string{filepath.Join(outputDir, "ubuntu.raw")}
+	if files := state.Get("ExportedFiles").([]string); fmt.Sprint(files) != fmt.Sprint(expected) {
+		t.Errorf("ExportedFiles = %v, expected %v", files, expected)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name   string
+		size   int64
+		format string
+		sizeMB int
+		ok     bool
+	}{
+		{"raw", 2 * mb, "raw", 2, true},
+		{"raw rounded up", 2*mb - 512, "raw", 2, true},
+		{"smaller raw", mb, "raw", 2, false},
+		{"larger raw", 3 * mb, "RAW", 2, false},
+		{"smaller qcow2", mb, "qcow2", 2, true},
+		// Internal snapshots make a qcow2 file larger than its disk
+		{"larger qcow2", 3 * mb, "qcow2", 2, true},
+		{"unknown size", 3 * mb, "raw", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSize(tt.size, tt.format, tt.sizeMB)
+			if tt.ok && err != nil {
+				t.Errorf("checkSize: %s", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("checkSize succeeded, expected a size mismatch")
+			}
+		})
+	}
+}
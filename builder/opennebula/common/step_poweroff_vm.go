@@ -11,10 +11,14 @@ import (
 )
 
 type StepPowerOffVM struct {
-	ShutdownMethod string
+	// Methods tried in order until the VM is powered off: "command",
+	// "poweroff" or "poweroff_hard".
+	ShutdownMethods []string
+	ShutdownCommand string
+	ShutdownTimeout time.Duration
 }
 
-// Shuts down the virtual machine using the specified method.
+// Shuts down the virtual machine, escalating through the configured methods.
 func (s *StepPowerOffVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
 	config := state.Get("config").(*Config)
@@ -28,42 +32,68 @@ func (s *StepPowerOffVM) Run(ctx context.Context, state multistep.StateBag) mult
 
 	ui.Say("Shutting down the virtual machine...")
 
-	// Determine the shutdown method
-	ShutdownMethod := s.ShutdownMethod
-	if ShutdownMethod == "" {
-		ShutdownMethod = "poweroff" // Default
+	// Determine the shutdown methods
+	ShutdownMethods := s.ShutdownMethods
+	if len(ShutdownMethods) == 0 {
+		ShutdownMethods = []string{"poweroff"} // Default
 	}
 
-	// Power off or power off hard based on the chosen method
-	switch ShutdownMethod {
-	case "poweroff":
-		err := controller.VM(vmInfoRaw.ID).Poweroff()
-		if err != nil {
-			ui.Error(fmt.Sprintf("Failed to power off the VM: %s", err))
-			return multistep.ActionHalt
-		}
-	case "poweroff_hard":
-		err := controller.VM(vmInfoRaw.ID).PoweroffHard()
-		if err != nil {
-			ui.Error(fmt.Sprintf("Failed to power off the VM (hard): %s", err))
+	for i, method := range ShutdownMethods {
+		timeout := s.ShutdownTimeout
+
+		// Power off using the shutdown command, ACPI or power off hard
+		switch method {
+		case "command":
+			comm, ok := state.Get("communicator").(packersdk.Communicator)
+			if !ok {
+				ui.Error("No communicator available to run the shutdown command")
+				continue
+			}
+			ui.Say(fmt.Sprintf("Running shutdown command: %s", s.ShutdownCommand))
+			cmd := &packersdk.RemoteCmd{Command: s.ShutdownCommand}
+			// The connection is usually dropped while the guest shuts down,
+			// so the command is not waited for.
+			if err := comm.Start(ctx, cmd); err != nil {
+				ui.Error(fmt.Sprintf("Failed to run the shutdown command: %s", err))
+				continue
+			}
+		case "poweroff":
+			err := controller.VM(vmInfoRaw.ID).Poweroff()
+			if err != nil {
+				ui.Error(fmt.Sprintf("Failed to power off the VM: %s", err))
+				continue
+			}
+		case "poweroff_hard":
+			err := controller.VM(vmInfoRaw.ID).PoweroffHard()
+			if err != nil {
+				ui.Error(fmt.Sprintf("Failed to power off the VM (hard): %s", err))
+				continue
+			}
+			timeout = 5 * time.Minute
+		default:
+			ui.Error(fmt.Sprintf("Invalid shutdown method specified: %s", method))
 			return multistep.ActionHalt
 		}
-	default:
-		ui.Error("Invalid shutdown method specified")
-		return multistep.ActionHalt
-	}
 
-	ui.Say("Waiting for the VM to be powered off...")
+		ui.Say("Waiting for the VM to be powered off...")
+
+		// Wait for the VM to be in POWEROFF state
+		err := WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, timeout)
+		if err == nil {
+			ui.Say("VM successfully powered off.")
+			return multistep.ActionContinue
+		}
 
-	// Wait for the VM to be in POWEROFF state
-	err := WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, 5*time.Minute)
-	if err != nil {
 		ui.Error(fmt.Sprintf("Error waiting for the VM to be powered off: %s", err))
-		return multistep.ActionHalt
+		if i < len(ShutdownMethods)-1 {
+			ui.Say(fmt.Sprintf("Shutdown method %q did not power off the VM, trying %q...", method, ShutdownMethods[i+1]))
+		}
 	}
 
-	ui.Say("VM successfully powered off.")
-	return multistep.ActionContinue
+	err := fmt.Errorf("VM %d could not be powered off with any of the methods %v", vmInfoRaw.ID, ShutdownMethods)
+	state.Put("error", err)
+	ui.Error(err.Error())
+	return multistep.ActionHalt
 }
 
 func (s *StepPowerOffVM) Cleanup(state multistep.StateBag) {
@@ -0,0 +1,129 @@
+package opennebula
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+func TestStepPowerOffVM(t *testing.T) {
+	tests := []struct {
+		name     string
+		methods  []string
+		failNext bool
+		expected []string
+	}{
+		{"default", nil, false, []string{"poweroff"}},
+		{"escalation", []string{"poweroff", "poweroff_hard"}, true, []string{"poweroff", "poweroff-hard"}},
+		{"no communicator", []string{"command", "poweroff"}, false, []string{"poweroff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, config, state := newTestState(t)
+			v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1))
+			if tt.failNext {
+				srv.FailNext("one.vm.action", "driver error")
+			}
+
+			step := &StepPowerOffVM{ShutdownMethods: tt.methods, ShutdownCommand: "shutdown -P now"}
+			if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("Run: %s", action)
+			}
+			if actions := srv.Actions(v.ID); !reflect.DeepEqual(actions, tt.expected) {
+				t.Errorf("actions = %v, expected %v", actions, tt.expected)
+			}
+			if off, _ := srv.VM(v.ID); off.State != vm.Poweroff {
+				t.Errorf("VM is %s, expected POWEROFF", off.State)
+			}
+		})
+	}
+}
+
+func TestStepPowerOffVM_failure(t *testing.T) {
+	srv, config, state := newTestState(t)
+	startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1))
+	srv.FailAlways("one.vm.action", "driver error")
+
+	step := &StepPowerOffVM{ShutdownMethods: []string{"poweroff", "poweroff_hard"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Error("error is not set")
+	}
+}
+
+// shutdownCommunicator powers the VM off when a command is started, unless
+// ignore is set.
+type shutdownCommunicator struct {
+	packersdk.MockCommunicator
+	srv    *fakeone.Server
+	vmID   int
+	ignore bool
+}
+
+func (c *shutdownCommunicator) Start(ctx context.Context, cmd *packersdk.RemoteCmd) error {
+	if err := c.MockCommunicator.Start(ctx, cmd); err != nil {
+		return err
+	}
+	if !c.ignore {
+		c.srv.SetVMState(c.vmID, vm.Poweroff, vm.LcmInit)
+	}
+	return nil
+}
+
+func TestStepPowerOffVM_command(t *testing.T) {
+	tests := []struct {
+		name     string
+		ignore   bool
+		expected []string
+	}{
+		{"guest shuts down", false, nil},
+		{"command ignored", true, []string{"poweroff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, config, state := newTestState(t)
+			v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1))
+			comm := &shutdownCommunicator{srv: srv, vmID: v.ID, ignore: tt.ignore}
+			state.Put("communicator", comm)
+
+			step := &StepPowerOffVM{
+				ShutdownMethods: []string{"command", "poweroff"},
+				ShutdownCommand: "sudo shutdown -P now",
+				ShutdownTimeout: 20 * time.Millisecond,
+			}
+			if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("Run: %s", action)
+			}
+			if comm.StartCmd == nil || comm.StartCmd.Command != "sudo shutdown -P now" {
+				t.Errorf("started %v, expected the shutdown command", comm.StartCmd)
+			}
+			if actions := srv.Actions(v.ID); !reflect.DeepEqual(actions, tt.expected) {
+				t.Errorf("actions = %v, expected %v", actions, tt.expected)
+			}
+			if off, _ := srv.VM(v.ID); off.State != vm.Poweroff {
+				t.Errorf("VM is %s, expected POWEROFF", off.State)
+			}
+		})
+	}
+}
+
+func TestStepPowerOffVM_invalidMethod(t *testing.T) {
+	srv, config, state := newTestState(t)
+	v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1))
+
+	step := &StepPowerOffVM{ShutdownMethods: []string{"reboot", "poweroff"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if actions := srv.Actions(v.ID); len(actions) != 0 {
+		t.Errorf("actions = %v, expected none", actions)
+	}
+}
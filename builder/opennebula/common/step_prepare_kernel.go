@@ -0,0 +1,119 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepPrepareKernel resolves the kernel and initrd the build VM boots
+// directly, uploading them to the kernel datastore when a path is given.
+// The IDs are stored as kernelImageID and initrdImageID. Uploaded images are
+// deleted during cleanup unless the build VM is kept.
+type StepPrepareKernel struct {
+	Kernel      string
+	KernelPath  string
+	Initrd      string
+	InitrdPath  string
+	DatastoreID int
+
+	imageIDs []int
+}
+
+func (s *StepPrepareKernel) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+
+	kernelID, err := s.prepare("KERNEL", s.Kernel, s.KernelPath, state)
+	if err != nil {
+		err := fmt.Errorf("Failed to prepare the kernel: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if kernelID >= 0 {
+		ui.Say(fmt.Sprintf("Booting the kernel image ID: %d", kernelID))
+		state.Put("kernelImageID", kernelID)
+	}
+
+	initrdID, err := s.prepare("RAMDISK", s.Initrd, s.InitrdPath, state)
+	if err != nil {
+		err := fmt.Errorf("Failed to prepare the initrd: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if initrdID >= 0 {
+		ui.Say(fmt.Sprintf("Booting the initrd image ID: %d", initrdID))
+		state.Put("initrdImageID", initrdID)
+	}
+
+	return multistep.ActionContinue
+}
+
+// prepare returns the ID of the image named or identified by ref, or of the
+// image of type imageType created from path. It returns -1 if neither is set.
+func (s *StepPrepareKernel) prepare(imageType, ref, path string, state multistep.StateBag) (int, error) {
+	ui := state.Get("ui").(packersdk.Ui)
+	controller := state.Get("config").(*Config).Controller
+
+	switch {
+	case ref != "":
+		if id, err := strconv.Atoi(ref); err == nil {
+			return id, nil
+		}
+		return controller.Images().ByName(ref)
+	case path != "":
+		name := fmt.Sprintf("%s-%s", state.Get("config").(*Config).VMTemplateConfig.Name, filepath.Base(path))
+		ui.Say(fmt.Sprintf("Uploading %s to datastore %d...", path, s.DatastoreID))
+
+		tpl := &image.Template{}
+		tpl.Add("name", name)
+		tpl.Add("type", imageType)
+		tpl.Add("path", path)
+		id, err := controller.Images().Create(tpl.String(), uint(s.DatastoreID))
+		if err != nil {
+			return 0, err
+		}
+		// Delete the image during cleanup even if it never becomes READY
+		s.imageIDs = append(s.imageIDs, id)
+
+		err = WaitForResourceState(id, "READY", "image", state, 15*time.Minute)
+		if err != nil {
+			return 0, fmt.Errorf("Error waiting for image ID %d to become READY: %s", id, err)
+		}
+		return id, nil
+	}
+	return -1, nil
+}
+
+func (s *StepPrepareKernel) Cleanup(state multistep.StateBag) {
+	if len(s.imageIDs) == 0 {
+		return
+	}
+	ui := state.Get("ui").(packersdk.Ui)
+
+	// The kept VM still boots from them
+	if kept, ok := state.Get("vmKept").(bool); ok && kept {
+		ui.Say("Skipping cleanup of the kernel images as the VM is kept.")
+		return
+	}
+
+	ui.Say("Cleaning up the kernel images...")
+	controller := state.Get("config").(*Config).Controller
+	for _, imageID := range s.imageIDs {
+		ui.Say(fmt.Sprintf("Deleting OpenNebula image ID: %d", imageID))
+		err := WaitForResourceState(imageID, "READY", "image", state, 5*time.Minute)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error waiting for the image to become READY: %s", err))
+		}
+		if err := controller.Image(imageID).Delete(); err != nil {
+			ui.Error(fmt.Sprintf("Error deleting image ID %d: %s", imageID, err))
+		}
+	}
+}
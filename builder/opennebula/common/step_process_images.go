@@ -4,10 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 
-	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
@@ -40,9 +40,15 @@ func (s *StepProcessImages) Run(ctx context.Context, state multistep.StateBag) m
 			if existingID != 0 {
 				ui.Say(fmt.Sprintf("Using existing OpenNebula image Name: %s", imageConfig.Image_Name))
 				state.Put("ImageIDs", append(state.Get("ImageIDs").([]int), existingID))
+			} else if imageConfig.Image_MarketplaceApp.IsSet() {
+				if action := s.exportMarketplaceApp(imageConfig, ui, state); action != multistep.ActionContinue {
+					return action
+				}
 			} else {
 				// Process  images
-				s.prepareImage(imageConfig, ui, state)
+				if action := s.prepareImage(imageConfig, ui, state); action != multistep.ActionContinue {
+					return action
+				}
 			}
 		}
 	}
@@ -54,6 +60,12 @@ func (s *StepProcessImages) Cleanup(state multistep.StateBag) {
 	ui := state.Get("ui").(packersdk.Ui)
 	createdImageIDs := state.Get("CreatedImageIDs").([]int)
 
+	// The images are still attached to the kept VM
+	if kept, ok := state.Get("vmKept").(bool); ok && kept {
+		ui.Say("Skipping cleanup of created images as the VM is kept.")
+		return
+	}
+
 	if createdImageIDs != nil && len(createdImageIDs) > 0 {
 		ui.Say("Cleaning up created images...")
 		c := state.Get("config").(*Config)
@@ -93,6 +105,56 @@ func (s *StepProcessImages) getImageIDByName(name string, ui packersdk.Ui, state
 	return imageID, nil
 }
 
+func (s *StepProcessImages) getMarketplaceAppID(config MarketplaceAppConfig, state multistep.StateBag) (int, error) {
+	if config.App_ID != 0 {
+		return config.App_ID, nil
+	}
+
+	c := state.Get("config").(*Config)
+	pool, err := c.Controller.MarketPlaceApps().Info()
+	if err != nil {
+		return 0, err
+	}
+
+	// The marketplace may be given either by ID or by name
+	marketplaceID, idErr := strconv.Atoi(config.App_Marketplace)
+	for _, app := range pool.MarketPlaceApps {
+		if app.Name != config.App_Name {
+			continue
+		}
+		if idErr == nil && app.MarketPlaceID != nil && *app.MarketPlaceID == marketplaceID {
+			return app.ID, nil
+		}
+		if app.MarketPlace == config.App_Marketplace {
+			return app.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Marketplace appliance '%s' not found in marketplace '%s'", config.App_Name, config.App_Marketplace)
+}
+
+func (s *StepProcessImages) exportMarketplaceApp(config ImageConfig, ui packersdk.Ui, state multistep.StateBag) multistep.StepAction {
+	appID, err := s.getMarketplaceAppID(config.Image_MarketplaceApp, state)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ID, err := ExportMarketplaceApp(appID, config.Image_Name, config.Image_DatastoreID, state)
+	if ID >= 0 && !config.Image_Keep {
+		// Track the image even if it never became READY so it gets removed
+		state.Put("CreatedImageIDs", append(state.Get("CreatedImageIDs").([]int), ID))
+	}
+	if err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
+	}
+
+	state.Put("ImageIDs", append(state.Get("ImageIDs").([]int), ID))
+	return multistep.ActionContinue
+}
+
 func (s *StepProcessImages) prepareImage(config ImageConfig, ui packersdk.Ui, state multistep.StateBag) multistep.StepAction {
 	ui.Say("Preparing disk image...")
 	c := state.Get("config").(*Config)
@@ -100,18 +162,19 @@ func (s *StepProcessImages) prepareImage(config ImageConfig, ui packersdk.Ui, st
 
 	// Check if CloneFromImage is specified
 	if config.Image_CloneFromImage != "" {
-		var err error
-
+		ID = -1
 		// Check if CloneFromImage is ID or Name
-		if id, err := strconv.Atoi(config.Image_CloneFromImage); err == nil {
-			// Clone using ID directly
-			ID, err = CloneImage(id, config.Image_Name, config.Image_DatastoreID, state)
-		} else {
+		sourceID, err := strconv.Atoi(config.Image_CloneFromImage)
+		if err != nil {
 			// Clone using Name, get the ID first
-			id, err = s.getImageIDByName(config.Image_CloneFromImage, ui, state)
-			if err == nil {
-				ID, err = CloneImage(id, config.Image_Name, config.Image_DatastoreID, state)
-			}
+			sourceID, err = s.getImageIDByName(config.Image_CloneFromImage, ui, state)
+		}
+		if err == nil {
+			ID, err = CloneImage(sourceID, config.Image_Name, config.Image_DatastoreID, state)
+		}
+		if ID >= 0 && !config.Image_Keep {
+			// Track the clone even if it never became READY so it gets removed
+			state.Put("CreatedImageIDs", append(state.Get("CreatedImageIDs").([]int), ID))
 		}
 
 		if err != nil {
@@ -124,27 +187,19 @@ func (s *StepProcessImages) prepareImage(config ImageConfig, ui packersdk.Ui, st
 		ui.Say(fmt.Sprintf("Image cloned successfully. New Image ID: %d", ID))
 	} else {
 		// If CloneFromImage is not specified, create a new image
-		tpl := &image.Template{}
-		tpl.Add("name", config.Image_Name)
-		tpl.Add("datastore_id", config.Image_DatastoreID)
-		tpl.Add("type", config.Image_Type)
-		tpl.Add("path", config.Image_Path)
-		tpl.Add("permissions", config.Image_Permissions)
-		tpl.Add("persistent", config.Image_Persistent)
-		tpl.Add("lock", config.Image_Lock)
-		tpl.Add("dev_prefix", config.Image_DevPrefix)
-		tpl.Add("target", config.Image_Target)
-		tpl.Add("driver", config.Image_Driver)
-		tpl.Add("format", config.Image_Format)
-		tpl.Add("size", config.Image_Size)
-		tpl.Add("group", config.Image_Group)
-		tpl.Add("tags", config.Image_Tags)
-
-		ID, err := c.Controller.Images().Create(tpl.String(), uint(config.Image_DatastoreID))
+		tpl := config.template()
+		log.Printf("[DEBUG] OpenNebula image template:\n%s", tpl.String())
+
+		var err error
+		ID, err = c.Controller.Images().Create(tpl.String(), uint(config.Image_DatastoreID))
 		if err != nil {
 			ui.Error(fmt.Sprintf("Error creating the OpenNebula image: %s", err))
 			return multistep.ActionHalt
 		}
+		if !config.Image_Keep {
+			// Track the image even if it never became READY so it gets removed
+			state.Put("CreatedImageIDs", append(state.Get("CreatedImageIDs").([]int), ID))
+		}
 		err = WaitForResourceState(ID, "READY", "image", state, 5*time.Minute)
 		if err != nil {
 			ui.Error(fmt.Sprintf("Error waiting for the image to become READY: %s", err))
@@ -155,7 +210,5 @@ func (s *StepProcessImages) prepareImage(config ImageConfig, ui packersdk.Ui, st
 
 	}
 	state.Put("ImageIDs", append(state.Get("ImageIDs").([]int), ID))
-	// Add the ID of the created image to CreatedImageIDs
-	state.Put("CreatedImageIDs", append(state.Get("CreatedImageIDs").([]int), ID))
 	return multistep.ActionContinue
 }
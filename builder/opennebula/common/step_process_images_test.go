@@ -0,0 +1,239 @@
+package opennebula
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepProcessImages_existing(t *testing.T) {
+	srv, _, state := newTestState(t)
+	// An image ID of 0 is taken as unset
+	srv.AddImage("unused", "OS", 1)
+	byID := srv.AddImage("ubuntu", "OS", 1)
+	byName := srv.AddImage("ubuntu-iso", "CDROM", 1)
+
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_ID: byID},
+		{Image_Name: "ubuntu-iso"},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	if imageIDs := state.Get("ImageIDs").([]int); !reflect.DeepEqual(imageIDs, []int{byID, byName}) {
+		t.Errorf("ImageIDs = %v, expected %v", imageIDs, []int{byID, byName})
+	}
+	if created := state.Get("CreatedImageIDs").([]int); len(created) != 0 {
+		t.Errorf("CreatedImageIDs = %v, expected none", created)
+	}
+	if calls := srv.Calls("one.image.allocate"); len(calls) != 0 {
+		t.Errorf("%d images allocated", len(calls))
+	}
+}
+
+func TestStepProcessImages_create(t *testing.T) {
+	srv, _, state := newTestState(t)
+	srv.AddImage("unused", "OS", 1)
+	sourceID := srv.AddImage("ubuntu", "OS", 1)
+
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: "installer", Image_Type: "CDROM", Image_Path: "https://example.com/installer.iso", Image_DatastoreID: 1},
+		{Image_Name: "disk", Image_CloneFromImage: "ubuntu", Image_DatastoreID: 1, Image_Keep: true},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	imageIDs := state.Get("ImageIDs").([]int)
+	if len(imageIDs) != 2 {
+		t.Fatalf("ImageIDs = %v, expected 2 images", imageIDs)
+	}
+	installer, _ := srv.Image(imageIDs[0])
+	if installer.Name != "installer" || installer.Type != "CDROM" || installer.State != image.Ready {
+		t.Errorf("image %d is %s %s in state %s, expected the READY CDROM installer", installer.ID, installer.Type, installer.Name, installer.State)
+	}
+	if path, _ := installer.Template.GetStr("PATH"); path != "https://example.com/installer.iso" {
+		t.Errorf("PATH = %q", path)
+	}
+	clone := srv.Calls("one.image.clone")
+	if len(clone) != 1 || clone[0].Args[0] != sourceID || clone[0].Args[1] != "disk" {
+		t.Errorf("clone calls %v, expected image %d to be cloned as disk", clone, sourceID)
+	}
+
+	// The kept image survives the cleanup
+	if created := state.Get("CreatedImageIDs").([]int); !reflect.DeepEqual(created, []int{imageIDs[0]}) {
+		t.Errorf("CreatedImageIDs = %v, expected %v", created, imageIDs[:1])
+	}
+	step.Cleanup(state)
+	if _, ok := srv.Image(imageIDs[0]); ok {
+		t.Error("created image not deleted")
+	}
+	if _, ok := srv.Image(imageIDs[1]); !ok {
+		t.Error("kept image deleted")
+	}
+}
+
+func TestStepProcessImages_vmKept(t *testing.T) {
+	srv, _, state := newTestState(t)
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: "installer", Image_Type: "CDROM", Image_Path: "/tmp/installer.iso", Image_DatastoreID: 1},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	state.Put("vmKept", true)
+	step.Cleanup(state)
+	if images := srv.Images(); len(images) != 1 {
+		t.Errorf("%d images left, expected the image of the kept VM", len(images))
+	}
+}
+
+func TestStepProcessImages_allocateError(t *testing.T) {
+	srv, _, state := newTestState(t)
+	srv.FailNext("one.image.allocate", "datastore is full")
+
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: "installer", Image_Type: "CDROM", Image_Path: "/tmp/installer.iso", Image_DatastoreID: 1},
+		{Image_Name: "never", Image_Type: "CDROM", Image_Path: "/tmp/never.iso", Image_DatastoreID: 1},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if calls := srv.Calls("one.image.allocate"); len(calls) != 1 {
+		t.Errorf("%d images allocated after the failure, expected the step to stop", len(calls)-1)
+	}
+	if images := srv.Images(); len(images) != 0 {
+		t.Errorf("%d images created", len(images))
+	}
+}
+
+func TestStepProcessImages_cloneError(t *testing.T) {
+	srv, _, state := newTestState(t)
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: "disk", Image_CloneFromImage: "missing", Image_DatastoreID: 1},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if calls := srv.Calls("one.image.clone"); len(calls) != 0 {
+		t.Errorf("%d images cloned from a missing image", len(calls))
+	}
+}
+
+func TestStepProcessImages_readyError(t *testing.T) {
+	for name, image := range map[string]ImageConfig{
+		"create": {Image_Name: "installer", Image_Type: "CDROM", Image_Path: "/tmp/installer.iso", Image_DatastoreID: 1},
+		"clone":  {Image_Name: "disk", Image_CloneFromImage: "ubuntu", Image_DatastoreID: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, _, state := newTestState(t)
+			srv.AddImage("unused", "OS", 1)
+			srv.AddImage("ubuntu", "OS", 1)
+			srv.FailNext("one.image.info", "image in ERROR")
+
+			step := &StepProcessImages{Images: []ImageConfig{image}}
+			if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+				t.Fatalf("Run: %s, expected a halt", action)
+			}
+			if created := state.Get("CreatedImageIDs").([]int); len(created) != 1 {
+				t.Fatalf("CreatedImageIDs = %v, expected the image that never became READY", created)
+			}
+			step.Cleanup(state)
+			if images := srv.Images(); len(images) != 2 {
+				t.Errorf("%d images left, expected only the existing ones", len(images)-2)
+			}
+		})
+	}
+}
+
+func TestStepProcessImages_marketplaceApp(t *testing.T) {
+	srv, _, state := newTestState(t)
+	// An appliance ID of 0 is taken as unset
+	srv.AddMarketplaceApp("unused", 0, "")
+	alpine := srv.AddMarketplaceApp("Alpine Linux 3.20", 0, "DEV_PREFIX=\"vd\"\nTYPE=\"OS\"")
+	srv.AddMarketplaceApp("Ubuntu 22.04", 0, "TYPE=\"OS\"")
+	ubuntu := srv.AddMarketplaceApp("Ubuntu 22.04", 1, "TYPE=\"OS\"")
+
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: "alpine", Image_DatastoreID: 1, Image_MarketplaceApp: MarketplaceAppConfig{App_ID: alpine}},
+		{Image_Name: "ubuntu", Image_DatastoreID: 1, Image_MarketplaceApp: MarketplaceAppConfig{App_Name: "Ubuntu 22.04", App_Marketplace: "private"}},
+		{Image_DatastoreID: 1, Image_MarketplaceApp: MarketplaceAppConfig{App_Name: "Ubuntu 22.04", App_Marketplace: "1"}, Image_Keep: true},
+	}}
+	// The last image takes the name of the appliance
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	imageIDs := state.Get("ImageIDs").([]int)
+	if len(imageIDs) != 3 {
+		t.Fatalf("ImageIDs = %v, expected 3 images", imageIDs)
+	}
+	expected := []struct {
+		name  string
+		appID int
+	}{
+		{"alpine", alpine},
+		{"ubuntu", ubuntu},
+		{"Ubuntu 22.04", ubuntu},
+	}
+	for i, e := range expected {
+		img, _ := srv.Image(imageIDs[i])
+		if img.Name != e.name || img.State != image.Ready {
+			t.Errorf("image %d is %s in state %s, expected the READY %s", img.ID, img.Name, img.State, e.name)
+		}
+		if from, _ := img.Template.GetInt("FROM_APP"); from != e.appID {
+			t.Errorf("image %s exported from appliance %d, expected %d", img.Name, from, e.appID)
+		}
+	}
+	// The template of the appliance is carried over
+	alpineImage, _ := srv.Image(imageIDs[0])
+	if prefix, _ := alpineImage.Template.GetStr("DEV_PREFIX"); prefix != "vd" {
+		t.Errorf("DEV_PREFIX = %q, expected the one of the appliance", prefix)
+	}
+
+	if created := state.Get("CreatedImageIDs").([]int); !reflect.DeepEqual(created, imageIDs[:2]) {
+		t.Errorf("CreatedImageIDs = %v, expected %v", created, imageIDs[:2])
+	}
+}
+
+func TestStepProcessImages_marketplaceAppNotFound(t *testing.T) {
+	srv, _, state := newTestState(t)
+	srv.AddMarketplaceApp("Ubuntu 22.04", 0, "TYPE=\"OS\"")
+
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: "ubuntu", Image_DatastoreID: 1, Image_MarketplaceApp: MarketplaceAppConfig{App_Name: "Ubuntu 22.04", App_Marketplace: "private"}},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if calls := srv.Calls("one.image.allocate"); len(calls) != 0 {
+		t.Errorf("%d images allocated", len(calls))
+	}
+}
+
+func TestStepProcessImages_marketplaceAppEscapedName(t *testing.T) {
+	srv, _, state := newTestState(t)
+	srv.AddMarketplaceApp("unused", 0, "")
+	alpine := srv.AddMarketplaceApp("Alpine Linux 3.20", 0, "TYPE=\"OS\"")
+
+	name := `alpine "edge"\` + "\nPERSISTENT=\"YES"
+	step := &StepProcessImages{Images: []ImageConfig{
+		{Image_Name: name, Image_DatastoreID: 1, Image_MarketplaceApp: MarketplaceAppConfig{App_ID: alpine}},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	img, _ := srv.Image(state.Get("ImageIDs").([]int)[0])
+	if img.Name != name {
+		t.Errorf("image named %q, expected %q", img.Name, name)
+	}
+	if _, err := img.Template.GetStr("PERSISTENT"); err == nil {
+		t.Error("the image name injected a PERSISTENT attribute")
+	}
+}
@@ -0,0 +1,123 @@
+package opennebula
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp"
+	mpk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp/keys"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepPublishMarketplaceApp creates a Marketplace appliance from every image
+// saved by StepCloneDisk.
+type StepPublishMarketplaceApp struct {
+	Publish MarketplacePublishConfig
+}
+
+// Run executes the step to publish the saved images.
+func (s *StepPublishMarketplaceApp) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+	controller := config.Controller
+
+	if !s.Publish.IsSet() {
+		return multistep.ActionContinue
+	}
+
+	imageIDs, _ := state.Get("ClonedDiskIDs").([]int)
+	if len(imageIDs) == 0 {
+		ui.Say("No saved images to publish.")
+		return multistep.ActionContinue
+	}
+
+	// The marketplace may be given either by ID or by name
+	marketplaceID, err := strconv.Atoi(s.Publish.Publish_Marketplace)
+	if err != nil {
+		marketplaceID, err = controller.MarketPlaces().ByName(s.Publish.Publish_Marketplace)
+		if err != nil {
+			err := fmt.Errorf("Error getting marketplace '%s': %s", s.Publish.Publish_Marketplace, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	name := s.Publish.Publish_Name
+	if name == "" {
+		name = config.SnapshotConfig.Snapshot_Name
+	}
+
+	state.Put("MarketplaceAppIDs", []int{})
+	for i, imageID := range imageIDs {
+		appName := name
+		if len(imageIDs) > 1 {
+			appName = fmt.Sprintf("%s-%d", name, i)
+		}
+
+		ui.Say(fmt.Sprintf("Publishing image ID %d to marketplace %d as '%s'...", imageID, marketplaceID, appName))
+		appID, err := controller.MarketPlaceApps().Create(s.appTemplate(appName, imageID), marketplaceID)
+		if err != nil {
+			err := fmt.Errorf("Failed to create Marketplace appliance from image ID %d: %s", imageID, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		state.Put("MarketplaceAppIDs", append(state.Get("MarketplaceAppIDs").([]int), appID))
+
+		// Uploading the image to the marketplace may take a while
+		err = WaitForResourceState(appID, "READY", "marketapp", state, defaultTimeout)
+		if err != nil {
+			err := fmt.Errorf("Error waiting for the Marketplace appliance to become READY: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Marketplace appliance published successfully. App ID: %d", appID))
+	}
+
+	return multistep.ActionContinue
+}
+
+// appTemplate builds the template of the appliance created from imageID.
+func (s *StepPublishMarketplaceApp) appTemplate(name string, imageID int) string {
+	tpl := marketplaceapp.NewTemplate()
+	tpl.Add(mpk.Name, name)
+	tpl.Add(mpk.OriginID, imageID)
+	tpl.SetType(marketplaceapp.Image)
+	if s.Publish.Publish_Version != "" {
+		tpl.Add(mpk.Version, s.Publish.Publish_Version)
+	}
+	if s.Publish.Publish_Description != "" {
+		tpl.Add(mpk.Description, s.Publish.Publish_Description)
+	}
+	if s.Publish.Publish_Publisher != "" {
+		tpl.Add(mpk.Publisher, s.Publish.Publish_Publisher)
+	}
+
+	if len(s.Publish.Publish_AppTemplate) > 0 {
+		keys := make([]string, 0, len(s.Publish.Publish_AppTemplate))
+		for k := range s.Publish.Publish_AppTemplate {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var appTpl strings.Builder
+		for _, k := range keys {
+			fmt.Fprintf(&appTpl, "%s=\"%s\"\n", strings.ToUpper(k), s.Publish.Publish_AppTemplate[k])
+		}
+		tpl.Add(mpk.AppTemplate64, base64.StdEncoding.EncodeToString([]byte(appTpl.String())))
+	}
+
+	return tpl.String()
+}
+
+// Cleanup performs cleanup tasks if necessary.
+func (s *StepPublishMarketplaceApp) Cleanup(state multistep.StateBag) {
+	// Published appliances are build outputs and are kept
+}
@@ -0,0 +1,107 @@
+package opennebula
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepPublishMarketplaceApp(t *testing.T) {
+	srv, config, state := newTestState(t)
+	config.SnapshotConfig.Snapshot_Name = "ubuntu-22.04"
+	imageIDs := []int{srv.AddImage("ubuntu-22.04-0", "OS", 1), srv.AddImage("ubuntu-22.04-1", "DATABLOCK", 1)}
+	state.Put("ClonedDiskIDs", imageIDs)
+
+	step := &StepPublishMarketplaceApp{Publish: MarketplacePublishConfig{
+		Publish_Marketplace: "private",
+		Publish_Version:     "1.2.0",
+		Publish_AppTemplate: map[string]string{"dev_prefix": "vd", "driver": "qcow2"},
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	appIDs := state.Get("MarketplaceAppIDs").([]int)
+	if len(appIDs) != len(imageIDs) {
+		t.Fatalf("MarketplaceAppIDs = %v, expected an appliance per image", appIDs)
+	}
+	for i, appID := range appIDs {
+		app, _ := srv.MarketplaceApp(appID)
+		expected := []string{"ubuntu-22.04-0", "ubuntu-22.04-1"}[i]
+		if app.Name != expected || app.MarketplaceID != 1 || app.OriginID != imageIDs[i] || app.State != marketplaceapp.Ready {
+			t.Errorf("appliance %d is %s of image %d in marketplace %d in state %s, expected the READY %s of image %d in marketplace 1",
+				app.ID, app.Name, app.OriginID, app.MarketplaceID, app.State, expected, imageIDs[i])
+		}
+		if version, _ := app.Template.GetStr("VERSION"); version != "1.2.0" {
+			t.Errorf("VERSION = %q", version)
+		}
+		if app.AppTemplate != "DEV_PREFIX=\"vd\"\nDRIVER=\"qcow2\"\n" {
+			t.Errorf("APPTEMPLATE64 decodes to %q", app.AppTemplate)
+		}
+	}
+}
+
+func TestStepPublishMarketplaceApp_byID(t *testing.T) {
+	srv, config, state := newTestState(t)
+	config.SnapshotConfig.Snapshot_Name = "ubuntu-22.04"
+	imageID := srv.AddImage("ubuntu-22.04", "OS", 1)
+	state.Put("ClonedDiskIDs", []int{imageID})
+
+	step := &StepPublishMarketplaceApp{Publish: MarketplacePublishConfig{
+		Publish_Marketplace: "1",
+		Publish_Name:        "Ubuntu 22.04",
+	}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	appIDs := state.Get("MarketplaceAppIDs").([]int)
+	app, _ := srv.MarketplaceApp(appIDs[0])
+	if app.Name != "Ubuntu 22.04" || app.MarketplaceID != 1 {
+		t.Errorf("appliance %s in marketplace %d, expected Ubuntu 22.04 in marketplace 1", app.Name, app.MarketplaceID)
+	}
+	// Without app_template no image template is shipped
+	if _, err := app.Template.GetStr("APPTEMPLATE64"); err == nil {
+		t.Errorf("APPTEMPLATE64 is set to %q", base64.StdEncoding.EncodeToString([]byte(app.AppTemplate)))
+	}
+	if calls := srv.Calls("one.marketpool.info"); len(calls) != 0 {
+		t.Errorf("marketplace looked up by name although given by ID")
+	}
+}
+
+func TestStepPublishMarketplaceApp_failure(t *testing.T) {
+	srv, config, state := newTestState(t)
+	config.SnapshotConfig.Snapshot_Name = "ubuntu-22.04"
+	state.Put("ClonedDiskIDs", []int{srv.AddImage("ubuntu-22.04-0", "OS", 1), srv.AddImage("ubuntu-22.04-1", "OS", 1)})
+	srv.FailNext("one.marketapp.allocate", "marketplace is full")
+
+	step := &StepPublishMarketplaceApp{Publish: MarketplacePublishConfig{Publish_Marketplace: "private"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Error("error is not set")
+	}
+	if calls := srv.Calls("one.marketapp.allocate"); len(calls) != 1 {
+		t.Errorf("%d appliances allocated after the failure, expected the step to stop", len(calls)-1)
+	}
+}
+
+func TestStepPublishMarketplaceApp_notSet(t *testing.T) {
+	srv, _, state := newTestState(t)
+	state.Put("ClonedDiskIDs", []int{srv.AddImage("ubuntu-22.04", "OS", 1)})
+
+	step := &StepPublishMarketplaceApp{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+	if _, ok := state.GetOk("MarketplaceAppIDs"); ok {
+		t.Error("MarketplaceAppIDs set although nothing is published")
+	}
+	if calls := srv.Calls("one.marketapp.allocate"); len(calls) != 0 {
+		t.Errorf("%d appliances allocated", len(calls))
+	}
+}
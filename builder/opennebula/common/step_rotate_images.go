@@ -0,0 +1,152 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// imageFamilyAttr is the image attribute holding the family of saved images.
+const imageFamilyAttr = "FAMILY"
+
+// StepRotateImages deletes the oldest images of the snapshot family so that
+// only the last KeepLast images are kept.
+type StepRotateImages struct {
+	Family   string
+	KeepLast int
+	DryRun   bool
+}
+
+// Run executes the step to rotate the images of the family.
+func (s *StepRotateImages) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+	controller := config.Controller
+
+	if s.Family == "" || s.KeepLast == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Rotating images of family '%s', keeping the last %d...", s.Family, s.KeepLast))
+
+	// The saved images may have been handed over to another user by
+	// StepSetOutputPermissions, so the family is not limited to own images
+	pool, err := controller.Images().Info(parameters.PoolWhoAll)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to list images: %s", err))
+		return multistep.ActionHalt
+	}
+
+	var family []image.Image
+	for _, img := range pool.Images {
+		if f, _ := img.Template.GetStr(imageFamilyAttr); f == s.Family {
+			family = append(family, img)
+		}
+	}
+
+	// Newest first
+	sort.Slice(family, func(i, j int) bool {
+		if family[i].RegTime == family[j].RegTime {
+			return family[i].ID > family[j].ID
+		}
+		return family[i].RegTime > family[j].RegTime
+	})
+
+	if len(family) <= s.KeepLast {
+		ui.Say(fmt.Sprintf("Family '%s' has %d images, nothing to rotate.", s.Family, len(family)))
+		return multistep.ActionContinue
+	}
+
+	templateImages, err := s.templateImages(state)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to list VM templates: %s", err))
+		return multistep.ActionHalt
+	}
+
+	built := map[int]bool{}
+	if clonedDiskIDs, ok := state.Get("ClonedDiskIDs").([]int); ok {
+		for _, id := range clonedDiskIDs {
+			built[id] = true
+		}
+	}
+
+	for _, img := range family[s.KeepLast:] {
+		switch {
+		case built[img.ID]:
+			continue
+		case img.RunningVMs > 0 || len(img.VMs.ID) > 0:
+			ui.Say(fmt.Sprintf("Keeping image ID %d (%s): used by VMs %v", img.ID, img.Name, img.VMs.ID))
+			continue
+		case templateImages[img.ID]:
+			ui.Say(fmt.Sprintf("Keeping image ID %d (%s): used by a VM template", img.ID, img.Name))
+			continue
+		}
+
+		if s.DryRun {
+			ui.Say(fmt.Sprintf("[Dry run] Would delete image ID %d (%s)", img.ID, img.Name))
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Deleting image ID %d (%s)", img.ID, img.Name))
+		err := controller.Image(img.ID).Delete()
+		if err != nil {
+			// A failed rotation does not invalidate the build
+			ui.Error(fmt.Sprintf("Error deleting image ID %d: %s", img.ID, err))
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+// templateImages returns the IDs of the images referenced by VM templates.
+func (s *StepRotateImages) templateImages(state multistep.StateBag) (map[int]bool, error) {
+	config := state.Get("config").(*Config)
+
+	pool, err := config.Controller.Templates().Info()
+	if err != nil {
+		return nil, err
+	}
+	images, err := config.Controller.Images().Info()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]int{}
+	for _, img := range images.Images {
+		byName[img.UName+"/"+img.Name] = img.ID
+	}
+
+	used := map[int]bool{}
+	for _, tpl := range pool.Templates {
+		for _, disk := range tpl.Template.GetDisks() {
+			if id, err := disk.GetInt("IMAGE_ID"); err == nil {
+				used[id] = true
+				continue
+			}
+			// Disks may also reference the image by name
+			name, err := disk.GetStr("IMAGE")
+			if err != nil {
+				continue
+			}
+			owner, err := disk.GetStr("IMAGE_UNAME")
+			if err != nil {
+				owner = tpl.UName
+			}
+			if id, ok := byName[owner+"/"+name]; ok {
+				used[id] = true
+			}
+		}
+	}
+
+	return used, nil
+}
+
+// Cleanup performs cleanup tasks if necessary.
+func (s *StepRotateImages) Cleanup(state multistep.StateBag) {
+	// Cleanup, if necessary
+}
@@ -0,0 +1,101 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// addFamilyImages adds n images of the family, oldest first.
+func addFamilyImages(t *testing.T, srv *fakeone.Server, config *Config, family string, n int) []int {
+	t.Helper()
+	var ids []int
+	for i := 0; i < n; i++ {
+		id := srv.AddImage(fmt.Sprintf("%s-%d", family, i), "OS", 1)
+		if err := config.Controller.Image(id).Update(fmt.Sprintf("%s=\"%s\"", imageFamilyAttr, family), parameters.Merge); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// remaining returns which of the images still exist.
+func remaining(srv *fakeone.Server, ids []int) []bool {
+	exist := make([]bool, len(ids))
+	for i, id := range ids {
+		_, exist[i] = srv.Image(id)
+	}
+	return exist
+}
+
+func TestStepRotateImages(t *testing.T) {
+	srv, config, state := newTestState(t)
+	family := addFamilyImages(t, srv, config, "ubuntu", 5)
+	addFamilyImages(t, srv, config, "debian", 2)
+	// The newest image was saved by the build, the oldest ones are in use
+	state.Put("ClonedDiskIDs", family[4:])
+	startVM(t, config, state, family[0])
+	srv.AddTemplate("ubuntu", `DISK=[IMAGE="ubuntu-1"]`)
+
+	step := &StepRotateImages{Family: "ubuntu", KeepLast: 2}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	expected := []bool{true, true, false, true, true}
+	if exist := remaining(srv, family); fmt.Sprint(exist) != fmt.Sprint(expected) {
+		t.Errorf("images left %v, expected %v", exist, expected)
+	}
+	if images := srv.Images(); len(images) != 6 {
+		t.Errorf("%d images left, expected the other family to be kept", len(images))
+	}
+}
+
+func TestStepRotateImages_dryRun(t *testing.T) {
+	srv, config, state := newTestState(t)
+	addFamilyImages(t, srv, config, "ubuntu", 3)
+
+	step := &StepRotateImages{Family: "ubuntu", KeepLast: 1, DryRun: true}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+	if calls := srv.Calls("one.image.delete"); len(calls) != 0 {
+		t.Errorf("%d images deleted on a dry run", len(calls))
+	}
+}
+
+// The images handed over to another user must still be rotated.
+func TestStepRotateImages_outputOwner(t *testing.T) {
+	srv, config, state := newTestState(t)
+	family := addFamilyImages(t, srv, config, "ubuntu", 4)
+	// Saved by previous builds
+	for _, id := range family[:3] {
+		if err := config.Controller.Image(id).Chown(2, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state.Put("ClonedDiskIDs", family[3:])
+
+	steps := []multistep.Step{
+		&StepSetOutputPermissions{Output: OutputConfig{OutputOwner: "packer"}},
+		&StepRotateImages{Family: "ubuntu", KeepLast: 2},
+	}
+	for _, step := range steps {
+		if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+			t.Fatalf("%T: %s", step, action)
+		}
+	}
+
+	expected := []bool{false, false, true, true}
+	if exist := remaining(srv, family); fmt.Sprint(exist) != fmt.Sprint(expected) {
+		t.Errorf("images left %v, expected %v", exist, expected)
+	}
+	if img, _ := srv.Image(family[3]); img.UID != 2 {
+		t.Errorf("saved image owned by user %d, expected 2", img.UID)
+	}
+}
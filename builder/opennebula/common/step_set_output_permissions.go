@@ -0,0 +1,36 @@
+package opennebula
+
+import (
+	"context"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepSetOutputPermissions applies the configured owner, group and
+// permissions to the images saved by StepCloneDisk. The build creates no VM
+// template. It runs after the steps reading the images, which the build user
+// may no longer access once they are handed over.
+type StepSetOutputPermissions struct {
+	Output OutputConfig
+}
+
+// Run executes the step to change ownership and permissions of the outputs.
+func (s *StepSetOutputPermissions) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+
+	imageIDs, _ := state.Get("ClonedDiskIDs").([]int)
+	if err := s.Output.Apply(config.Controller, ui, imageIDs, nil); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+// Cleanup performs cleanup tasks if necessary.
+func (s *StepSetOutputPermissions) Cleanup(state multistep.StateBag) {
+	// Cleanup, if necessary
+}
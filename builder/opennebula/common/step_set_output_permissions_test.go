@@ -0,0 +1,55 @@
+package opennebula
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepSetOutputPermissions(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   OutputConfig
+		uid, gid int
+		perms    [9]int
+	}{
+		{"by name", OutputConfig{OutputOwner: "packer", OutputGroup: "users", OutputPermissions: "640"}, 2, 1, [9]int{1, 1, 0, 1, 0, 0, 0, 0, 0}},
+		{"by ID", OutputConfig{OutputOwner: "1", OutputGroup: "1"}, 1, 1, [9]int{1, 1}},
+		{"permissions only", OutputConfig{OutputPermissions: "744"}, 0, 0, [9]int{1, 1, 1, 1, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, state := newTestState(t)
+			other := srv.AddImage("installer", "CDROM", 1)
+			saved := srv.AddImage("ubuntu-22.04", "OS", 1)
+			state.Put("ClonedDiskIDs", []int{saved})
+
+			step := &StepSetOutputPermissions{Output: tt.output}
+			if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("Run: %s", action)
+			}
+
+			img, _ := srv.Image(saved)
+			if img.UID != tt.uid || img.GID != tt.gid || img.Permissions != tt.perms {
+				t.Errorf("image owned by %d:%d with %v, expected %d:%d with %v", img.UID, img.GID, img.Permissions, tt.uid, tt.gid, tt.perms)
+			}
+			if img, _ := srv.Image(other); img.UID != 0 || img.Permissions != [9]int{1, 1} {
+				t.Errorf("image not saved by the build changed")
+			}
+		})
+	}
+}
+
+func TestStepSetOutputPermissions_unknownUser(t *testing.T) {
+	srv, _, state := newTestState(t)
+	state.Put("ClonedDiskIDs", []int{srv.AddImage("ubuntu-22.04", "OS", 1)})
+
+	step := &StepSetOutputPermissions{Output: OutputConfig{OutputOwner: "nobody"}}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if calls := srv.Calls("one.image.chown"); len(calls) != 0 {
+		t.Errorf("%d images chowned", len(calls))
+	}
+}
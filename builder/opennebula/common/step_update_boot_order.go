@@ -0,0 +1,109 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	vmk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm/keys"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepUpdateBootOrder changes the boot order of the VM once the OS has been
+// installed. OpenNebula only updates the configuration of a powered off VM,
+// so the step first waits for the installation to power the VM off.
+type StepUpdateBootOrder struct {
+	Boot string
+	// DropKernel removes the kernel the VM was booted from, so that it
+	// boots the installed OS instead.
+	DropKernel bool
+	// Timeout is how long to wait for the VM to be powered off.
+	Timeout time.Duration
+}
+
+// kernelOSKeys are the OS attributes of a direct kernel boot.
+var kernelOSKeys = map[string]bool{
+	string(vmk.Kernel):    true,
+	string(vmk.KernelDS):  true,
+	string(vmk.Initrd):    true,
+	string(vmk.InitrdDS):  true,
+	string(vmk.KernelCmd): true,
+}
+
+// Run executes the step to update the boot order.
+func (s *StepUpdateBootOrder) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+	controller := config.Controller
+	vmInfoRaw, ok := state.Get("VM_Info").(*vm.VM)
+
+	if !ok {
+		ui.Error("Failed to convert VM_Info to *vm.VM")
+		return multistep.ActionHalt
+	}
+
+	if s.Boot == "" && !s.DropKernel {
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Waiting up to %s for the VM to be powered off...", s.Timeout))
+	err := WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, s.Timeout)
+	if err != nil {
+		err := fmt.Errorf("The boot configuration can only be changed once the VM is powered off: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if s.DropKernel {
+		ui.Say("Removing the kernel boot from the VM...")
+	}
+	if s.Boot != "" {
+		ui.Say(fmt.Sprintf("Changing the boot order of the VM to %s...", s.Boot))
+	}
+
+	// UpdateConf replaces the whole OS section, so the other attributes
+	// of the current section are carried over.
+	tpl := vm.NewTemplate()
+	if os, err := vmInfoRaw.Template.GetVector(string(vmk.OSVec)); err == nil {
+		for _, pair := range os.Pairs {
+			if s.DropKernel && kernelOSKeys[pair.Key()] {
+				continue
+			}
+			if pair.Key() != string(vmk.Boot) || s.Boot == "" {
+				tpl.AddOS(vmk.OS(pair.Key()), pair.Value)
+			}
+		}
+	}
+	if s.Boot != "" {
+		tpl.AddOS(vmk.Boot, s.Boot)
+	}
+
+	err = controller.VM(vmInfoRaw.ID).UpdateConf(tpl.String())
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to change the boot order: %s", err))
+		return multistep.ActionHalt
+	}
+
+	// Refresh the VM information so later steps see the new boot order
+	vmInfo, err := controller.VM(vmInfoRaw.ID).Info(false)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Failed to fetch VM information: %s", err))
+		return multistep.ActionHalt
+	}
+	state.Put("VM_Info", vmInfo)
+	if s.Boot != "" {
+		// Carried over to the templates using the saved images
+		state.Put("PostInstallBoot", s.Boot)
+	}
+
+	ui.Say("Boot configuration changed successfully.")
+	return multistep.ActionContinue
+}
+
+// Cleanup performs cleanup tasks if necessary.
+func (s *StepUpdateBootOrder) Cleanup(state multistep.StateBag) {
+	// Cleanup, if necessary
+}
@@ -0,0 +1,58 @@
+package opennebula
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepUpdateBootOrder(t *testing.T) {
+	srv, config, state := newTestState(t)
+	config.VMTemplateConfig.OSArch = "x86_64"
+	config.VMTemplateConfig.OSBoot = "disk1,disk0"
+	v := startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1), srv.AddImage("installer", "CDROM", 1))
+	// The installation powers the VM off a bit later
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		srv.SetVMState(v.ID, vm.Poweroff, vm.LcmInit)
+	}()
+
+	step := &StepUpdateBootOrder{Boot: "disk0", Timeout: time.Minute}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Run: %s", action)
+	}
+
+	updated, _ := srv.VM(v.ID)
+	expected := map[string]string{"BOOT": "disk0", "ARCH": "x86_64"}
+	for key, value := range expected {
+		if got, _ := updated.Template.GetStrFromVec("OS", key); got != value {
+			t.Errorf("OS/%s = %q, expected %q", key, got, value)
+		}
+	}
+	if boot, _ := state.Get("VM_Info").(*vm.VM).Template.GetStrFromVec("OS", "BOOT"); boot != "disk0" {
+		t.Errorf("VM_Info has boot order %q, expected it to be refreshed", boot)
+	}
+	if boot := state.Get("PostInstallBoot"); boot != "disk0" {
+		t.Errorf("PostInstallBoot = %v, expected disk0 for the templates", boot)
+	}
+}
+
+// The configuration of a running VM cannot be updated.
+func TestStepUpdateBootOrder_running(t *testing.T) {
+	srv, config, state := newTestState(t)
+	startVM(t, config, state, srv.AddImage("disk", "DATABLOCK", 1))
+
+	step := &StepUpdateBootOrder{Boot: "disk0", Timeout: 10 * time.Millisecond}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("Run: %s, expected a halt", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Error("error is not set")
+	}
+	if calls := srv.Calls("one.vm.updateconf"); len(calls) != 0 {
+		t.Errorf("configuration of the running VM updated %d times", len(calls))
+	}
+}
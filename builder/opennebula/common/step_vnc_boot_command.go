@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
@@ -20,7 +23,70 @@ type StepVNCBootCommand struct {
 	VNCPassword string                `mapstructure:"vm_vnc_password,omitempty"`
 	VNCIP       string                `mapstructure:"vnc_ip" required:"false"`
 	VNCPort     int                   `mapstructure:"vnc_port" required:"false"`
-	BootSteps   [][]string            `mapstructure:"boot_steps" required:"false"`
+	// Boot command split into steps of `[command, description]`. Instead of
+	// keys to type, a step may wait for the VNC console with
+	// `<waitScreenStatic 5s>`, until the screen stops changing, or
+	// `<waitScreenMatch ref.png>`, until it matches a reference image.
+	// Both accept `region=x,y,width,height`, `tolerance=0.01` (fraction of
+	// pixels allowed to differ) and `timeout=5m` options.
+	BootSteps [][]string `mapstructure:"boot_steps" required:"false"`
+	// Save a PNG screenshot of the VNC console into `output_directory` at
+	// this interval while the boot command is typed.
+	VNCScreenshotInterval time.Duration `mapstructure:"vnc_screenshot_interval" required:"false"`
+	// Save a PNG screenshot of the VNC console into `output_directory` when
+	// typing the boot command fails.
+	VNCScreenshotOnError bool `mapstructure:"vnc_screenshot_on_error" required:"false"`
+	// Record the VNC console into `output_directory` as an MJPEG stream
+	// (`vnc-recording.mjpeg`) while the boot command is typed.
+	VNCRecord bool `mapstructure:"vnc_record" required:"false"`
+	// Interval between the frames of the recording. Defaults to `1s`.
+	VNCRecordInterval time.Duration `mapstructure:"vnc_record_interval" required:"false"`
+	// Connect to the VNC console through the websocket proxy of the frontend
+	// instead of the hypervisor host, for runners that can only reach the
+	// frontend. Either `sunstone`, the noVNC proxy of the Ruby Sunstone, or
+	// `fireedge`, whose Guacamole connection is translated to VNC. Through
+	// FireEdge the screen keeps the size it had when connecting.
+	VNCProxy string `mapstructure:"vnc_proxy" required:"false"`
+	// URL of Sunstone or FireEdge, used to request the VNC token. Defaults
+	// to port 9869 of the `opennebula_url` host for Sunstone and to
+	// `/fireedge` on port 2616 for FireEdge.
+	VNCProxyURL string `mapstructure:"vnc_proxy_url" required:"false"`
+	// Websocket URL of the VNC proxy. Defaults to port 29876 of the
+	// `vnc_proxy_url` host for Sunstone and to `/guacamole` under
+	// `vnc_proxy_url` for FireEdge.
+	VNCProxyWebsocketURL string `mapstructure:"vnc_proxy_websocket_url" required:"false"`
+	// Forward the VNC connection over SSH through this jump host, for
+	// hypervisor hosts that are not directly reachable. Defaults to
+	// `ssh_bastion_host` unless `vnc_proxy` is set. The other settings
+	// default to the `ssh_bastion_*` ones when not set.
+	VNCBastionHost string `mapstructure:"vnc_bastion_host" required:"false"`
+	// SSH port of the VNC bastion. Defaults to `ssh_bastion_port` when the
+	// bastion is `ssh_bastion_host`, to `22` otherwise.
+	VNCBastionPort int `mapstructure:"vnc_bastion_port" required:"false"`
+	// User name on the VNC bastion.
+	VNCBastionUsername string `mapstructure:"vnc_bastion_username" required:"false"`
+	// Password for the VNC bastion.
+	VNCBastionPassword string `mapstructure:"vnc_bastion_password" required:"false"`
+	// Private key file for the VNC bastion.
+	VNCBastionPrivateKeyFile string `mapstructure:"vnc_bastion_private_key_file" required:"false"`
+	// Authenticate to the VNC bastion with the local SSH agent.
+	VNCBastionAgentAuth bool `mapstructure:"vnc_bastion_agent_auth" required:"false"`
+	// Console the boot command is typed on, `vnc` (default) or `serial`. The
+	// serial console is a raw TCP socket the hypervisor host opens on
+	// `serial_port`; keys are sent as the text a terminal would send and
+	// screen waits are not available.
+	BootConsole string `mapstructure:"boot_console" required:"false"`
+	// TCP port of the hypervisor host the serial console listens on. Required
+	// when `boot_console` is `serial`.
+	SerialPort int `mapstructure:"serial_port" required:"false"`
+	// Address of the hypervisor host the serial console listens on. Defaults
+	// to `0.0.0.0`. The console has no authentication: anyone reaching the
+	// port can read it and type into the VM during the build, so prefer the
+	// address of a management network Packer connects from.
+	SerialListen string `mapstructure:"serial_listen" required:"false"`
+	// File the serial console output is written to. Defaults to
+	// `serial-console.log` in `output_directory`.
+	SerialLogFile string `mapstructure:"serial_log_file" required:"false"`
 }
 
 func (s *StepVNCBootCommand) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -36,49 +102,26 @@ func (s *StepVNCBootCommand) Run(ctx context.Context, state multistep.StateBag)
 			return multistep.ActionHalt
 		}
 	}
-	vncIP := s.VNCIP
-	if vncIP == "" {
-		vncIPFromVM, err := getVNCIP(state, ui)
+	var d bootcommand.BCDriver
+	var fb *vncFramebuffer
+	if s.BootConsole == "serial" {
+		conn, err := s.connectSerial(state, ui)
 		if err != nil {
 			ui.Error(err.Error())
 			return multistep.ActionHalt
 		}
-		vncIP = vncIPFromVM
-	}
-
-	vncPort := state.Get("vncPort").(int)
-
-	err := checkVNCConnectivity(vncIP, vncPort, ui)
-	if err != nil {
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-
-	ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s:%d)", vncIP, vncPort))
-
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", vncIP, vncPort))
-	if err != nil {
-		err := fmt.Errorf("Error connecting to VNC: %s", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-	defer conn.Close()
-
-	var auth []vnc.ClientAuth
-
-	if len(s.VNCPassword) > 0 {
-		auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: s.VNCPassword}}
+		defer conn.Close()
+		d = newSerialDriver(conn, s.VNCConfig.BootKeyInterval)
 	} else {
-		auth = []vnc.ClientAuth{new(vnc.ClientAuthNone)}
-	}
-
-	client, err := vnc.Client(conn, &vnc.ClientConfig{Auth: auth, Exclusive: false})
-	if err != nil {
-		err := fmt.Errorf("Error handshaking with VNC: %s", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		client, framebuffer, closeVNC, err := s.connectVNC(ctx, state, ui)
+		if err != nil {
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		defer closeVNC()
+		d = bootcommand.NewVNCDriver(client, s.VNCConfig.BootKeyInterval)
+		fb = framebuffer
 	}
-	defer client.Close()
 
 	var pauseFn multistep.DebugPauseFn
 	if debug {
@@ -94,9 +137,11 @@ func (s *StepVNCBootCommand) Run(ctx context.Context, state multistep.StateBag)
 
 	ui.Say(fmt.Sprintf("Typing Command: %s", command))
 
-	ui.Say("Typing the boot commands over VNC...")
-
-	d := bootcommand.NewVNCDriver(client, s.VNCConfig.BootKeyInterval)
+	if s.BootConsole == "serial" {
+		ui.Say("Typing the boot commands over the serial console...")
+	} else {
+		ui.Say("Typing the boot commands over VNC...")
+	}
 
 	for _, step := range bootSteps {
 		if len(step) == 0 {
@@ -115,19 +160,14 @@ func (s *StepVNCBootCommand) Run(ctx context.Context, state multistep.StateBag)
 			ui.Say(fmt.Sprintf("Typing boot command for: %s", description))
 		}
 
-		var httpServerIP string
-		var err error
 		c := state.Get("config").(*Config)
 		ui.Say(fmt.Sprintf("HTTPAddress: %s", c.HTTPConfig.HTTPAddress))
-		if c.HTTPAddress != "0.0.0.0" {
-			httpServerIP = c.HTTPAddress
-		} else {
-			httpServerIP, err = hostIP(c.HTTPInterface)
-			if err != nil {
-				err := fmt.Errorf("Failed to determine host IP: %s", err)
-				ui.Error(err.Error())
-				return multistep.ActionHalt
-			}
+		httpServerIP, err := resolveHTTPIP(c)
+		if err != nil {
+			err := fmt.Errorf("Failed to determine host IP: %s", err)
+			ui.Error(err.Error())
+			state.Put("vncBootFailed", true)
+			return multistep.ActionHalt
 		}
 		ui.Say(fmt.Sprintf("httpServerIP: %s", httpServerIP))
 
@@ -140,24 +180,40 @@ func (s *StepVNCBootCommand) Run(ctx context.Context, state multistep.StateBag)
 			},
 		}
 
-		command, err := interpolate.Render(command, configCtx)
+		command, err := interpolate.Render(step[0], configCtx)
 		if err != nil {
 			err := fmt.Errorf("Error preparing boot command: %s", err)
 			ui.Error(err.Error())
+			state.Put("vncBootFailed", true)
 			return multistep.ActionHalt
 		}
 
-		seq, err := bootcommand.GenerateExpressionSequence(command)
-		if err != nil {
-			err := fmt.Errorf("Error generating boot command: %s", err)
-			ui.Error(err.Error())
-			return multistep.ActionHalt
-		}
+		if wait, ok, err := parseScreenWait(command); ok {
+			if err == nil {
+				ui.Say(fmt.Sprintf("Waiting for the screen: %s", command))
+				err = wait.Wait(ctx, fb)
+			}
+			if err != nil {
+				err := fmt.Errorf("Error waiting for the screen: %s", err)
+				ui.Error(err.Error())
+				state.Put("vncBootFailed", true)
+				return multistep.ActionHalt
+			}
+		} else {
+			seq, err := bootcommand.GenerateExpressionSequence(command)
+			if err != nil {
+				err := fmt.Errorf("Error generating boot command: %s", err)
+				ui.Error(err.Error())
+				state.Put("vncBootFailed", true)
+				return multistep.ActionHalt
+			}
 
-		if err := seq.Do(ctx, d); err != nil {
-			err := fmt.Errorf("Error running boot command: %s", err)
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			if err := seq.Do(ctx, d); err != nil {
+				err := fmt.Errorf("Error running boot command: %s", err)
+				ui.Error(err.Error())
+				state.Put("vncBootFailed", true)
+				return multistep.ActionHalt
+			}
 		}
 
 		if pauseFn != nil {
@@ -177,7 +233,224 @@ func (s *StepVNCBootCommand) Run(ctx context.Context, state multistep.StateBag)
 
 func (s *StepVNCBootCommand) Cleanup(state multistep.StateBag) {}
 
-func hostIP(ifname string) (string, error) {
+// connectVNC connects to the VNC console and, when screenshots, recording
+// or screen waits are configured, starts tracking its framebuffer. The
+// returned function closes everything once the boot command is typed.
+func (s *StepVNCBootCommand) connectVNC(ctx context.Context, state multistep.StateBag, ui packersdk.Ui) (*vncConn, *vncFramebuffer, func(), error) {
+	conn, vncPassword, err := s.dialVNC(ctx, state, ui)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var closers []func()
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	closers = append(closers, func() { conn.Close() })
+
+	var auth []vnc.ClientAuth
+
+	if len(vncPassword) > 0 {
+		auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: vncPassword}}
+	} else {
+		auth = []vnc.ClientAuth{new(vnc.ClientAuthNone)}
+	}
+
+	clientConfig := &vnc.ClientConfig{Auth: auth, Exclusive: false}
+
+	// Capture the framebuffer through the same connection used for typing
+	var fb *vncFramebuffer
+	if s.VNCScreenshotInterval > 0 || s.VNCScreenshotOnError || s.VNCRecord || usesScreenWait(s.BootSteps) {
+		fb = newVNCFramebuffer()
+		clientConfig.ServerMessageCh = fb.Messages
+	}
+
+	c, err := vnc.Client(conn, clientConfig)
+	if err != nil {
+		closeAll()
+		return nil, nil, nil, fmt.Errorf("Error handshaking with VNC: %s", err)
+	}
+	closers = append(closers, func() { c.Close() })
+	client := &vncConn{ClientConn: c}
+
+	if fb != nil {
+		fb.Attach(client)
+		closers = append(closers, fb.Close)
+
+		outputDir := state.Get("config").(*Config).OutputDir
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("Error creating output directory: %s", err)
+		}
+
+		recordInterval := s.VNCRecordInterval
+		if recordInterval == 0 {
+			recordInterval = time.Second
+		}
+		recorder := &vncRecorder{
+			fb:                 fb,
+			dir:                outputDir,
+			screenshotInterval: s.VNCScreenshotInterval,
+			record:             s.VNCRecord,
+			recordInterval:     recordInterval,
+		}
+		if err := recorder.Start(ctx); err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("Error starting VNC recording: %s", err)
+		}
+		closers = append(closers, recorder.Stop)
+
+		if s.VNCScreenshotOnError {
+			closers = append(closers, func() {
+				if _, ok := state.GetOk("vncBootFailed"); !ok {
+					return
+				}
+				path := filepath.Join(outputDir, "vnc-error.png")
+				err := fb.Refresh(context.Background(), 5*time.Second)
+				if err == nil {
+					err = fb.SavePNG(path)
+				}
+				if err != nil {
+					ui.Error(fmt.Sprintf("Failed to save VNC screenshot: %s", err))
+					return
+				}
+				ui.Say(fmt.Sprintf("Saved VNC screenshot to %s", path))
+			})
+		}
+	}
+
+	return client, fb, closeAll, nil
+}
+
+// connectSerial connects to the serial console of the VM and starts logging
+// its output.
+func (s *StepVNCBootCommand) connectSerial(state multistep.StateBag, ui packersdk.Ui) (net.Conn, error) {
+	host := s.VNCIP
+	if host == "" {
+		var err error
+		host, err = getVNCIP(state, ui)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(s.SerialPort))
+
+	var conn net.Conn
+	var err error
+	if s.VNCBastionHost != "" {
+		ui.Say(fmt.Sprintf("Connecting to the serial console (%s) through bastion %s", addr, s.VNCBastionHost))
+		conn, err = s.dialVNCBastion(addr)
+	} else {
+		ui.Say(fmt.Sprintf("Connecting to the serial console (%s)", addr))
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to the serial console: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.SerialLogFile), 0755); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error creating output directory: %s", err)
+	}
+	if err := logSerialConsole(conn, s.SerialLogFile); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error creating the serial console log: %s", err)
+	}
+	return conn, nil
+}
+
+// dialVNC opens the RFB stream to the VNC console of the VM and returns it
+// with the VNC password to authenticate with.
+func (s *StepVNCBootCommand) dialVNC(ctx context.Context, state multistep.StateBag, ui packersdk.Ui) (net.Conn, string, error) {
+	if s.VNCProxy == "fireedge" {
+		c := state.Get("config").(*Config)
+		vmID := state.Get("vmID").(int)
+
+		ui.Say(fmt.Sprintf("Connecting to VM via VNC proxy (%s)", s.VNCProxyURL))
+		conn, err := dialFireEdgeVNC(ctx, s.VNCProxyURL, s.VNCProxyWebsocketURL, c.Username, c.Password, c.Insecure, vmID)
+		return conn, "", err
+	}
+	if s.VNCProxy == "sunstone" {
+		c := state.Get("config").(*Config)
+		vmID := state.Get("vmID").(int)
+
+		ui.Say(fmt.Sprintf("Connecting to VM via VNC proxy (%s)", s.VNCProxyURL))
+		conn, password, err := dialSunstoneVNC(ctx, s.VNCProxyURL, s.VNCProxyWebsocketURL, c.Username, c.Password, c.Insecure, vmID)
+		if err != nil {
+			return nil, "", err
+		}
+		if s.VNCPassword != "" {
+			password = s.VNCPassword
+		}
+		return conn, password, nil
+	}
+
+	password := s.VNCPassword
+	if generated, ok := state.GetOk("vncPassword"); ok {
+		password = generated.(string)
+	}
+
+	vncIP := s.VNCIP
+	if vncIP == "" {
+		vncIPFromVM, err := getVNCIP(state, ui)
+		if err != nil {
+			return nil, "", err
+		}
+		vncIP = vncIPFromVM
+	}
+
+	vncPort := state.Get("vncPort").(int)
+
+	addr := net.JoinHostPort(vncIP, strconv.Itoa(vncPort))
+
+	if s.VNCBastionHost != "" {
+		ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s) through bastion %s", addr, s.VNCBastionHost))
+		conn, err := s.dialVNCBastion(addr)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error connecting to VNC through bastion: %s", err)
+		}
+		return conn, password, nil
+	}
+
+	if err := checkVNCConnectivity(vncIP, vncPort, ui); err != nil {
+		return nil, "", err
+	}
+
+	ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s:%d)", vncIP, vncPort))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error connecting to VNC: %s", err)
+	}
+	return conn, password, nil
+}
+
+// usesScreenWait reports whether any of the boot steps waits for the screen.
+func usesScreenWait(bootSteps [][]string) bool {
+	for _, step := range bootSteps {
+		if len(step) == 0 {
+			continue
+		}
+		if _, ok, _ := parseScreenWait(step[0]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveHTTPIP returns the address the VM reaches the HTTP server of the
+// build on.
+func resolveHTTPIP(c *Config) (string, error) {
+	if c.HTTPAddress != "0.0.0.0" {
+		return c.HTTPAddress, nil
+	}
+	return HostIP(c.HTTPInterface)
+}
+
+// HostIP returns the first non-loopback IPv4 address of the interface, or of
+// the host when ifname is empty.
+func HostIP(ifname string) (string, error) {
 	var addrs []net.Addr
 	var err error
 
@@ -221,7 +494,7 @@ func getVNCIP(state multistep.StateBag, ui packersdk.Ui) (string, error) {
 }
 
 func checkVNCConnectivity(vncIP string, vncPort int, ui packersdk.Ui) error {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", vncIP, vncPort))
+	conn, err := net.Dial("tcp", net.JoinHostPort(vncIP, strconv.Itoa(vncPort)))
 	if err != nil {
 		return fmt.Errorf("Error connecting to VNC: %s", err)
 	}
@@ -0,0 +1,44 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepWaitForInstall waits for an unattended installation to power off the
+// VM once it is done.
+type StepWaitForInstall struct {
+	Timeout time.Duration
+}
+
+func (s *StepWaitForInstall) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	vmInfoRaw, ok := state.Get("VM_Info").(*vm.VM)
+
+	if !ok {
+		ui.Error("Failed to convert VM_Info to *vm.VM")
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("Waiting up to %s for the installation to power off the VM...", s.Timeout))
+
+	err := WaitForResourceState(vmInfoRaw.ID, "POWEROFF", "vm", state, s.Timeout)
+	if err != nil {
+		err := fmt.Errorf("Error waiting for the installation to finish: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Installation finished.")
+	return multistep.ActionContinue
+}
+
+func (s *StepWaitForInstall) Cleanup(state multistep.StateBag) {
+	// Cleanup, if necessary
+}
@@ -0,0 +1,153 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	imk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image/keys"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	vmk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm/keys"
+)
+
+// vmTemplateParams are the parts of the build VM template only known once
+// the build runs.
+type vmTemplateParams struct {
+	ImageIDs    []int
+	VNCPassword string
+	// SerialPort exposes the serial console as a TCP socket when set,
+	// listening on SerialListen.
+	SerialPort   int
+	SerialListen string
+	// KernelImageID and InitrdImageID are -1 unless the VM boots a kernel
+	// directly.
+	KernelImageID int
+	InitrdImageID int
+	// KernelCmd is the rendered kernel command line.
+	KernelCmd string
+}
+
+// template returns the template of the build VM. Attributes that are not
+// configured are left out so that OpenNebula applies its defaults.
+func (c *VMTemplateConfig) template(p vmTemplateParams) *vm.Template {
+	tpl := vm.NewTemplate()
+
+	if c.Name != "" {
+		tpl.Add(vmk.Name, c.Name)
+	}
+	if c.CPU != 0 {
+		tpl.CPU(c.CPU)
+	}
+	if c.Memory != 0 {
+		tpl.Memory(c.Memory)
+	}
+	if c.VCPU != 0 {
+		tpl.VCPU(c.VCPU)
+	}
+	if c.CPUModel != "" {
+		tpl.CPUModel(c.CPUModel)
+	}
+
+	for _, imageID := range p.ImageIDs {
+		disk := tpl.AddDisk()
+		disk.Add(shared.ImageID, imageID)
+	}
+
+	graphics := []struct {
+		key   vmk.IOGraphics
+		value string
+	}{
+		{vmk.GraphicType, c.GraphicsType},
+		{vmk.Keymap, c.GraphicsKeymap},
+		{vmk.Listen, c.GraphicsListen},
+		{vmk.Passwd, p.VNCPassword},
+	}
+	for _, attr := range graphics {
+		if attr.value != "" {
+			tpl.AddIOGraphic(attr.key, attr.value)
+		}
+	}
+
+	if p.SerialPort != 0 {
+		raw := tpl.AddVector("RAW")
+		raw.AddPair("TYPE", "kvm")
+		raw.AddPair("DATA", serialConsoleRaw(p.SerialListen, p.SerialPort))
+	}
+
+	for _, nicConf := range c.NICs {
+		nic := tpl.AddNIC()
+		nic.Add(shared.Network, nicConf.Network)
+	}
+
+	tpl.AddCtx(vmk.SetHostname, "$NAME")
+	tpl.AddCtx(vmk.SSHPubKey, "$USER[SSH_PUBLIC_KEY]")
+	tpl.AddCtx(vmk.NetworkCtx, "YES")
+	if c.UserData != "" {
+		tpl.AddCtx("USER_DATA", base64.StdEncoding.EncodeToString([]byte(c.UserData)))
+		tpl.AddCtx("USER_DATA_ENCODING", "base64")
+	}
+	tpl.AddCtx("AUTOSTART", "true")
+
+	if c.OSArch != "" {
+		tpl.AddOS(vmk.Arch, c.OSArch)
+	}
+	if c.OSBoot != "" {
+		tpl.AddOS(vmk.Boot, c.OSBoot)
+	}
+	if p.KernelImageID >= 0 {
+		tpl.AddOS(vmk.KernelDS, fmt.Sprintf("$FILE[IMAGE_ID=%d]", p.KernelImageID))
+		if p.InitrdImageID >= 0 {
+			tpl.AddOS(vmk.InitrdDS, fmt.Sprintf("$FILE[IMAGE_ID=%d]", p.InitrdImageID))
+		}
+		if p.KernelCmd != "" {
+			tpl.AddOS(vmk.KernelCmd, p.KernelCmd)
+		}
+	}
+
+	return tpl
+}
+
+// template returns the template of the image created from path, or as an
+// empty datablock. Attributes that are not configured are left out.
+func (c *ImageConfig) template() *image.Template {
+	tpl := image.NewTemplate()
+
+	attrs := []struct {
+		key   imk.Template
+		value string
+	}{
+		{imk.Name, c.Image_Name},
+		{imk.Type, c.Image_Type},
+		{imk.Path, c.Image_Path},
+		{"LOCK", c.Image_Lock},
+		{imk.DevPrefix, c.Image_DevPrefix},
+		{imk.Target, c.Image_Target},
+		{imk.Driver, c.Image_Driver},
+		{"FORMAT", c.Image_Format},
+		{"GROUP", c.Image_Group},
+		// goca drops list values, so the tags are sent comma separated
+		{"TAGS", strings.Join(c.Image_Tags, ",")},
+	}
+	for _, attr := range attrs {
+		if attr.value != "" {
+			tpl.Add(attr.key, attr.value)
+		}
+	}
+	if c.Image_DatastoreID != 0 {
+		tpl.Add("DATASTORE_ID", c.Image_DatastoreID)
+	}
+	if c.Image_Permissions != 0 {
+		tpl.Add("PERMISSIONS", c.Image_Permissions)
+	}
+	// goca drops bool values, OpenNebula expects YES or NO
+	if c.Image_Persistent {
+		tpl.Add(imk.Persistent, "YES")
+	}
+	if c.Image_Size != 0 {
+		tpl.Add(imk.Size, c.Image_Size)
+	}
+
+	return tpl
+}
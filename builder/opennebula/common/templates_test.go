@@ -0,0 +1,140 @@
+package opennebula
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares the template with testdata/<name>.golden, or writes it
+// there with -update.
+func checkGolden(t *testing.T, name, template string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading the golden file, run with -update to create it: %s", err)
+	}
+	if template != string(expected) {
+		t.Errorf("template differs from %s:\n%s\nexpected:\n%s", path, template, expected)
+	}
+}
+
+func TestVMTemplateConfig_template(t *testing.T) {
+	noKernel := vmTemplateParams{ImageIDs: []int{12}, KernelImageID: -1, InitrdImageID: -1}
+
+	tests := []struct {
+		name   string
+		config VMTemplateConfig
+		params vmTemplateParams
+	}{
+		{
+			// Nothing but the disk, nothing empty is emitted
+			name:   "vm_minimal",
+			params: noKernel,
+		},
+		{
+			name: "vm_iso",
+			config: VMTemplateConfig{
+				Name:           "packer-ubuntu",
+				CPU:            0.5,
+				VCPU:           2,
+				Memory:         2048,
+				CPUModel:       "host-passthrough",
+				GraphicsType:   "VNC",
+				GraphicsKeymap: "en-us",
+				GraphicsListen: "0.0.0.0",
+				NICs:           []NICConfig{{Network: "public"}, {Network: "private"}},
+				UserData:       "#cloud-config\npackages: [qemu-guest-agent]\n",
+				OSArch:         "x86_64",
+				OSBoot:         "disk1,disk0",
+			},
+			params: vmTemplateParams{
+				ImageIDs:      []int{12, 13},
+				VNCPassword:   "s3cr3t",
+				KernelImageID: -1,
+				InitrdImageID: -1,
+			},
+		},
+		{
+			name: "vm_serial",
+			config: VMTemplateConfig{
+				Name:   "packer-serial",
+				Memory: 1024,
+			},
+			params: vmTemplateParams{
+				ImageIDs:      []int{12},
+				SerialPort:    4555,
+				SerialListen:  "192.0.2.20",
+				KernelImageID: -1,
+				InitrdImageID: -1,
+			},
+		},
+		{
+			name: "vm_kernel",
+			config: VMTemplateConfig{
+				Name:   "packer-kernel",
+				Memory: 1024,
+				OSArch: "x86_64",
+			},
+			params: vmTemplateParams{
+				ImageIDs:      []int{12},
+				KernelImageID: 20,
+				InitrdImageID: 21,
+				KernelCmd:     "console=ttyS0 ds=nocloud-net;s=http://192.0.2.10:8100/",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGolden(t, tt.name, tt.config.template(tt.params).String())
+		})
+	}
+}
+
+func TestImageConfig_template(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ImageConfig
+	}{
+		{
+			name: "image_iso",
+			config: ImageConfig{
+				Image_Name:        "ubuntu-22.04-live-server",
+				Image_Type:        "CDROM",
+				Image_DatastoreID: 1,
+				Image_Path:        "https://releases.ubuntu.com/22.04/ubuntu-22.04-live-server-amd64.iso",
+			},
+		},
+		{
+			name: "image_datablock",
+			config: ImageConfig{
+				Image_Name:        "packer-disk",
+				Image_Type:        "DATABLOCK",
+				Image_DatastoreID: 1,
+				Image_Size:        10240,
+				Image_Persistent:  true,
+				Image_DevPrefix:   "vd",
+				Image_Driver:      "qcow2",
+				Image_Format:      "qcow2",
+				Image_Permissions: 640,
+				Image_Tags:        []string{"packer", "ubuntu"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGolden(t, tt.name, tt.config.template().String())
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package opennebula
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator/ssh"
+	"github.com/hashicorp/packer-plugin-sdk/pathing"
+	helperssh "github.com/hashicorp/packer-plugin-sdk/sdk-internals/communicator/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// dialVNCBastion forwards addr over an SSH connection to the VNC bastion.
+// Closing the returned connection also closes the SSH connection.
+func (s *StepVNCBootCommand) dialVNCBastion(addr string) (net.Conn, error) {
+	auth := make([]gossh.AuthMethod, 0, 3)
+
+	if s.VNCBastionPassword != "" {
+		auth = append(auth,
+			gossh.Password(s.VNCBastionPassword),
+			gossh.KeyboardInteractive(helperssh.PasswordKeyboardInteractive(s.VNCBastionPassword)))
+	}
+
+	if s.VNCBastionPrivateKeyFile != "" {
+		path, err := pathing.ExpandUser(s.VNCBastionPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error expanding path for VNC bastion private key: %s", err)
+		}
+		signer, err := ssh.FileSigner(path)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, gossh.PublicKeys(signer))
+	}
+
+	if s.VNCBastionAgentAuth {
+		authSock := os.Getenv("SSH_AUTH_SOCK")
+		if authSock == "" {
+			return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+		}
+		sshAgent, err := net.Dial("unix", authSock)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot connect to SSH Agent socket %q: %s", authSock, err)
+		}
+		// The agent is only used to authenticate, while connecting
+		defer sshAgent.Close()
+		auth = append(auth, gossh.PublicKeysCallback(agent.NewClient(sshAgent).Signers))
+	}
+
+	conf := &gossh.ClientConfig{
+		User:            s.VNCBastionUsername,
+		Auth:            auth,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	}
+
+	bastionAddr := net.JoinHostPort(s.VNCBastionHost, strconv.Itoa(s.VNCBastionPort))
+	return helperssh.BastionConnectFunc("tcp", bastionAddr, conf, "tcp", addr)()
+}
@@ -0,0 +1,175 @@
+package opennebula
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/go-vnc"
+)
+
+// vncConn serializes the messages sent on a VNC connection. go-vnc writes
+// a message in several parts, so the keys typed by the boot command driver
+// must not interleave with the update requests of the framebuffer.
+type vncConn struct {
+	*vnc.ClientConn
+	mu sync.Mutex
+}
+
+func (c *vncConn) KeyEvent(keysym uint32, down bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ClientConn.KeyEvent(keysym, down)
+}
+
+func (c *vncConn) FramebufferUpdateRequest(incremental bool, x, y, width, height uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ClientConn.FramebufferUpdateRequest(incremental, x, y, width, height)
+}
+
+// vncFramebuffer keeps a local copy of the remote framebuffer of a VNC
+// connection, built from the updates the server sends.
+type vncFramebuffer struct {
+	// Messages must be passed as ServerMessageCh of the vnc.ClientConfig.
+	Messages chan vnc.ServerMessage
+
+	client *vncConn
+	done   chan struct{}
+
+	mu          sync.Mutex
+	img         *image.RGBA
+	initialized bool
+	// updated is closed, and replaced, when an update is applied, so that
+	// every Refresh waiting sees it.
+	updated chan struct{}
+}
+
+func newVNCFramebuffer() *vncFramebuffer {
+	return &vncFramebuffer{
+		Messages: make(chan vnc.ServerMessage, 16),
+		done:     make(chan struct{}),
+		updated:  make(chan struct{}),
+	}
+}
+
+// Attach starts tracking the framebuffer of the connected client.
+func (f *vncFramebuffer) Attach(client *vncConn) {
+	f.client = client
+	f.img = image.NewRGBA(image.Rect(0, 0, int(client.FrameBufferWidth), int(client.FrameBufferHeight)))
+	go f.loop()
+}
+
+// Close stops tracking the framebuffer.
+func (f *vncFramebuffer) Close() {
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+}
+
+func (f *vncFramebuffer) loop() {
+	for {
+		select {
+		case <-f.done:
+			return
+		case msg := <-f.Messages:
+			update, ok := msg.(*vnc.FramebufferUpdateMessage)
+			if !ok {
+				continue
+			}
+			f.apply(update)
+		}
+	}
+}
+
+func (f *vncFramebuffer) apply(update *vnc.FramebufferUpdateMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pf := f.client.PixelFormat
+	scale := func(v, max uint16) uint8 {
+		if !pf.TrueColor || max == 0 {
+			// Color map entries are 16 bit values
+			return uint8(v >> 8)
+		}
+		return uint8(uint32(v) * 255 / uint32(max))
+	}
+
+	for _, rect := range update.Rectangles {
+		raw, ok := rect.Enc.(*vnc.RawEncoding)
+		if !ok {
+			continue
+		}
+		for y := 0; y < int(rect.Height); y++ {
+			for x := 0; x < int(rect.Width); x++ {
+				c := raw.Colors[y*int(rect.Width)+x]
+				f.img.SetRGBA(int(rect.X)+x, int(rect.Y)+y, color.RGBA{
+					R: scale(c.R, pf.RedMax),
+					G: scale(c.G, pf.GreenMax),
+					B: scale(c.B, pf.BlueMax),
+					A: 0xff,
+				})
+			}
+		}
+	}
+	f.initialized = true
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// Refresh requests an update of the framebuffer and waits until an update
+// arrives, the timeout expires or ctx is done. Servers only answer
+// incremental requests once the screen changes, so a timeout is not an
+// error. Concurrent calls all see the next update.
+func (f *vncFramebuffer) Refresh(ctx context.Context, timeout time.Duration) error {
+	f.mu.Lock()
+	incremental := f.initialized
+	updated := f.updated
+	f.mu.Unlock()
+
+	err := f.client.FramebufferUpdateRequest(incremental, 0, 0, f.client.FrameBufferWidth, f.client.FrameBufferHeight)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-updated:
+	case <-time.After(timeout):
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+		return errors.New("VNC framebuffer closed")
+	}
+	return nil
+}
+
+// Image returns a copy of the current framebuffer.
+func (f *vncFramebuffer) Image() *image.RGBA {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	img := image.NewRGBA(f.img.Rect)
+	copy(img.Pix, f.img.Pix)
+	return img
+}
+
+// SavePNG writes the current framebuffer to path.
+func (f *vncFramebuffer) SavePNG(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := png.Encode(out, f.Image()); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,294 @@
+package opennebula
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+	"github.com/mitchellh/go-vnc"
+)
+
+// fakeVNCServer is an RFB 3.8 server of a 64x48 screen filled with a single
+// color. Like real servers, it only answers incremental update requests once
+// the screen changes, with a single update for all the pending requests. It
+// stops at the first malformed client message.
+type fakeVNCServer struct {
+	conn    net.Conn
+	pf      rfbPixelFormat
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	color    color.RGBA
+	changed  bool
+	pending  bool
+	requests int
+	keys     []uint32
+	err      error
+}
+
+// newFakeVNCServer starts a fake VNC server and returns it with the
+// connection of the client.
+func newFakeVNCServer(t *testing.T) (*fakeVNCServer, net.Conn) {
+	server, client := net.Pipe()
+	s := &fakeVNCServer{
+		conn:  server,
+		pf:    rfbPixelFormat{BPP: 32, Depth: 24, TrueColor: 1, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8},
+		color: color.RGBA{A: 0xff},
+	}
+	go func() {
+		err := s.serve()
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		server.Close()
+	}()
+	t.Cleanup(func() { client.Close() })
+	return s, client
+}
+
+func (s *fakeVNCServer) serve() error {
+	if _, err := io.WriteString(s.conn, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	// Version, security type None and ClientInit
+	handshake := []struct {
+		read  int
+		reply []byte
+	}{
+		{12, []byte{1, 1}},
+		{1, []byte{0, 0, 0, 0}},
+		{1, nil},
+	}
+	for _, step := range handshake {
+		if _, err := io.CopyN(io.Discard, s.conn, int64(step.read)); err != nil {
+			return err
+		}
+		if _, err := s.conn.Write(step.reply); err != nil {
+			return err
+		}
+	}
+	var init bytes.Buffer
+	binary.Write(&init, binary.BigEndian, [2]uint16{64, 48})
+	binary.Write(&init, binary.BigEndian, s.pf)
+	binary.Write(&init, binary.BigEndian, uint32(4))
+	init.WriteString("fake")
+	if _, err := s.conn.Write(init.Bytes()); err != nil {
+		return err
+	}
+
+	for {
+		var msgType uint8
+		if err := binary.Read(s.conn, binary.BigEndian, &msgType); err != nil {
+			return nil
+		}
+		switch msgType {
+		case 3:
+			var msg struct {
+				Incremental         uint8
+				X, Y, Width, Height uint16
+			}
+			if err := binary.Read(s.conn, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if msg.Incremental > 1 || msg.X != 0 || msg.Y != 0 || msg.Width != 64 || msg.Height != 48 {
+				return fmt.Errorf("malformed FramebufferUpdateRequest %+v", msg)
+			}
+			s.mu.Lock()
+			s.requests++
+			update := msg.Incremental == 0 || s.changed
+			s.pending = !update
+			s.mu.Unlock()
+			if update {
+				s.sendUpdate()
+			}
+		case 4:
+			var msg struct {
+				Down    uint8
+				Padding uint16
+				Key     uint32
+			}
+			if err := binary.Read(s.conn, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if msg.Down > 1 || msg.Padding != 0 {
+				return fmt.Errorf("malformed KeyEvent %+v", msg)
+			}
+			if msg.Down == 1 {
+				s.mu.Lock()
+				s.keys = append(s.keys, msg.Key)
+				s.mu.Unlock()
+			}
+		default:
+			return fmt.Errorf("unexpected message type %d", msgType)
+		}
+	}
+}
+
+// SetColor fills the screen with c.
+func (s *fakeVNCServer) SetColor(c color.RGBA) {
+	s.mu.Lock()
+	s.color = c
+	s.changed = true
+	update := s.pending
+	s.pending = false
+	s.mu.Unlock()
+	if update {
+		s.sendUpdate()
+	}
+}
+
+func (s *fakeVNCServer) sendUpdate() {
+	s.mu.Lock()
+	c := s.color
+	s.changed = false
+	s.mu.Unlock()
+
+	var update bytes.Buffer
+	binary.Write(&update, binary.BigEndian, struct {
+		Type                uint8
+		_                   uint8
+		Rectangles          uint16
+		X, Y, Width, Height uint16
+		Encoding            int32
+	}{Rectangles: 1, Width: 64, Height: 48})
+	pixel := make([]byte, 4)
+	s.pf.put(pixel, c)
+	update.Write(bytes.Repeat(pixel, 64*48))
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.Write(update.Bytes())
+}
+
+// Requests returns the number of update requests received.
+func (s *fakeVNCServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Keys returns the keys pressed and the error that stopped the server.
+func (s *fakeVNCServer) Keys() ([]uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys, s.err
+}
+
+// connectFakeVNC connects a tracked framebuffer to a fake VNC server.
+func connectFakeVNC(t *testing.T) (*fakeVNCServer, *vncConn, *vncFramebuffer) {
+	srv, conn := newFakeVNCServer(t)
+	fb := newVNCFramebuffer()
+	t.Cleanup(fb.Close)
+	client, err := vnc.Client(conn, &vnc.ClientConfig{
+		Auth:            []vnc.ClientAuth{new(vnc.ClientAuthNone)},
+		ServerMessageCh: fb.Messages,
+	})
+	if err != nil {
+		t.Fatalf("handshake: %s", err)
+	}
+	c := &vncConn{ClientConn: client}
+	fb.Attach(c)
+	return srv, c, fb
+}
+
+// keyLog records the keys pressed by a boot command driver.
+type keyLog []uint32
+
+func (l *keyLog) KeyEvent(key uint32, down bool) error {
+	if down {
+		*l = append(*l, key)
+	}
+	return nil
+}
+
+func TestVNCRecorder_whileTyping(t *testing.T) {
+	srv, client, fb := connectFakeVNC(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	recorder := &vncRecorder{fb: fb, dir: dir, record: true, recordInterval: time.Millisecond}
+	if err := recorder.Start(ctx); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	// The screen keeps changing so that the recorder gets updates
+	go func() {
+		for i := 0; ctx.Err() == nil; i++ {
+			srv.SetColor(color.RGBA{R: uint8(i), A: 0xff})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	command := "install auto=true url=http://10.0.0.1:8080/preseed.cfg<enter>"
+	seq, err := bootcommand.GenerateExpressionSequence(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seq.Do(ctx, bootcommand.NewVNCDriver(client, time.Microsecond)); err != nil {
+		_, srvErr := srv.Keys()
+		t.Fatalf("typing: %s, VNC server: %v", err, srvErr)
+	}
+	var expected keyLog
+	seq.Do(ctx, bootcommand.NewVNCDriver(&expected, time.Microsecond))
+
+	recorder.Stop()
+	cancel()
+
+	var keys []uint32
+	for deadline := time.Now().Add(5 * time.Second); len(keys) < len(expected) && time.Now().Before(deadline); {
+		if keys, err = srv.Keys(); err != nil {
+			t.Fatalf("VNC stream corrupted: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !reflect.DeepEqual(keys, []uint32(expected)) {
+		t.Errorf("received %d keys, expected %d", len(keys), len(expected))
+	}
+	if info, err := os.Stat(filepath.Join(dir, "vnc-recording.mjpeg")); err != nil || info.Size() == 0 {
+		t.Errorf("nothing recorded: %v", err)
+	}
+}
+
+func TestVNCFramebuffer_concurrentRefresh(t *testing.T) {
+	srv, _, fb := connectFakeVNC(t)
+	ctx := context.Background()
+	if err := fb.Refresh(ctx, 5*time.Second); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+
+	// A screen wait and the recorder wait for the same update
+	const waiters = 3
+	elapsed := make(chan time.Duration, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			start := time.Now()
+			fb.Refresh(ctx, 5*time.Second)
+			elapsed <- time.Since(start)
+		}()
+	}
+	for srv.Requests() < 1+waiters {
+		time.Sleep(time.Millisecond)
+	}
+	red := color.RGBA{R: 0xff, A: 0xff}
+	srv.SetColor(red)
+
+	for i := 0; i < waiters; i++ {
+		if d := <-elapsed; d > 2*time.Second {
+			t.Errorf("Refresh returned after %s, expected the update to wake every waiter", d)
+		}
+	}
+	if c := fb.Image().RGBAAt(10, 10); c != red {
+		t.Errorf("pixel 10,10 is %v, expected red", c)
+	}
+}
@@ -0,0 +1,554 @@
+package opennebula
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/websocket"
+)
+
+// guacHandshakeTimeout bounds the wait for guacd to send the display size.
+const guacHandshakeTimeout = time.Minute
+
+// guacArity is the minimum number of arguments of the Guacamole
+// instructions handled by the bridge.
+var guacArity = map[string]int{
+	"sync":  1,
+	"error": 1,
+	"size":  3,
+	"img":   6,
+	"blob":  2,
+	"end":   1,
+	"png":   5,
+	"jpeg":  5,
+	"copy":  9,
+}
+
+// rfbPixelFormat is the PIXEL_FORMAT structure of RFB.
+type rfbPixelFormat struct {
+	BPP, Depth, BigEndian, TrueColor uint8
+	RedMax, GreenMax, BlueMax        uint16
+	RedShift, GreenShift, BlueShift  uint8
+	_                                [3]byte
+}
+
+// put encodes c into buf, which holds one pixel.
+func (pf rfbPixelFormat) put(buf []byte, c color.RGBA) {
+	v := uint32(c.R)*uint32(pf.RedMax)/255<<pf.RedShift |
+		uint32(c.G)*uint32(pf.GreenMax)/255<<pf.GreenShift |
+		uint32(c.B)*uint32(pf.BlueMax)/255<<pf.BlueShift
+	for i := range buf {
+		shift := 8 * i
+		if pf.BigEndian != 0 {
+			shift = 8 * (len(buf) - 1 - i)
+		}
+		buf[i] = byte(v >> shift)
+	}
+}
+
+// guacImageStream is an image guacd sends in blobs.
+type guacImageStream struct {
+	mask, layer string
+	x, y        int
+	data        bytes.Buffer
+}
+
+// guacBridge serves a Guacamole connection as an RFB one, so the boot
+// command can be typed and the screen captured through FireEdge. Key and
+// pointer events are forwarded to guacd, which draws the display in
+// images; the bridge composes them and serves the result as raw
+// framebuffer updates, one per frame guacd syncs. The RFB framebuffer keeps
+// the size the display had when connecting, later resizes are clipped.
+type guacBridge struct {
+	ws   *websocket.Conn
+	wsMu sync.Mutex
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	rfb     net.Conn
+	pf      rfbPixelFormat
+	display *image.RGBA
+	streams map[string]*guacImageStream
+	width   int
+	height  int
+	// frame counts the frames guacd synced, sent is the last one served
+	frame, sent int
+	// pending is set by a framebuffer update request
+	pending, incremental bool
+	closed               bool
+}
+
+func newGuacBridge(ws *websocket.Conn) *guacBridge {
+	b := &guacBridge{
+		ws: ws,
+		pf: rfbPixelFormat{
+			BPP: 32, Depth: 24, BigEndian: 1, TrueColor: 1,
+			RedMax: 255, GreenMax: 255, BlueMax: 255,
+			RedShift: 16, GreenShift: 8, BlueShift: 0,
+		},
+		streams: make(map[string]*guacImageStream),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Start waits for the size of the display, which the RFB handshake needs,
+// and returns the connection to hand to the VNC client.
+func (b *guacBridge) Start(ctx context.Context) (net.Conn, error) {
+	go b.readGuacamole()
+
+	ctx, cancel := context.WithTimeout(ctx, guacHandshakeTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		b.fail(fmt.Errorf("Error waiting for the display: %s", ctx.Err()))
+	})
+	defer stop()
+
+	b.mu.Lock()
+	for b.width == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return nil, errors.New("the connection was closed before the display was ready")
+	}
+	client, server := net.Pipe()
+	b.rfb = server
+	b.mu.Unlock()
+
+	go b.serveRFB()
+	return client, nil
+}
+
+// fail closes both sides of the bridge.
+func (b *guacBridge) fail(err error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	b.cond.Broadcast()
+	rfb := b.rfb
+	b.mu.Unlock()
+
+	log.Printf("[DEBUG] Closing the VNC proxy connection: %s", err)
+	b.ws.Close()
+	if rfb != nil {
+		rfb.Close()
+	}
+}
+
+func (b *guacBridge) send(opcode string, args ...string) error {
+	b.wsMu.Lock()
+	defer b.wsMu.Unlock()
+	return websocket.Message.Send(b.ws, encodeGuacInstruction(opcode, args...))
+}
+
+func (b *guacBridge) readGuacamole() {
+	var buf string
+	for {
+		var data string
+		if err := websocket.Message.Receive(b.ws, &data); err != nil {
+			b.fail(fmt.Errorf("Error reading from the VNC proxy: %s", err))
+			return
+		}
+		// Instructions may be split across messages
+		buf += data
+		for {
+			elements, rest, err := parseGuacInstruction(buf)
+			if err != nil {
+				b.fail(err)
+				return
+			}
+			if elements == nil {
+				break
+			}
+			buf = rest
+			if err := b.handle(elements[0], elements[1:]); err != nil {
+				b.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// handle applies an instruction of guacd. Layers other than the default
+// one, 0, are not visible and are ignored.
+func (b *guacBridge) handle(opcode string, args []string) error {
+	if len(args) < guacArity[opcode] {
+		return fmt.Errorf("malformed Guacamole instruction %q", opcode)
+	}
+
+	switch opcode {
+	case "sync":
+		b.mu.Lock()
+		b.frame++
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		return b.send("sync", args[0])
+	case "error":
+		return fmt.Errorf("VNC proxy error: %s", args[0])
+	case "disconnect":
+		return errors.New("VNC proxy disconnected")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch opcode {
+	case "size": // layer, width, height
+		size, err := guacInts(args[1:3])
+		if err != nil || args[0] != "0" {
+			return err
+		}
+		resized := image.NewRGBA(image.Rect(0, 0, size[0], size[1]))
+		if b.display != nil {
+			draw.Draw(resized, resized.Bounds(), b.display, image.Point{}, draw.Src)
+		}
+		b.display = resized
+		if b.width == 0 {
+			b.width, b.height = size[0], size[1]
+			b.cond.Broadcast()
+		}
+	case "img": // stream, mask, layer, mimetype, x, y
+		at, err := guacInts(args[4:6])
+		if err != nil {
+			return err
+		}
+		b.streams[args[0]] = &guacImageStream{mask: args[1], layer: args[2], x: at[0], y: at[1]}
+	case "blob": // stream, data
+		stream, ok := b.streams[args[0]]
+		if !ok {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(args[1])
+		if err != nil {
+			return fmt.Errorf("malformed Guacamole blob: %s", err)
+		}
+		stream.data.Write(data)
+	case "end": // stream
+		if stream, ok := b.streams[args[0]]; ok {
+			delete(b.streams, args[0])
+			b.drawImage(stream.mask, stream.layer, stream.x, stream.y, stream.data.Bytes())
+		}
+	case "png", "jpeg": // mask, layer, x, y, data
+		at, err := guacInts(args[2:4])
+		if err != nil {
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(args[4])
+		if err != nil {
+			return fmt.Errorf("malformed Guacamole %s: %s", opcode, err)
+		}
+		b.drawImage(args[0], args[1], at[0], at[1], data)
+	case "copy": // srclayer, srcx, srcy, width, height, mask, dstlayer, dstx, dsty
+		values, err := guacInts([]string{args[1], args[2], args[3], args[4], args[7], args[8]})
+		if err != nil || args[0] != "0" || args[6] != "0" || b.display == nil {
+			return err
+		}
+		src := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+		// Copy through a buffer as the regions may overlap
+		buf := image.NewRGBA(src)
+		draw.Draw(buf, src, b.display, src.Min, draw.Src)
+		dst := image.Rect(values[4], values[5], values[4]+values[2], values[5]+values[3])
+		draw.Draw(b.display, dst, buf, src.Min, draw.Src)
+	}
+	return nil
+}
+
+// drawImage draws an encoded image on the display, b.mu must be held.
+func (b *guacBridge) drawImage(mask, layer string, x, y int, data []byte) {
+	if layer != "0" || b.display == nil {
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[WARN] Skipping an image of the VNC proxy: %s", err)
+		return
+	}
+	// 12 is the SRC compositing mode, the others are drawn over
+	op := draw.Over
+	if mask == "12" {
+		op = draw.Src
+	}
+	at := image.Pt(x, y)
+	draw.Draw(b.display, image.Rectangle{Min: at, Max: at.Add(img.Bounds().Size())}, img, img.Bounds().Min, op)
+}
+
+func (b *guacBridge) serveRFB() {
+	err := b.rfbHandshake()
+	if err == nil {
+		go b.writeUpdates()
+		err = b.readClientMessages()
+	}
+	b.fail(err)
+}
+
+// rfbHandshake answers the handshake of the client as an RFB 3.8 server
+// without authentication.
+func (b *guacBridge) rfbHandshake() error {
+	if _, err := io.WriteString(b.rfb, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(b.rfb, version[:]); err != nil {
+		return err
+	}
+
+	// A single security type, None
+	if _, err := b.rfb.Write([]byte{1, 1}); err != nil {
+		return err
+	}
+	var securityType [1]byte
+	if _, err := io.ReadFull(b.rfb, securityType[:]); err != nil {
+		return err
+	}
+	if securityType[0] != 1 {
+		return fmt.Errorf("unsupported RFB security type %d", securityType[0])
+	}
+	if err := binary.Write(b.rfb, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+
+	// ClientInit, the shared flag does not matter
+	var shared [1]byte
+	if _, err := io.ReadFull(b.rfb, shared[:]); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	var init bytes.Buffer
+	binary.Write(&init, binary.BigEndian, uint16(b.width))
+	binary.Write(&init, binary.BigEndian, uint16(b.height))
+	binary.Write(&init, binary.BigEndian, b.pf)
+	b.mu.Unlock()
+	name := "OpenNebula (FireEdge)"
+	binary.Write(&init, binary.BigEndian, uint32(len(name)))
+	init.WriteString(name)
+	_, err := b.rfb.Write(init.Bytes())
+	return err
+}
+
+func (b *guacBridge) readClientMessages() error {
+	for {
+		var msgType uint8
+		if err := binary.Read(b.rfb, binary.BigEndian, &msgType); err != nil {
+			return err
+		}
+
+		switch msgType {
+		case 0: // SetPixelFormat
+			var msg struct {
+				_      [3]byte
+				Format rfbPixelFormat
+			}
+			if err := binary.Read(b.rfb, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if msg.Format.TrueColor == 0 || msg.Format.BPP%8 != 0 || msg.Format.BPP > 32 {
+				return fmt.Errorf("unsupported RFB pixel format: %d bits per pixel, true color %d", msg.Format.BPP, msg.Format.TrueColor)
+			}
+			b.mu.Lock()
+			b.pf = msg.Format
+			b.mu.Unlock()
+		case 2: // SetEncodings, updates are always raw
+			var msg struct {
+				_     uint8
+				Count uint16
+			}
+			if err := binary.Read(b.rfb, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, b.rfb, int64(msg.Count)*4); err != nil {
+				return err
+			}
+		case 3: // FramebufferUpdateRequest, always answered with the whole screen
+			var msg struct {
+				Incremental         uint8
+				X, Y, Width, Height uint16
+			}
+			if err := binary.Read(b.rfb, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			b.mu.Lock()
+			b.pending = true
+			b.incremental = msg.Incremental != 0
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		case 4: // KeyEvent, RFB and Guacamole both use X11 keysyms
+			var msg struct {
+				Down uint8
+				_    [2]byte
+				Key  uint32
+			}
+			if err := binary.Read(b.rfb, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			pressed := "0"
+			if msg.Down != 0 {
+				pressed = "1"
+			}
+			if err := b.send("key", strconv.FormatUint(uint64(msg.Key), 10), pressed); err != nil {
+				return err
+			}
+		case 5: // PointerEvent
+			var msg struct {
+				Mask uint8
+				X, Y uint16
+			}
+			if err := binary.Read(b.rfb, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if err := b.send("mouse", strconv.Itoa(int(msg.X)), strconv.Itoa(int(msg.Y)), strconv.Itoa(int(msg.Mask))); err != nil {
+				return err
+			}
+		case 6: // ClientCutText
+			var msg struct {
+				_      [3]byte
+				Length uint32
+			}
+			if err := binary.Read(b.rfb, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, b.rfb, int64(msg.Length)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported RFB message type %d", msgType)
+		}
+	}
+}
+
+// writeUpdates answers the framebuffer update requests. Incremental ones
+// are answered once guacd syncs a new frame.
+func (b *guacBridge) writeUpdates() {
+	for {
+		b.mu.Lock()
+		for !b.closed && !(b.pending && (!b.incremental || b.frame != b.sent)) {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		b.pending = false
+		b.sent = b.frame
+		update := b.framebufferUpdate()
+		b.mu.Unlock()
+
+		if _, err := b.rfb.Write(update); err != nil {
+			b.fail(err)
+			return
+		}
+	}
+}
+
+// framebufferUpdate encodes the display as a raw FramebufferUpdate, b.mu
+// must be held.
+func (b *guacBridge) framebufferUpdate() []byte {
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, struct {
+		Type                uint8
+		_                   uint8
+		Rectangles          uint16
+		X, Y, Width, Height uint16
+		Encoding            int32
+	}{Rectangles: 1, Width: uint16(b.width), Height: uint16(b.height)})
+
+	bpp := int(b.pf.BPP / 8)
+	update := make([]byte, header.Len()+b.width*b.height*bpp)
+	copy(update, header.Bytes())
+	pixels := update[header.Len():]
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			i := (y*b.width + x) * bpp
+			b.pf.put(pixels[i:i+bpp], b.display.RGBAAt(x, y))
+		}
+	}
+	return update
+}
+
+// encodeGuacInstruction encodes an instruction of the Guacamole protocol,
+// whose elements are prefixed with their length in characters.
+func encodeGuacInstruction(opcode string, args ...string) string {
+	var b strings.Builder
+	for i, element := range append([]string{opcode}, args...) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%d.%s", utf8.RuneCountInString(element), element)
+	}
+	b.WriteByte(';')
+	return b.String()
+}
+
+// parseGuacInstruction parses the first instruction of data and returns its
+// elements, opcode first, and the data left. The elements are nil when the
+// instruction is not complete yet.
+func parseGuacInstruction(data string) ([]string, string, error) {
+	var elements []string
+	pos := 0
+	for {
+		dot := strings.IndexByte(data[pos:], '.')
+		if dot < 0 {
+			return nil, data, nil
+		}
+		length, err := strconv.Atoi(data[pos : pos+dot])
+		if err != nil || length < 0 {
+			return nil, data, fmt.Errorf("malformed Guacamole instruction %q", data[pos:pos+dot])
+		}
+
+		start := pos + dot + 1
+		end := start
+		for i := 0; i < length; i++ {
+			if end >= len(data) {
+				return nil, data, nil
+			}
+			_, size := utf8.DecodeRuneInString(data[end:])
+			end += size
+		}
+		if end >= len(data) {
+			return nil, data, nil
+		}
+
+		elements = append(elements, data[start:end])
+		switch data[end] {
+		case ',':
+			pos = end + 1
+		case ';':
+			return elements, data[end+1:], nil
+		default:
+			return nil, data, fmt.Errorf("malformed Guacamole instruction: unexpected %q", data[end])
+		}
+	}
+}
+
+func guacInts(values []string) ([]int, error) {
+	ints := make([]int, len(values))
+	for i, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("malformed Guacamole instruction: %s", err)
+		}
+		ints[i] = n
+	}
+	return ints, nil
+}
@@ -0,0 +1,229 @@
+package opennebula
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+const defaultVNCProxyPort = "29876"
+
+var sunstoneCSRFToken = regexp.MustCompile(`csrftoken\s*=\s*['"]([^'"]+)['"]`)
+
+// sunstoneVNC is the answer of Sunstone to a startvnc request.
+type sunstoneVNC struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// dialSunstoneVNC opens the VNC console of a VM through the websocket proxy
+// of Sunstone (noVNC), so that only the frontend needs to be reachable. It
+// returns the RFB stream and the VNC password of the VM.
+func dialSunstoneVNC(ctx context.Context, sunstoneURL, websocketURL, username, password string, insecure bool, vmID int) (net.Conn, string, error) {
+	base, err := url.Parse(strings.TrimSuffix(sunstoneURL, "/"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid vnc_proxy_url: %s", err)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	client := &http.Client{
+		Jar:       jar,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	// Log in to get a session, then read its CSRF token from the index page
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.String()+"/login", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.SetBasicAuth(username, password)
+	if _, err := doProxyRequest(client, req); err != nil {
+		return nil, "", fmt.Errorf("Error logging in to Sunstone: %s", err)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, base.String()+"/", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	index, err := doProxyRequest(client, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error getting the Sunstone session: %s", err)
+	}
+	match := sunstoneCSRFToken.FindSubmatch(index)
+	if match == nil {
+		return nil, "", errors.New("Error getting the Sunstone session: CSRF token not found")
+	}
+
+	form := url.Values{"csrftoken": {string(match[1])}}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/vm/%d/startvnc", base, vmID), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := doProxyRequest(client, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error requesting a VNC token: %s", err)
+	}
+	var vnc sunstoneVNC
+	if err := json.Unmarshal(body, &vnc); err != nil {
+		return nil, "", fmt.Errorf("Error decoding the VNC token: %s", err)
+	}
+
+	if websocketURL == "" {
+		scheme := "ws"
+		if base.Scheme == "https" {
+			scheme = "wss"
+		}
+		websocketURL = fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(base.Hostname(), defaultVNCProxyPort))
+	}
+	wsURL, err := url.Parse(websocketURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid vnc_proxy_websocket_url: %s", err)
+	}
+	query := wsURL.Query()
+	query.Set("token", vnc.Token)
+	wsURL.RawQuery = query.Encode()
+
+	wsConfig, err := websocket.NewConfig(wsURL.String(), base.Scheme+"://"+base.Host)
+	if err != nil {
+		return nil, "", err
+	}
+	wsConfig.Protocol = []string{"binary"}
+	wsConfig.TlsConfig = tlsConfig
+
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error connecting to the VNC proxy: %s", err)
+	}
+	// RFB is carried in binary frames
+	conn.PayloadType = websocket.BinaryFrame
+
+	return conn, vnc.Password, nil
+}
+
+// fireEdgeResponse is the envelope of the answers of the FireEdge API.
+type fireEdgeResponse struct {
+	ID      int             `json:"id"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// dialFireEdgeVNC opens the VNC console of a VM through FireEdge. FireEdge
+// serves consoles over the Guacamole protocol instead of RFB, so the
+// returned connection is a bridge that go-vnc can handshake with (see
+// guacBridge). guacd authenticates to the VNC server, so the bridge asks for
+// no password.
+func dialFireEdgeVNC(ctx context.Context, fireEdgeURL, websocketURL, username, password string, insecure bool, vmID int) (net.Conn, error) {
+	base, err := url.Parse(strings.TrimSuffix(fireEdgeURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid vnc_proxy_url: %s", err)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	// Log in to get a JWT, then request a Guacamole token for the VM
+	credentials, err := json.Marshal(map[string]string{"user": username, "token": password})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.String()+"/api/auth", bytes.NewReader(credentials))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := doFireEdgeRequest(client, req, &session); err != nil {
+		return nil, fmt.Errorf("Error logging in to FireEdge: %s", err)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/vm/%d/guacamole/vnc", base, vmID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	var token string
+	if err := doFireEdgeRequest(client, req, &token); err != nil {
+		return nil, fmt.Errorf("Error requesting a VNC token: %s", err)
+	}
+
+	if websocketURL == "" {
+		scheme := "ws"
+		if base.Scheme == "https" {
+			scheme = "wss"
+		}
+		websocketURL = fmt.Sprintf("%s://%s%s/guacamole", scheme, base.Host, base.Path)
+	}
+	wsURL, err := url.Parse(websocketURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vnc_proxy_websocket_url: %s", err)
+	}
+	query := wsURL.Query()
+	query.Set("token", token)
+	wsURL.RawQuery = query.Encode()
+
+	wsConfig, err := websocket.NewConfig(wsURL.String(), base.Scheme+"://"+base.Host)
+	if err != nil {
+		return nil, err
+	}
+	wsConfig.Protocol = []string{"guacamole"}
+	wsConfig.TlsConfig = tlsConfig
+
+	ws, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to the VNC proxy: %s", err)
+	}
+
+	conn, err := newGuacBridge(ws).Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to the VNC proxy: %s", err)
+	}
+	return conn, nil
+}
+
+// doFireEdgeRequest sends req and decodes the data of the answer into data.
+func doFireEdgeRequest(client *http.Client, req *http.Request, data interface{}) error {
+	body, err := doProxyRequest(client, req)
+	if err != nil {
+		return err
+	}
+	var resp fireEdgeResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Data, data)
+}
+
+func doProxyRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,178 @@
+package opennebula
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-vnc"
+	"golang.org/x/net/websocket"
+)
+
+// newFireEdgeServer starts a fake FireEdge serving the console of VM 7: the
+// display is 64x48 and black until draw is closed, then a red 8x8 square is
+// drawn at 4,4. The instructions the client sends are passed on received.
+func newFireEdgeServer(t *testing.T, draw <-chan struct{}, received chan<- string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fireedge/api/auth", func(w http.ResponseWriter, r *http.Request) {
+		var credentials map[string]string
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["user"] != "oneadmin" || credentials["token"] != "opennebula" {
+			http.Error(w, `{"id":401}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id":200,"message":"OK","data":{"token":"jwt","id":"0"}}`))
+	})
+	mux.HandleFunc("/fireedge/api/vm/7/guacamole/vnc", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer jwt" {
+			http.Error(w, `{"id":401}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id":200,"message":"OK","data":"guacamole-token"}`))
+	})
+	mux.Handle("/fireedge/guacamole", websocket.Handler(func(ws *websocket.Conn) {
+		if ws.Request().URL.Query().Get("token") != "guacamole-token" {
+			return
+		}
+		websocket.Message.Send(ws, encodeGuacInstruction("size", "0", "64", "48"))
+		go func() {
+			<-draw
+
+			square := image.NewRGBA(image.Rect(0, 0, 8, 8))
+			for i := range square.Pix {
+				if i%4 == 0 || i%4 == 3 {
+					square.Pix[i] = 0xff
+				}
+			}
+			var encoded bytes.Buffer
+			png.Encode(&encoded, square)
+			data := base64.StdEncoding.EncodeToString(encoded.Bytes())
+
+			// Instructions are split across messages
+			frame := encodeGuacInstruction("img", "1", "14", "0", "image/png", "4", "4") +
+				encodeGuacInstruction("blob", "1", data[:12]) +
+				encodeGuacInstruction("blob", "1", data[12:]) +
+				encodeGuacInstruction("end", "1") +
+				encodeGuacInstruction("sync", "1700000000")
+			websocket.Message.Send(ws, frame[:20])
+			websocket.Message.Send(ws, frame[20:])
+		}()
+
+		for {
+			var data string
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				return
+			}
+			received <- data
+		}
+	}))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDialFireEdgeVNC(t *testing.T) {
+	draw := make(chan struct{})
+	received := make(chan string, 16)
+	srv := newFireEdgeServer(t, draw, received)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := dialFireEdgeVNC(ctx, srv.URL+"/fireedge/", "", "oneadmin", "opennebula", false, 7)
+	if err != nil {
+		t.Fatalf("dialFireEdgeVNC: %s", err)
+	}
+	defer conn.Close()
+
+	fb := newVNCFramebuffer()
+	defer fb.Close()
+	client, err := vnc.Client(conn, &vnc.ClientConfig{
+		Auth:            []vnc.ClientAuth{new(vnc.ClientAuthNone)},
+		ServerMessageCh: fb.Messages,
+	})
+	if err != nil {
+		t.Fatalf("handshake: %s", err)
+	}
+	defer client.Close()
+	if client.FrameBufferWidth != 64 || client.FrameBufferHeight != 48 {
+		t.Fatalf("framebuffer is %dx%d, expected 64x48", client.FrameBufferWidth, client.FrameBufferHeight)
+	}
+	fb.Attach(&vncConn{ClientConn: client})
+
+	if err := fb.Refresh(ctx, 5*time.Second); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if c := fb.Image().RGBAAt(5, 5); c != (color.RGBA{A: 0xff}) {
+		t.Errorf("pixel 5,5 is %v before drawing, expected black", c)
+	}
+
+	// The incremental update waits for guacd to sync the next frame
+	close(draw)
+	if err := fb.Refresh(ctx, 5*time.Second); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	img := fb.Image()
+	if c := img.RGBAAt(5, 5); c != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("pixel 5,5 is %v, expected red", c)
+	}
+	if c := img.RGBAAt(12, 12); c != (color.RGBA{A: 0xff}) {
+		t.Errorf("pixel 12,12 is %v, expected black", c)
+	}
+
+	if err := client.KeyEvent(0xff0d, true); err != nil {
+		t.Fatalf("KeyEvent: %s", err)
+	}
+	expected := []string{
+		encodeGuacInstruction("sync", "1700000000"),
+		encodeGuacInstruction("key", "65293", "1"),
+	}
+	for _, instruction := range expected {
+		select {
+		case data := <-received:
+			if data != instruction {
+				t.Errorf("received %q, expected %q", data, instruction)
+			}
+		case <-ctx.Done():
+			t.Fatalf("%q not received", instruction)
+		}
+	}
+}
+
+func TestDialFireEdgeVNC_authenticationError(t *testing.T) {
+	srv := newFireEdgeServer(t, nil, nil)
+
+	_, err := dialFireEdgeVNC(context.Background(), srv.URL+"/fireedge", "", "oneadmin", "wrong", false, 7)
+	if err == nil {
+		t.Fatal("dialFireEdgeVNC succeeded, expected the login to fail")
+	}
+}
+
+func TestParseGuacInstruction(t *testing.T) {
+	elements, rest, err := parseGuacInstruction("4.size,1.0,2.64,2.48;3.nop;")
+	if err != nil {
+		t.Fatalf("parseGuacInstruction: %s", err)
+	}
+	if len(elements) != 4 || elements[0] != "size" || elements[2] != "64" || rest != "3.nop;" {
+		t.Errorf("parsed %q, %q", elements, rest)
+	}
+
+	// Lengths count characters, not bytes
+	if elements, _, _ := parseGuacInstruction("5.error,5.échec,3.519;"); len(elements) != 3 || elements[1] != "échec" {
+		t.Errorf("parsed %q, expected the error message", elements)
+	}
+	if elements, rest, _ := parseGuacInstruction("4.size,1.0,2.6"); elements != nil || rest != "4.size,1.0,2.6" {
+		t.Errorf("parsed %q from an incomplete instruction", elements)
+	}
+	if _, _, err := parseGuacInstruction("4.size+1.0;"); err == nil {
+		t.Error("parsed a malformed instruction")
+	}
+}
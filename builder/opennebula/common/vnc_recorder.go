@@ -0,0 +1,87 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// vncRecorder periodically captures the framebuffer into PNG screenshots
+// and/or an MJPEG recording (a stream of concatenated JPEG frames).
+type vncRecorder struct {
+	fb                 *vncFramebuffer
+	dir                string
+	screenshotInterval time.Duration
+	record             bool
+	recordInterval     time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start begins capturing in the background until Stop is called.
+func (r *vncRecorder) Start(ctx context.Context) error {
+	interval := r.screenshotInterval
+	var recording *os.File
+	if r.record {
+		interval = r.recordInterval
+		var err error
+		recording, err = os.Create(filepath.Join(r.dir, "vnc-recording.mjpeg"))
+		if err != nil {
+			return err
+		}
+	}
+	if interval <= 0 {
+		return nil
+	}
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if recording != nil {
+			defer recording.Close()
+		}
+
+		var lastScreenshot time.Time
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := r.fb.Refresh(ctx, interval/2); err != nil {
+				return
+			}
+			if recording != nil {
+				if err := jpeg.Encode(recording, r.fb.Image(), nil); err != nil {
+					log.Printf("[WARN] Failed to record VNC frame: %s", err)
+				}
+			}
+			if r.screenshotInterval > 0 && time.Since(lastScreenshot) >= r.screenshotInterval {
+				lastScreenshot = time.Now()
+				name := fmt.Sprintf("vnc-%s.png", lastScreenshot.Format("20060102-150405"))
+				if err := r.fb.SavePNG(filepath.Join(r.dir, name)); err != nil {
+					log.Printf("[WARN] Failed to save VNC screenshot: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops capturing and waits for the last frame to be written.
+func (r *vncRecorder) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
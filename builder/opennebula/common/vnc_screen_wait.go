@@ -0,0 +1,194 @@
+package opennebula
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// screenWaitPollInterval is the delay between two checks of the screen.
+var screenWaitPollInterval = 500 * time.Millisecond
+
+const (
+	defaultScreenWaitTime = 5 * time.Minute
+	// Channel difference below which two pixels are considered equal, so
+	// that blinking cursors and compression noise do not count as changes.
+	screenPixelThreshold = 16
+)
+
+// screenWait is a boot step that waits until the VNC framebuffer meets a
+// condition before typing continues. Two directives are supported:
+//
+//	<waitScreenStatic 5s [region=x,y,w,h] [tolerance=0.01] [timeout=5m]>
+//	<waitScreenMatch ref.png [region=x,y,w,h] [tolerance=0.01] [timeout=5m]>
+//
+// waitScreenStatic waits until the screen (or region) has not changed for
+// the given duration, waitScreenMatch until it matches the reference PNG.
+// The tolerance is the fraction of pixels allowed to differ.
+type screenWait struct {
+	Kind      string
+	Duration  time.Duration
+	Reference string
+	Region    image.Rectangle
+	Tolerance float64
+	Timeout   time.Duration
+}
+
+// parseScreenWait parses a screen wait directive. ok is false if cmd is not
+// one, so it can be typed as a regular boot command.
+func parseScreenWait(cmd string) (w *screenWait, ok bool, err error) {
+	cmd = strings.TrimSpace(cmd)
+	if !strings.HasPrefix(cmd, "<waitScreen") || !strings.HasSuffix(cmd, ">") {
+		return nil, false, nil
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(cmd, "<"), ">"))
+	w = &screenWait{Kind: fields[0], Timeout: defaultScreenWaitTime}
+	if len(fields) < 2 {
+		return nil, true, fmt.Errorf("%s requires an argument", w.Kind)
+	}
+
+	switch w.Kind {
+	case "waitScreenStatic":
+		if w.Duration, err = time.ParseDuration(fields[1]); err != nil {
+			return nil, true, fmt.Errorf("%s: %s", w.Kind, err)
+		}
+	case "waitScreenMatch":
+		w.Reference = fields[1]
+	default:
+		return nil, true, fmt.Errorf("unknown screen wait directive %s", w.Kind)
+	}
+
+	for _, opt := range fields[2:] {
+		key, value, found := strings.Cut(opt, "=")
+		if !found {
+			return nil, true, fmt.Errorf("%s: invalid option %q", w.Kind, opt)
+		}
+		switch key {
+		case "timeout":
+			w.Timeout, err = time.ParseDuration(value)
+		case "tolerance":
+			w.Tolerance, err = strconv.ParseFloat(value, 64)
+		case "region":
+			w.Region, err = parseRegion(value)
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %s", w.Kind, err)
+		}
+	}
+
+	return w, true, nil
+}
+
+func parseRegion(value string) (image.Rectangle, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("region must be x,y,width,height, got %q", value)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid region %q: %s", value, err)
+		}
+		v[i] = n
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// Wait blocks until the condition is met, the timeout expires or ctx is done.
+func (w *screenWait) Wait(ctx context.Context, fb *vncFramebuffer) error {
+	var reference image.Image
+	if w.Kind == "waitScreenMatch" {
+		f, err := os.Open(w.Reference)
+		if err != nil {
+			return err
+		}
+		reference, err = png.Decode(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Error decoding %s: %s", w.Reference, err)
+		}
+	}
+
+	deadline := time.Now().Add(w.Timeout)
+	var previous *image.RGBA
+	var stableSince time.Time
+	for {
+		if err := fb.Refresh(ctx, screenWaitPollInterval); err != nil {
+			return err
+		}
+
+		current := fb.Image()
+		region := w.Region
+		if region.Empty() {
+			region = current.Rect
+		}
+		if !region.In(current.Rect) {
+			return fmt.Errorf("region %v is outside of the %v screen", region, current.Rect.Size())
+		}
+
+		switch w.Kind {
+		case "waitScreenStatic":
+			if previous == nil || screenDiff(previous, region, current, region.Min) > w.Tolerance {
+				stableSince = time.Now()
+			} else if time.Since(stableSince) >= w.Duration {
+				return nil
+			}
+			previous = current
+		case "waitScreenMatch":
+			if reference.Bounds().Size() != region.Size() {
+				return fmt.Errorf("reference %s is %v, expected the region size %v", w.Reference, reference.Bounds().Size(), region.Size())
+			}
+			if screenDiff(reference, reference.Bounds(), current, region.Min) <= w.Tolerance {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s", w.Timeout, w.Kind)
+		}
+
+		select {
+		case <-time.After(screenWaitPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// screenDiff returns the fraction of pixels of a within r that differ from
+// the pixels of b starting at offset.
+func screenDiff(a image.Image, r image.Rectangle, b image.Image, offset image.Point) float64 {
+	if r.Empty() {
+		return 0
+	}
+
+	differ := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ar, ag, ab, _ := a.At(x, y).RGBA()
+			br, bg, bb, _ := b.At(offset.X+x-r.Min.X, offset.Y+y-r.Min.Y).RGBA()
+			if channelDiff(ar, br) > screenPixelThreshold || channelDiff(ag, bg) > screenPixelThreshold || channelDiff(ab, bb) > screenPixelThreshold {
+				differ++
+			}
+		}
+	}
+	return float64(differ) / float64(r.Dx()*r.Dy())
+}
+
+// channelDiff compares two 16 bit color channels on an 8 bit scale.
+func channelDiff(a, b uint32) uint32 {
+	a, b = a>>8, b>>8
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,183 @@
+package opennebula
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScreenWait(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected *screenWait
+		ok       bool
+		err      string
+	}{
+		{"<enter>", nil, false, ""},
+		{"<wait5s>", nil, false, ""},
+		{"<waitScreenStatic 5s>", &screenWait{Kind: "waitScreenStatic", Duration: 5 * time.Second, Timeout: defaultScreenWaitTime}, true, ""},
+		{" <waitScreenStatic 2s region=10,20,100,50 tolerance=0.05 timeout=1m> ", &screenWait{
+			Kind:      "waitScreenStatic",
+			Duration:  2 * time.Second,
+			Region:    image.Rect(10, 20, 110, 70),
+			Tolerance: 0.05,
+			Timeout:   time.Minute,
+		}, true, ""},
+		{"<waitScreenMatch login.png timeout=30s>", &screenWait{Kind: "waitScreenMatch", Reference: "login.png", Timeout: 30 * time.Second}, true, ""},
+		{"<waitScreenStatic>", nil, true, "requires an argument"},
+		{"<waitScreenStatic soon>", nil, true, "invalid duration"},
+		{"<waitScreenStatic 5s timeout=later>", nil, true, "invalid duration"},
+		{"<waitScreenStatic 5s tolerance=low>", nil, true, "invalid syntax"},
+		{"<waitScreenStatic 5s region=10,20,100>", nil, true, "region must be x,y,width,height"},
+		{"<waitScreenStatic 5s region=10,20,100,high>", nil, true, "invalid region"},
+		{"<waitScreenStatic 5s 10s>", nil, true, "invalid option"},
+		{"<waitScreenStatic 5s color=red>", nil, true, "unknown option"},
+		{"<waitScreenGone 5s>", nil, true, "unknown screen wait directive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			w, ok, err := parseScreenWait(tt.cmd)
+			if ok != tt.ok {
+				t.Fatalf("ok = %t, expected %t", ok, tt.ok)
+			}
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("parseScreenWait: %s", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("parseScreenWait succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("parseScreenWait: %s, expected an error containing %q", err, tt.err)
+			}
+			if !reflect.DeepEqual(w, tt.expected) {
+				t.Errorf("parsed %+v, expected %+v", w, tt.expected)
+			}
+		})
+	}
+}
+
+// fillImage returns an image of the size filled with c.
+func fillImage(width, height int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestScreenDiff(t *testing.T) {
+	black := fillImage(10, 10, color.RGBA{A: 0xff})
+	noisy := fillImage(10, 10, color.RGBA{R: 10, G: 10, B: 10, A: 0xff})
+	changed := fillImage(10, 10, color.RGBA{A: 0xff})
+	for x := 0; x < 10; x++ {
+		changed.SetRGBA(x, 0, color.RGBA{R: 0xff, A: 0xff})
+	}
+
+	tests := []struct {
+		name     string
+		b        image.Image
+		r        image.Rectangle
+		offset   image.Point
+		expected float64
+	}{
+		{"same", black, black.Rect, image.Point{}, 0},
+		{"below the pixel threshold", noisy, black.Rect, image.Point{}, 0},
+		{"one row", changed, black.Rect, image.Point{}, 0.1},
+		{"region", changed, image.Rect(0, 1, 10, 10), image.Pt(0, 1), 0},
+		{"offset", changed, image.Rect(0, 0, 10, 5), image.Pt(0, 0), 0.2},
+		{"empty region", changed, image.Rectangle{}, image.Point{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := screenDiff(black, tt.r, tt.b, tt.offset); diff != tt.expected {
+				t.Errorf("screenDiff = %v, expected %v", diff, tt.expected)
+			}
+		})
+	}
+}
+
+// writeReference writes a PNG reference screen of the size filled with c.
+func writeReference(t *testing.T, width, height int, c color.RGBA) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "reference.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, fillImage(width, height, c)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScreenWait(t *testing.T) {
+	red := color.RGBA{R: 0xff, A: 0xff}
+	redScreen := writeReference(t, 64, 48, red)
+	redSquare := writeReference(t, 8, 8, red)
+
+	tests := []struct {
+		name string
+		cmd  string
+		// screen is how the screen changes while waiting: it turns red after
+		// a while, keeps changing or stays black
+		screen string
+		err    string
+	}{
+		{"static", "<waitScreenStatic 50ms timeout=5s>", "black", ""},
+		{"static once red", "<waitScreenStatic 200ms timeout=5s>", "red", ""},
+		{"never static", "<waitScreenStatic 50ms timeout=200ms>", "changing", "timed out after 200ms"},
+		{"region outside the screen", "<waitScreenStatic 50ms region=60,40,10,10>", "black", "outside of the"},
+		{"match", "<waitScreenMatch " + redScreen + " timeout=5s>", "red", ""},
+		{"match region", "<waitScreenMatch " + redSquare + " region=4,4,8,8 timeout=5s>", "red", ""},
+		{"no match", "<waitScreenMatch " + redScreen + " timeout=200ms>", "black", "timed out after 200ms"},
+		{"reference size", "<waitScreenMatch " + redSquare + " timeout=5s>", "red", "expected the region size"},
+		{"missing reference", "<waitScreenMatch missing.png>", "black", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, fb := connectFakeVNC(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := fb.Refresh(ctx, 5*time.Second); err != nil {
+				t.Fatalf("Refresh: %s", err)
+			}
+			switch tt.screen {
+			case "red":
+				time.AfterFunc(20*time.Millisecond, func() { srv.SetColor(red) })
+			case "changing":
+				go func() {
+					for i := 0; ctx.Err() == nil; i++ {
+						srv.SetColor(color.RGBA{B: uint8(i * 64), A: 0xff})
+						time.Sleep(5 * time.Millisecond)
+					}
+				}()
+			}
+
+			w, _, err := parseScreenWait(tt.cmd)
+			if err != nil {
+				t.Fatalf("parseScreenWait: %s", err)
+			}
+			err = w.Wait(ctx, fb)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("Wait: %s", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("Wait succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Wait: %s, expected an error containing %q", err, tt.err)
+			}
+			if tt.err == "" && tt.screen == "red" && fb.Image().RGBAAt(10, 10) != red {
+				t.Error("Wait returned before the screen turned red")
+			}
+		})
+	}
+}
@@ -34,7 +34,7 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		b.config.HTTPPortMax = 9000
 	}
 
-	return nil, warnings, nil
+	return onecommon.GeneratedDataKeys, warnings, nil
 }
 
 func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
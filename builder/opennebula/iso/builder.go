@@ -35,18 +35,21 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		b.config.HTTPPortMax = 9000
 	}
 
-	return nil, warnings, nil
+	return onecommon.GeneratedDataKeys, warnings, nil
 }
 
 func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
 	state := new(multistep.BasicStateBag)
 	state.Put("iso-config", &b.config)
 
+	vncBootCommand := b.config.StepVNCBootCommand
 	IsoPreSteps := []multistep.Step{
-		&onecommon.StepVNCBootCommand{
-			VNCConfig: b.config.VNCConfig,
-		},
+		&vncBootCommand,
 		&onecommon.StepDetachISO{},
+		&onecommon.StepUpdateBootOrder{
+			Boot:    b.config.VMTemplateConfig.PostInstallBoot,
+			Timeout: b.config.VMTemplateConfig.OSInstallTimeout,
+		},
 		&onecommon.StepStartVM{},
 	}
 
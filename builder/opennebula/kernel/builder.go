@@ -0,0 +1,72 @@
+package kernel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+)
+
+// The unique id for the builder
+const BuilderID = "opennebula.kernel"
+
+type Builder struct {
+	config onecommon.Config
+}
+
+// Builder implements packersdk.Builder
+var _ packersdk.Builder = &Builder{}
+
+func (b *Builder) ConfigSpec() hcldec.ObjectSpec { return b.config.FlatMapstructure().HCL2Spec() }
+
+func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
+	warnings, errs := b.config.Prepare(raws...)
+	if errs != nil {
+		return nil, warnings, errs
+	}
+	if !b.config.VMTemplateConfig.HasKernel() {
+		return nil, warnings, errors.New("vm_os_kernel or vm_os_kernel_path must be specified")
+	}
+	if b.config.HTTPPortMin == 0 {
+		b.config.HTTPPortMin = 8000
+	}
+
+	if b.config.HTTPPortMax == 0 {
+		b.config.HTTPPortMax = 9000
+	}
+
+	return onecommon.GeneratedDataKeys, warnings, nil
+}
+
+// Run boots the kernel with its command line and waits for the unattended
+// installation to power off the VM, without typing a boot command. The VM
+// is then started from its disks for provisioning.
+func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
+	vmc := b.config.VMTemplateConfig
+	KernelPreSteps := []multistep.Step{
+		&onecommon.StepWaitForInstall{
+			Timeout: vmc.OSInstallTimeout,
+		},
+		&onecommon.StepUpdateBootOrder{
+			Boot:       vmc.PostInstallBoot,
+			DropKernel: true,
+			Timeout:    vmc.OSInstallTimeout,
+		},
+		&onecommon.StepStartVM{},
+	}
+
+	sb := onecommon.NewSharedBuilder(BuilderID, b.config, KernelPreSteps)
+	sb.SourceSteps = []multistep.Step{
+		&onecommon.StepPrepareKernel{
+			Kernel:      vmc.OSKernel,
+			KernelPath:  vmc.OSKernelPath,
+			Initrd:      vmc.OSInitrd,
+			InitrdPath:  vmc.OSInitrdPath,
+			DatastoreID: vmc.OSKernelDatastoreID,
+		},
+	}
+	return sb.Run(ctx, ui, hook)
+}
@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+)
+
+// The unique id for the builder
+const BuilderID = "opennebula.vm"
+
+type Builder struct {
+	config onecommon.Config
+}
+
+// Builder implements packersdk.Builder
+var _ packersdk.Builder = &Builder{}
+
+func (b *Builder) ConfigSpec() hcldec.ObjectSpec { return b.config.FlatMapstructure().HCL2Spec() }
+
+func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
+	warnings, errs := b.config.Prepare(raws...)
+	if errs != nil {
+		return nil, warnings, errs
+	}
+	if b.config.SourceVMID == nil {
+		return nil, warnings, errors.New("source_vm_id must be specified")
+	}
+	if b.config.HTTPPortMin == 0 {
+		b.config.HTTPPortMin = 8000
+	}
+
+	if b.config.HTTPPortMax == 0 {
+		b.config.HTTPPortMax = 9000
+	}
+
+	return onecommon.GeneratedDataKeys, warnings, nil
+}
+
+func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
+	sb := onecommon.NewSharedBuilder(BuilderID, b.config, []multistep.Step{})
+	sb.SourceSteps = []multistep.Step{
+		&onecommon.StepCopySourceVM{
+			VMID: *b.config.SourceVMID,
+		},
+	}
+	return sb.Run(ctx, ui, hook)
+}
@@ -0,0 +1,44 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuilderPrepare_sourceVMID(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string]interface{}
+		err  string
+	}{
+		{"missing", nil, "source_vm_id must be specified"},
+		{"VM 0", map[string]interface{}{"source_vm_id": 0}, ""},
+		{"VM 42", map[string]interface{}{"source_vm_id": 42}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{
+				"opennebula_url": "https://one.example.com:2633/RPC2",
+				"username":       "oneadmin",
+				"password":       "opennebula",
+				"communicator":   "none",
+			}
+			for k, v := range tt.raw {
+				config[k] = v
+			}
+
+			var b Builder
+			_, _, err := b.Prepare(config)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("Prepare: %s", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("Prepare succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Prepare: %s, expected an error containing %q", err, tt.err)
+			case tt.err == "" && *b.config.SourceVMID != tt.raw["source_vm_id"]:
+				t.Errorf("source_vm_id = %d, expected %v", *b.config.SourceVMID, tt.raw["source_vm_id"])
+			}
+		})
+	}
+}
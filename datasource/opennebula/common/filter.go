@@ -0,0 +1,76 @@
+//go:generate packer-sdc struct-markdown
+package opennebula
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+)
+
+// Filter holds the filters the data sources share.
+type Filter struct {
+	// Regular expression the name must match.
+	NameRegex string `mapstructure:"name_regex" required:"false"`
+	// Labels the resource must have, e.g. `["golden", "ubuntu"]`.
+	Labels []string `mapstructure:"labels" required:"false"`
+	// Name or ID of the owner.
+	Owner string `mapstructure:"owner" required:"false"`
+
+	nameRegex *regexp.Regexp
+}
+
+func (f *Filter) Prepare() []error {
+	var errs []error
+	var err error
+	if f.nameRegex, err = regexp.Compile(f.NameRegex); err != nil {
+		errs = append(errs, fmt.Errorf("invalid name_regex: %s", err))
+	}
+	return errs
+}
+
+// Match reports whether a resource with the given name, owner and LABELS
+// attribute passes the filters.
+func (f *Filter) Match(name string, uid int, uname string, labels string) bool {
+	if f.nameRegex != nil && !f.nameRegex.MatchString(name) {
+		return false
+	}
+	if f.Owner != "" && f.Owner != uname && f.Owner != strconv.Itoa(uid) {
+		return false
+	}
+	for _, label := range f.Labels {
+		if !hasLabel(labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchID reports whether value, a name or ID, designates the resource.
+func MatchID(value string, id int, name string) bool {
+	return value == "" || value == name || value == strconv.Itoa(id)
+}
+
+// hasLabel reports whether label is one of the comma separated labels.
+func hasLabel(labels, label string) bool {
+	for _, l := range strings.Split(labels, ",") {
+		if strings.TrimSpace(l) == label {
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterID resolves the name or ID of a cluster.
+func ClusterID(controller *goca.Controller, value string) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	id, err := controller.Clusters().ByName(value)
+	if err != nil {
+		return 0, fmt.Errorf("Error finding cluster %s: %s", value, err)
+	}
+	return id, nil
+}
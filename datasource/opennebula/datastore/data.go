@@ -0,0 +1,164 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,DatasourceOutput
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	ds "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/datastore"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/hcl2helper"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	dscommon "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/common"
+)
+
+// datastoreTypes are the names of the datastore types, indexed by the TYPE
+// value of the datastore pool.
+var datastoreTypes = []string{"IMAGE", "SYSTEM", "FILE"}
+
+type Config struct {
+	onecommon.OpenNebulaConnect `mapstructure:",squash"`
+	dscommon.Filter             `mapstructure:",squash"`
+	// Type of the datastore, `IMAGE`, `SYSTEM` or `FILE`.
+	Type string `mapstructure:"type" required:"false"`
+	// Name or ID of a cluster the datastore must belong to.
+	Cluster string `mapstructure:"cluster" required:"false"`
+	// Minimum free space of the datastore in MB.
+	MinFreeMB int `mapstructure:"min_free_mb" required:"false"`
+	// Pick the datastore with the most free space when several match,
+	// instead of failing.
+	MostFree bool `mapstructure:"most_free" required:"false"`
+}
+
+type Datasource struct {
+	config Config
+}
+
+type DatasourceOutput struct {
+	// ID of the datastore.
+	ID int `mapstructure:"id"`
+	// Name of the datastore.
+	Name string `mapstructure:"name"`
+	// Type of the datastore.
+	Type string `mapstructure:"type"`
+	// Datastore driver, e.g. `fs` or `ceph`.
+	DSMad string `mapstructure:"ds_mad"`
+	// Transfer driver, e.g. `qcow2` or `ceph`.
+	TMMad string `mapstructure:"tm_mad"`
+	// Total space of the datastore in MB.
+	TotalMB int `mapstructure:"total_mb"`
+	// Free space of the datastore in MB.
+	FreeMB int `mapstructure:"free_mb"`
+	// Used space of the datastore in MB.
+	UsedMB int `mapstructure:"used_mb"`
+	// IDs of the clusters of the datastore.
+	ClusterIDs []int `mapstructure:"cluster_ids"`
+}
+
+func (d *Datasource) ConfigSpec() hcldec.ObjectSpec {
+	return d.config.FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Configure(raws ...interface{}) error {
+	err := config.Decode(&d.config, nil, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, d.config.OpenNebulaConnect.Prepare()...)
+	errs = packersdk.MultiErrorAppend(errs, d.config.Filter.Prepare()...)
+	if d.config.Type != "" && !slices.Contains(datastoreTypes, strings.ToUpper(d.config.Type)) {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid type %q, expected \"IMAGE\", \"SYSTEM\" or \"FILE\"", d.config.Type))
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (d *Datasource) OutputSpec() hcldec.ObjectSpec {
+	return (&DatasourceOutput{}).FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Execute() (cty.Value, error) {
+	_, controller, err := onecommon.NewOpenNebulaConnect(d.config.OpenNebulaURL, d.config.Username, d.config.Password, d.config.Insecure)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error connecting to OpenNebula: %s", err)
+	}
+
+	clusterID := -1
+	if d.config.Cluster != "" {
+		clusterID, err = dscommon.ClusterID(controller, d.config.Cluster)
+		if err != nil {
+			return cty.NullVal(cty.EmptyObject), err
+		}
+	}
+
+	pool, err := controller.Datastores().Info()
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error listing datastores: %s", err)
+	}
+
+	var matches []ds.Datastore
+	for _, datastore := range pool.Datastores {
+		labels, _ := datastore.Template.GetStr("LABELS")
+		if !d.config.Filter.Match(datastore.Name, datastore.UID, datastore.UName, labels) {
+			continue
+		}
+		if d.config.Type != "" && !strings.EqualFold(datastoreTypeName(datastore.Type), d.config.Type) {
+			continue
+		}
+		if clusterID >= 0 && !slices.Contains(datastore.Clusters.ID, clusterID) {
+			continue
+		}
+		if datastore.FreeMB < d.config.MinFreeMB {
+			continue
+		}
+		matches = append(matches, datastore)
+	}
+
+	if len(matches) == 0 {
+		return cty.NullVal(cty.EmptyObject), errors.New("no datastore matches the filters")
+	}
+	if len(matches) > 1 {
+		if !d.config.MostFree {
+			return cty.NullVal(cty.EmptyObject), fmt.Errorf("%d datastores match the filters, narrow them down or set most_free", len(matches))
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].FreeMB > matches[j].FreeMB })
+	}
+
+	datastore := matches[0]
+	output := DatasourceOutput{
+		ID:         datastore.ID,
+		Name:       datastore.Name,
+		Type:       datastoreTypeName(datastore.Type),
+		DSMad:      datastore.DSMad,
+		TMMad:      datastore.TMMad,
+		TotalMB:    datastore.TotalMB,
+		FreeMB:     datastore.FreeMB,
+		UsedMB:     datastore.UsedMB,
+		ClusterIDs: datastore.Clusters.ID,
+	}
+
+	return hcl2helper.HCL2ValueFromConfig(output, d.OutputSpec()), nil
+}
+
+// datastoreTypeName returns the name of the datastore type, which the pool
+// reports as its index.
+func datastoreTypeName(raw string) string {
+	if i, err := strconv.Atoi(raw); err == nil && i >= 0 && i < len(datastoreTypes) {
+		return datastoreTypes[i]
+	}
+	return raw
+}
@@ -0,0 +1,90 @@
+// Code generated by "packer-sdc mapstructure-to-hcl2"; DO NOT EDIT.
+
+package datastore
+
+import (
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatConfig struct {
+	OpenNebulaURL *string  `mapstructure:"opennebula_url" cty:"opennebula_url" hcl:"opennebula_url"`
+	Username      *string  `mapstructure:"username" cty:"username" hcl:"username"`
+	Password      *string  `mapstructure:"password" cty:"password" hcl:"password"`
+	Insecure      *bool    `mapstructure:"insecure" cty:"insecure" hcl:"insecure"`
+	NameRegex     *string  `mapstructure:"name_regex" required:"false" cty:"name_regex" hcl:"name_regex"`
+	Labels        []string `mapstructure:"labels" required:"false" cty:"labels" hcl:"labels"`
+	Owner         *string  `mapstructure:"owner" required:"false" cty:"owner" hcl:"owner"`
+	Type          *string  `mapstructure:"type" required:"false" cty:"type" hcl:"type"`
+	Cluster       *string  `mapstructure:"cluster" required:"false" cty:"cluster" hcl:"cluster"`
+	MinFreeMB     *int     `mapstructure:"min_free_mb" required:"false" cty:"min_free_mb" hcl:"min_free_mb"`
+	MostFree      *bool    `mapstructure:"most_free" required:"false" cty:"most_free" hcl:"most_free"`
+}
+
+// FlatMapstructure returns a new FlatConfig.
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatConfig)
+}
+
+// HCL2Spec returns the hcl spec of a Config.
+// This spec is used by HCL to read the fields of Config.
+// The decoded values from this spec will then be applied to a FlatConfig.
+func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"opennebula_url": &hcldec.AttrSpec{Name: "opennebula_url", Type: cty.String, Required: false},
+		"username":       &hcldec.AttrSpec{Name: "username", Type: cty.String, Required: false},
+		"password":       &hcldec.AttrSpec{Name: "password", Type: cty.String, Required: false},
+		"insecure":       &hcldec.AttrSpec{Name: "insecure", Type: cty.Bool, Required: false},
+		"name_regex":     &hcldec.AttrSpec{Name: "name_regex", Type: cty.String, Required: false},
+		"labels":         &hcldec.AttrSpec{Name: "labels", Type: cty.List(cty.String), Required: false},
+		"owner":          &hcldec.AttrSpec{Name: "owner", Type: cty.String, Required: false},
+		"type":           &hcldec.AttrSpec{Name: "type", Type: cty.String, Required: false},
+		"cluster":        &hcldec.AttrSpec{Name: "cluster", Type: cty.String, Required: false},
+		"min_free_mb":    &hcldec.AttrSpec{Name: "min_free_mb", Type: cty.Number, Required: false},
+		"most_free":      &hcldec.AttrSpec{Name: "most_free", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatDatasourceOutput is an auto-generated flat version of DatasourceOutput.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatDatasourceOutput struct {
+	ID         *int    `mapstructure:"id" cty:"id" hcl:"id"`
+	Name       *string `mapstructure:"name" cty:"name" hcl:"name"`
+	Type       *string `mapstructure:"type" cty:"type" hcl:"type"`
+	DSMad      *string `mapstructure:"ds_mad" cty:"ds_mad" hcl:"ds_mad"`
+	TMMad      *string `mapstructure:"tm_mad" cty:"tm_mad" hcl:"tm_mad"`
+	TotalMB    *int    `mapstructure:"total_mb" cty:"total_mb" hcl:"total_mb"`
+	FreeMB     *int    `mapstructure:"free_mb" cty:"free_mb" hcl:"free_mb"`
+	UsedMB     *int    `mapstructure:"used_mb" cty:"used_mb" hcl:"used_mb"`
+	ClusterIDs []int   `mapstructure:"cluster_ids" cty:"cluster_ids" hcl:"cluster_ids"`
+}
+
+// FlatMapstructure returns a new FlatDatasourceOutput.
+// FlatDatasourceOutput is an auto-generated flat version of DatasourceOutput.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*DatasourceOutput) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatDatasourceOutput)
+}
+
+// HCL2Spec returns the hcl spec of a DatasourceOutput.
+// This spec is used by HCL to read the fields of DatasourceOutput.
+// The decoded values from this spec will then be applied to a FlatDatasourceOutput.
+func (*FlatDatasourceOutput) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"id":          &hcldec.AttrSpec{Name: "id", Type: cty.Number, Required: false},
+		"name":        &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"type":        &hcldec.AttrSpec{Name: "type", Type: cty.String, Required: false},
+		"ds_mad":      &hcldec.AttrSpec{Name: "ds_mad", Type: cty.String, Required: false},
+		"tm_mad":      &hcldec.AttrSpec{Name: "tm_mad", Type: cty.String, Required: false},
+		"total_mb":    &hcldec.AttrSpec{Name: "total_mb", Type: cty.Number, Required: false},
+		"free_mb":     &hcldec.AttrSpec{Name: "free_mb", Type: cty.Number, Required: false},
+		"used_mb":     &hcldec.AttrSpec{Name: "used_mb", Type: cty.Number, Required: false},
+		"cluster_ids": &hcldec.AttrSpec{Name: "cluster_ids", Type: cty.List(cty.Number), Required: false},
+	}
+	return s
+}
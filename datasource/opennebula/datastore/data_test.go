@@ -0,0 +1,135 @@
+package datastore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// testDatastores registers datastores besides the default ones, "system",
+// "default" and "files", and returns the IDs of all of them by name.
+func testDatastores(t *testing.T, srv *fakeone.Server) map[string]int {
+	t.Helper()
+	ids := map[string]int{
+		"system":      0,
+		"default":     1,
+		"files":       2,
+		"ceph-images": srv.AddDatastore("ceph-images", "IMAGE", 1024000, 900000, fakeone.EdgeClusterID),
+		"small":       srv.AddDatastore("small", "IMAGE", 10240, 1024, fakeone.ClusterID),
+	}
+
+	_, controller, err := onecommon.NewOpenNebulaConnect(srv.URL, "oneadmin", "opennebula", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Datastore(ids["ceph-images"]).Update(`LABELS="fast"`, parameters.Merge); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// execute configures the data source with raw and executes it.
+func execute(t *testing.T, srv *fakeone.Server, raw map[string]interface{}) (cty.Value, error) {
+	t.Helper()
+	config := map[string]interface{}{
+		"opennebula_url": srv.URL,
+		"username":       "oneadmin",
+		"password":       "opennebula",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	d := &Datasource{}
+	if err := d.Configure(config); err != nil {
+		t.Fatalf("Configure: %s", err)
+	}
+	return d.Execute()
+}
+
+func TestDatasource(t *testing.T) {
+	srv := fakeone.New(t)
+	ids := testDatastores(t, srv)
+
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+		err      string
+	}{
+		{"type", map[string]interface{}{"type": "system"}, "system", ""},
+		{"several matches", map[string]interface{}{"type": "IMAGE"}, "", "3 datastores match"},
+		{"most free", map[string]interface{}{"type": "IMAGE", "most_free": true}, "ceph-images", ""},
+		{"cluster and free space", map[string]interface{}{"type": "IMAGE", "cluster": "default", "min_free_mb": 2048}, "default", ""},
+		{"cluster by name", map[string]interface{}{"cluster": "edge"}, "ceph-images", ""},
+		{"cluster by ID", map[string]interface{}{"cluster": "101"}, "ceph-images", ""},
+		{"labels", map[string]interface{}{"labels": []string{"fast"}}, "ceph-images", ""},
+		{"unknown cluster", map[string]interface{}{"cluster": "staging"}, "", "Error finding cluster staging"},
+		{"no match", map[string]interface{}{"type": "FILE", "min_free_mb": 102400}, "", "no datastore matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := execute(t, srv, tt.raw)
+			switch {
+			case tt.err != "" && err == nil:
+				t.Fatalf("Execute succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Execute: %s, expected an error containing %q", err, tt.err)
+			case tt.err != "":
+				return
+			case err != nil:
+				t.Fatalf("Execute: %s", err)
+			}
+
+			if name := output.GetAttr("name").AsString(); name != tt.expected {
+				t.Errorf("name = %q, expected %q", name, tt.expected)
+			}
+			if id, _ := output.GetAttr("id").AsBigFloat().Int64(); int(id) != ids[tt.expected] {
+				t.Errorf("id = %d, expected %d", id, ids[tt.expected])
+			}
+		})
+	}
+}
+
+func TestDatasource_output(t *testing.T) {
+	srv := fakeone.New(t)
+	testDatastores(t, srv)
+
+	output, err := execute(t, srv, map[string]interface{}{"name_regex": "^ceph"})
+	if err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	var space []int64
+	for _, attr := range []string{"total_mb", "free_mb", "used_mb"} {
+		n, _ := output.GetAttr(attr).AsBigFloat().Int64()
+		space = append(space, n)
+	}
+	if !reflect.DeepEqual(space, []int64{1024000, 900000, 124000}) {
+		t.Errorf("total, free and used MB = %v, expected [1024000 900000 124000]", space)
+	}
+	if dsType := output.GetAttr("type").AsString(); dsType != "IMAGE" {
+		t.Errorf("type = %q, expected IMAGE", dsType)
+	}
+	clusterIDs := output.GetAttr("cluster_ids").AsValueSlice()
+	if id, _ := clusterIDs[0].AsBigFloat().Int64(); len(clusterIDs) != 1 || id != fakeone.EdgeClusterID {
+		t.Errorf("cluster_ids = %v, expected [%d]", clusterIDs, fakeone.EdgeClusterID)
+	}
+}
+
+func TestDatasource_invalidType(t *testing.T) {
+	d := &Datasource{}
+	err := d.Configure(map[string]interface{}{
+		"opennebula_url": "http://localhost:2633/RPC2",
+		"username":       "oneadmin",
+		"password":       "opennebula",
+		"type":           "BLOCK",
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid type") {
+		t.Errorf("Configure: %v, expected an invalid type error", err)
+	}
+}
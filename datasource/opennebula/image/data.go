@@ -0,0 +1,159 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,DatasourceOutput
+package image
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	goimage "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/hcl2helper"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	dscommon "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/common"
+)
+
+// imageTypes are the names of the image types, indexed by the TYPE value of
+// the image pool.
+var imageTypes = []string{"OS", "CDROM", "DATABLOCK", "KERNEL", "RAMDISK", "CONTEXT"}
+
+type Config struct {
+	onecommon.OpenNebulaConnect `mapstructure:",squash"`
+	dscommon.Filter             `mapstructure:",squash"`
+	// Name or ID of the datastore of the image.
+	Datastore string `mapstructure:"datastore" required:"false"`
+	// State of the image, e.g. `READY`.
+	State string `mapstructure:"state" required:"false"`
+	// Type of the image, e.g. `OS` or `CDROM`.
+	Type string `mapstructure:"type" required:"false"`
+	// Pick the most recently registered image when several match, instead
+	// of failing.
+	MostRecent bool `mapstructure:"most_recent" required:"false"`
+}
+
+type Datasource struct {
+	config Config
+}
+
+type DatasourceOutput struct {
+	// ID of the image.
+	ID int `mapstructure:"id"`
+	// Name of the image.
+	Name string `mapstructure:"name"`
+	// Size of the image in MB.
+	Size int `mapstructure:"size"`
+	// Format of the image, e.g. `qcow2`.
+	Format string `mapstructure:"format"`
+	// ID of the datastore of the image.
+	DatastoreID int `mapstructure:"datastore_id"`
+	// Name of the datastore of the image.
+	Datastore string `mapstructure:"datastore"`
+}
+
+func (d *Datasource) ConfigSpec() hcldec.ObjectSpec {
+	return d.config.FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Configure(raws ...interface{}) error {
+	err := config.Decode(&d.config, nil, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, d.config.OpenNebulaConnect.Prepare()...)
+	errs = packersdk.MultiErrorAppend(errs, d.config.Filter.Prepare()...)
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (d *Datasource) OutputSpec() hcldec.ObjectSpec {
+	return (&DatasourceOutput{}).FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Execute() (cty.Value, error) {
+	_, controller, err := onecommon.NewOpenNebulaConnect(d.config.OpenNebulaURL, d.config.Username, d.config.Password, d.config.Insecure)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error connecting to OpenNebula: %s", err)
+	}
+
+	pool, err := controller.Images().Info(parameters.PoolWhoAll)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error listing images: %s", err)
+	}
+
+	var matches []goimage.Image
+	for _, img := range pool.Images {
+		if d.matches(&img) {
+			matches = append(matches, img)
+		}
+	}
+
+	if len(matches) == 0 {
+		return cty.NullVal(cty.EmptyObject), errors.New("no image matches the filters")
+	}
+	if len(matches) > 1 {
+		if !d.config.MostRecent {
+			return cty.NullVal(cty.EmptyObject), fmt.Errorf("%d images match the filters, narrow them down or set most_recent", len(matches))
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].RegTime > matches[j].RegTime })
+	}
+
+	img := matches[0]
+	output := DatasourceOutput{
+		ID:        img.ID,
+		Name:      img.Name,
+		Size:      img.Size,
+		Format:    img.Format,
+		Datastore: img.Datastore,
+	}
+	if img.DatastoreID != nil {
+		output.DatastoreID = *img.DatastoreID
+	}
+	if output.Format == "" {
+		output.Format, _ = img.Template.GetStr("FORMAT")
+	}
+
+	return hcl2helper.HCL2ValueFromConfig(output, d.OutputSpec()), nil
+}
+
+// matches reports whether img passes all the filters.
+func (d *Datasource) matches(img *goimage.Image) bool {
+	labels, _ := img.Template.GetStr("LABELS")
+	if !d.config.Filter.Match(img.Name, img.UID, img.UName, labels) {
+		return false
+	}
+	if d.config.Datastore != "" && (img.DatastoreID == nil || !dscommon.MatchID(d.config.Datastore, *img.DatastoreID, img.Datastore)) {
+		return false
+	}
+	if d.config.State != "" {
+		state, err := img.StateString()
+		if err != nil || !strings.EqualFold(state, d.config.State) {
+			return false
+		}
+	}
+	if d.config.Type != "" && !strings.EqualFold(imageTypeName(img.Type), d.config.Type) {
+		return false
+	}
+	return true
+}
+
+// imageTypeName returns the name of the image type, which the pool reports
+// as its index.
+func imageTypeName(raw string) string {
+	if i, err := strconv.Atoi(raw); err == nil && i >= 0 && i < len(imageTypes) {
+		return imageTypes[i]
+	}
+	return raw
+}
@@ -0,0 +1,136 @@
+package image
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// testImages registers the images the tests look up and returns their IDs
+// by name.
+func testImages(t *testing.T, srv *fakeone.Server) map[string]int {
+	t.Helper()
+	_, controller, err := onecommon.NewOpenNebulaConnect(srv.URL, "oneadmin", "opennebula", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]int{}
+	for _, img := range []struct {
+		name, imageType string
+		datastoreID     int
+		attributes      string
+	}{
+		{"ubuntu-22.04-v1", "OS", 1, `LABELS="golden,ubuntu"`},
+		{"ubuntu-22.04-v2", "OS", 1, `LABELS="golden,ubuntu"` + "\n" + `FORMAT="qcow2"` + "\n" + `SIZE="10240"`},
+		{"ubuntu-22.04-installer", "CDROM", 1, `LABELS="ubuntu"`},
+		{"debian-12", "OS", 2, ""},
+	} {
+		id := srv.AddImage(img.name, img.imageType, img.datastoreID)
+		if err := controller.Image(id).Update(img.attributes, parameters.Merge); err != nil {
+			t.Fatal(err)
+		}
+		ids[img.name] = id
+	}
+	if err := controller.Image(ids["debian-12"]).Chown(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// execute configures the data source with raw and executes it.
+func execute(t *testing.T, srv *fakeone.Server, raw map[string]interface{}) (cty.Value, error) {
+	t.Helper()
+	config := map[string]interface{}{
+		"opennebula_url": srv.URL,
+		"username":       "oneadmin",
+		"password":       "opennebula",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	d := &Datasource{}
+	if err := d.Configure(config); err != nil {
+		t.Fatalf("Configure: %s", err)
+	}
+	return d.Execute()
+}
+
+func TestDatasource(t *testing.T) {
+	srv := fakeone.New(t)
+	ids := testImages(t, srv)
+
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+		err      string
+	}{
+		{"most recent", map[string]interface{}{"name_regex": "^ubuntu-22\\.04", "labels": []string{"golden"}, "most_recent": true}, "ubuntu-22.04-v2", ""},
+		{"several matches", map[string]interface{}{"name_regex": "^ubuntu-22\\.04", "labels": []string{"golden"}}, "", "2 images match"},
+		{"type", map[string]interface{}{"labels": []string{"ubuntu"}, "type": "cdrom"}, "ubuntu-22.04-installer", ""},
+		{"datastore by name", map[string]interface{}{"datastore": "files"}, "debian-12", ""},
+		{"datastore by ID", map[string]interface{}{"datastore": "2"}, "debian-12", ""},
+		{"owner", map[string]interface{}{"owner": "packer", "state": "READY"}, "debian-12", ""},
+		{"state", map[string]interface{}{"name_regex": "debian", "state": "ERROR"}, "", "no image matches"},
+		{"no match", map[string]interface{}{"labels": []string{"golden", "debian"}}, "", "no image matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := execute(t, srv, tt.raw)
+			switch {
+			case tt.err != "" && err == nil:
+				t.Fatalf("Execute succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Execute: %s, expected an error containing %q", err, tt.err)
+			case tt.err != "":
+				return
+			case err != nil:
+				t.Fatalf("Execute: %s", err)
+			}
+
+			if name := output.GetAttr("name").AsString(); name != tt.expected {
+				t.Errorf("name = %q, expected %q", name, tt.expected)
+			}
+			if id, _ := output.GetAttr("id").AsBigFloat().Int64(); int(id) != ids[tt.expected] {
+				t.Errorf("id = %d, expected %d", id, ids[tt.expected])
+			}
+		})
+	}
+}
+
+func TestDatasource_output(t *testing.T) {
+	srv := fakeone.New(t)
+	testImages(t, srv)
+
+	output, err := execute(t, srv, map[string]interface{}{"name_regex": "v2$"})
+	if err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	size, _ := output.GetAttr("size").AsBigFloat().Int64()
+	datastoreID, _ := output.GetAttr("datastore_id").AsBigFloat().Int64()
+	if size != 10240 || output.GetAttr("format").AsString() != "qcow2" {
+		t.Errorf("size = %d, format = %q, expected a 10240 MB qcow2 image", size, output.GetAttr("format").AsString())
+	}
+	if datastoreID != 1 || output.GetAttr("datastore").AsString() != "default" {
+		t.Errorf("datastore = %d %q, expected 1 default", datastoreID, output.GetAttr("datastore").AsString())
+	}
+}
+
+func TestDatasource_invalidNameRegex(t *testing.T) {
+	d := &Datasource{}
+	err := d.Configure(map[string]interface{}{
+		"opennebula_url": "http://localhost:2633/RPC2",
+		"username":       "oneadmin",
+		"password":       "opennebula",
+		"name_regex":     "ubuntu-(",
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid name_regex") {
+		t.Errorf("Configure: %v, expected an invalid name_regex error", err)
+	}
+}
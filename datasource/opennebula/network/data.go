@@ -0,0 +1,159 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,DatasourceOutput,AddressRange
+package network
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	vn "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/virtualnetwork"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/hcl2helper"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	dscommon "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/common"
+)
+
+type Config struct {
+	onecommon.OpenNebulaConnect `mapstructure:",squash"`
+	dscommon.Filter             `mapstructure:",squash"`
+	// Name or ID of a cluster the network must belong to.
+	Cluster string `mapstructure:"cluster" required:"false"`
+}
+
+type Datasource struct {
+	config Config
+}
+
+type AddressRange struct {
+	// ID of the address range.
+	ID int `mapstructure:"id"`
+	// Type of the address range, e.g. `IP4`.
+	Type string `mapstructure:"type"`
+	// First IP of the range.
+	IP string `mapstructure:"ip"`
+	// Last IP of the range.
+	IPEnd string `mapstructure:"ip_end"`
+	// First MAC of the range.
+	MAC string `mapstructure:"mac"`
+	// Number of addresses of the range.
+	Size int `mapstructure:"size"`
+	// Number of addresses in use.
+	UsedLeases int `mapstructure:"used_leases"`
+}
+
+type DatasourceOutput struct {
+	// ID of the network.
+	ID int `mapstructure:"id"`
+	// Name of the network.
+	Name string `mapstructure:"name"`
+	// Bridge of the network.
+	Bridge string `mapstructure:"bridge"`
+	// Network driver, e.g. `bridge` or `802.1Q`.
+	VNMad string `mapstructure:"vn_mad"`
+	// IDs of the clusters of the network.
+	ClusterIDs []int `mapstructure:"cluster_ids"`
+	// Number of addresses in use.
+	UsedLeases int `mapstructure:"used_leases"`
+	// Address ranges of the network.
+	AddressRanges []AddressRange `mapstructure:"address_ranges"`
+}
+
+func (d *Datasource) ConfigSpec() hcldec.ObjectSpec {
+	return d.config.FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Configure(raws ...interface{}) error {
+	err := config.Decode(&d.config, nil, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, d.config.OpenNebulaConnect.Prepare()...)
+	errs = packersdk.MultiErrorAppend(errs, d.config.Filter.Prepare()...)
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (d *Datasource) OutputSpec() hcldec.ObjectSpec {
+	return (&DatasourceOutput{}).FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Execute() (cty.Value, error) {
+	_, controller, err := onecommon.NewOpenNebulaConnect(d.config.OpenNebulaURL, d.config.Username, d.config.Password, d.config.Insecure)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error connecting to OpenNebula: %s", err)
+	}
+
+	clusterID := -1
+	if d.config.Cluster != "" {
+		clusterID, err = dscommon.ClusterID(controller, d.config.Cluster)
+		if err != nil {
+			return cty.NullVal(cty.EmptyObject), err
+		}
+	}
+
+	pool, err := controller.VirtualNetworks().Info(parameters.PoolWhoAll)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error listing networks: %s", err)
+	}
+
+	var matches []vn.VirtualNetwork
+	for _, network := range pool.VirtualNetworks {
+		labels, _ := network.Template.GetStr("LABELS")
+		if !d.config.Filter.Match(network.Name, network.UID, network.UName, labels) {
+			continue
+		}
+		if clusterID >= 0 && !slices.Contains(network.Clusters.ID, clusterID) {
+			continue
+		}
+		matches = append(matches, network)
+	}
+
+	if len(matches) == 0 {
+		return cty.NullVal(cty.EmptyObject), errors.New("no network matches the filters")
+	}
+	if len(matches) > 1 {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("%d networks match the filters, narrow them down", len(matches))
+	}
+
+	// The pool does not list the address ranges
+	network, err := controller.VirtualNetwork(matches[0].ID).Info(false)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error getting network %d: %s", matches[0].ID, err)
+	}
+
+	output := DatasourceOutput{
+		ID:         network.ID,
+		Name:       network.Name,
+		Bridge:     network.Bridge,
+		VNMad:      network.VNMad,
+		ClusterIDs: network.Clusters.ID,
+		UsedLeases: network.UsedLeases,
+	}
+	for _, ar := range network.ARs {
+		id, _ := strconv.Atoi(ar.ID)
+		used, _ := strconv.Atoi(ar.UsedLeases)
+		output.AddressRanges = append(output.AddressRanges, AddressRange{
+			ID:         id,
+			Type:       ar.Type,
+			IP:         ar.IP,
+			IPEnd:      ar.IPEnd,
+			MAC:        ar.MAC,
+			Size:       ar.Size,
+			UsedLeases: used,
+		})
+	}
+
+	return hcl2helper.HCL2ValueFromConfig(output, d.OutputSpec()), nil
+}
@@ -0,0 +1,115 @@
+// Code generated by "packer-sdc mapstructure-to-hcl2"; DO NOT EDIT.
+
+package network
+
+import (
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// FlatAddressRange is an auto-generated flat version of AddressRange.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatAddressRange struct {
+	ID         *int    `mapstructure:"id" cty:"id" hcl:"id"`
+	Type       *string `mapstructure:"type" cty:"type" hcl:"type"`
+	IP         *string `mapstructure:"ip" cty:"ip" hcl:"ip"`
+	IPEnd      *string `mapstructure:"ip_end" cty:"ip_end" hcl:"ip_end"`
+	MAC        *string `mapstructure:"mac" cty:"mac" hcl:"mac"`
+	Size       *int    `mapstructure:"size" cty:"size" hcl:"size"`
+	UsedLeases *int    `mapstructure:"used_leases" cty:"used_leases" hcl:"used_leases"`
+}
+
+// FlatMapstructure returns a new FlatAddressRange.
+// FlatAddressRange is an auto-generated flat version of AddressRange.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*AddressRange) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatAddressRange)
+}
+
+// HCL2Spec returns the hcl spec of a AddressRange.
+// This spec is used by HCL to read the fields of AddressRange.
+// The decoded values from this spec will then be applied to a FlatAddressRange.
+func (*FlatAddressRange) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"id":          &hcldec.AttrSpec{Name: "id", Type: cty.Number, Required: false},
+		"type":        &hcldec.AttrSpec{Name: "type", Type: cty.String, Required: false},
+		"ip":          &hcldec.AttrSpec{Name: "ip", Type: cty.String, Required: false},
+		"ip_end":      &hcldec.AttrSpec{Name: "ip_end", Type: cty.String, Required: false},
+		"mac":         &hcldec.AttrSpec{Name: "mac", Type: cty.String, Required: false},
+		"size":        &hcldec.AttrSpec{Name: "size", Type: cty.Number, Required: false},
+		"used_leases": &hcldec.AttrSpec{Name: "used_leases", Type: cty.Number, Required: false},
+	}
+	return s
+}
+
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatConfig struct {
+	OpenNebulaURL *string  `mapstructure:"opennebula_url" cty:"opennebula_url" hcl:"opennebula_url"`
+	Username      *string  `mapstructure:"username" cty:"username" hcl:"username"`
+	Password      *string  `mapstructure:"password" cty:"password" hcl:"password"`
+	Insecure      *bool    `mapstructure:"insecure" cty:"insecure" hcl:"insecure"`
+	NameRegex     *string  `mapstructure:"name_regex" required:"false" cty:"name_regex" hcl:"name_regex"`
+	Labels        []string `mapstructure:"labels" required:"false" cty:"labels" hcl:"labels"`
+	Owner         *string  `mapstructure:"owner" required:"false" cty:"owner" hcl:"owner"`
+	Cluster       *string  `mapstructure:"cluster" required:"false" cty:"cluster" hcl:"cluster"`
+}
+
+// FlatMapstructure returns a new FlatConfig.
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatConfig)
+}
+
+// HCL2Spec returns the hcl spec of a Config.
+// This spec is used by HCL to read the fields of Config.
+// The decoded values from this spec will then be applied to a FlatConfig.
+func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"opennebula_url": &hcldec.AttrSpec{Name: "opennebula_url", Type: cty.String, Required: false},
+		"username":       &hcldec.AttrSpec{Name: "username", Type: cty.String, Required: false},
+		"password":       &hcldec.AttrSpec{Name: "password", Type: cty.String, Required: false},
+		"insecure":       &hcldec.AttrSpec{Name: "insecure", Type: cty.Bool, Required: false},
+		"name_regex":     &hcldec.AttrSpec{Name: "name_regex", Type: cty.String, Required: false},
+		"labels":         &hcldec.AttrSpec{Name: "labels", Type: cty.List(cty.String), Required: false},
+		"owner":          &hcldec.AttrSpec{Name: "owner", Type: cty.String, Required: false},
+		"cluster":        &hcldec.AttrSpec{Name: "cluster", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatDatasourceOutput is an auto-generated flat version of DatasourceOutput.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatDatasourceOutput struct {
+	ID            *int               `mapstructure:"id" cty:"id" hcl:"id"`
+	Name          *string            `mapstructure:"name" cty:"name" hcl:"name"`
+	Bridge        *string            `mapstructure:"bridge" cty:"bridge" hcl:"bridge"`
+	VNMad         *string            `mapstructure:"vn_mad" cty:"vn_mad" hcl:"vn_mad"`
+	ClusterIDs    []int              `mapstructure:"cluster_ids" cty:"cluster_ids" hcl:"cluster_ids"`
+	UsedLeases    *int               `mapstructure:"used_leases" cty:"used_leases" hcl:"used_leases"`
+	AddressRanges []FlatAddressRange `mapstructure:"address_ranges" cty:"address_ranges" hcl:"address_ranges"`
+}
+
+// FlatMapstructure returns a new FlatDatasourceOutput.
+// FlatDatasourceOutput is an auto-generated flat version of DatasourceOutput.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*DatasourceOutput) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatDatasourceOutput)
+}
+
+// HCL2Spec returns the hcl spec of a DatasourceOutput.
+// This spec is used by HCL to read the fields of DatasourceOutput.
+// The decoded values from this spec will then be applied to a FlatDatasourceOutput.
+func (*FlatDatasourceOutput) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"id":             &hcldec.AttrSpec{Name: "id", Type: cty.Number, Required: false},
+		"name":           &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"bridge":         &hcldec.AttrSpec{Name: "bridge", Type: cty.String, Required: false},
+		"vn_mad":         &hcldec.AttrSpec{Name: "vn_mad", Type: cty.String, Required: false},
+		"cluster_ids":    &hcldec.AttrSpec{Name: "cluster_ids", Type: cty.List(cty.Number), Required: false},
+		"used_leases":    &hcldec.AttrSpec{Name: "used_leases", Type: cty.Number, Required: false},
+		"address_ranges": &hcldec.BlockListSpec{TypeName: "address_ranges", Nested: hcldec.ObjectSpec((*FlatAddressRange)(nil).HCL2Spec())},
+	}
+	return s
+}
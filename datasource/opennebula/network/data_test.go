@@ -0,0 +1,127 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// testNetworks registers the networks the tests look up and returns their
+// IDs by name.
+func testNetworks(t *testing.T, srv *fakeone.Server) map[string]int {
+	t.Helper()
+	ids := map[string]int{
+		"public": srv.AddNetwork("public", "br0", []int{fakeone.ClusterID},
+			fakeone.AddressRange{IP: "10.0.0.2", Size: 100, UsedLeases: 3}),
+		"private": srv.AddNetwork("private", "br1", []int{fakeone.ClusterID, fakeone.EdgeClusterID},
+			fakeone.AddressRange{IP: "192.168.0.10", Size: 10},
+			fakeone.AddressRange{IP: "192.168.1.10", Size: 5, UsedLeases: 1}),
+	}
+
+	_, controller, err := onecommon.NewOpenNebulaConnect(srv.URL, "oneadmin", "opennebula", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.VirtualNetwork(ids["private"]).Update(`LABELS="internal"`, parameters.Merge); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// execute configures the data source with raw and executes it.
+func execute(t *testing.T, srv *fakeone.Server, raw map[string]interface{}) (cty.Value, error) {
+	t.Helper()
+	config := map[string]interface{}{
+		"opennebula_url": srv.URL,
+		"username":       "oneadmin",
+		"password":       "opennebula",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	d := &Datasource{}
+	if err := d.Configure(config); err != nil {
+		t.Fatalf("Configure: %s", err)
+	}
+	return d.Execute()
+}
+
+func TestDatasource(t *testing.T) {
+	srv := fakeone.New(t)
+	ids := testNetworks(t, srv)
+
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+		err      string
+	}{
+		{"name", map[string]interface{}{"name_regex": "^public$"}, "public", ""},
+		{"cluster", map[string]interface{}{"cluster": "edge"}, "private", ""},
+		{"labels", map[string]interface{}{"labels": []string{"internal"}}, "private", ""},
+		{"several matches", map[string]interface{}{"cluster": "default"}, "", "2 networks match"},
+		{"unknown cluster", map[string]interface{}{"cluster": "staging"}, "", "Error finding cluster staging"},
+		{"no match", map[string]interface{}{"owner": "packer"}, "", "no network matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := execute(t, srv, tt.raw)
+			switch {
+			case tt.err != "" && err == nil:
+				t.Fatalf("Execute succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Execute: %s, expected an error containing %q", err, tt.err)
+			case tt.err != "":
+				return
+			case err != nil:
+				t.Fatalf("Execute: %s", err)
+			}
+
+			if name := output.GetAttr("name").AsString(); name != tt.expected {
+				t.Errorf("name = %q, expected %q", name, tt.expected)
+			}
+			if id, _ := output.GetAttr("id").AsBigFloat().Int64(); int(id) != ids[tt.expected] {
+				t.Errorf("id = %d, expected %d", id, ids[tt.expected])
+			}
+		})
+	}
+}
+
+func TestDatasource_addressRanges(t *testing.T) {
+	srv := fakeone.New(t)
+	testNetworks(t, srv)
+
+	output, err := execute(t, srv, map[string]interface{}{"name_regex": "private"})
+	if err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	if bridge := output.GetAttr("bridge").AsString(); bridge != "br1" {
+		t.Errorf("bridge = %q, expected br1", bridge)
+	}
+	if usedLeases, _ := output.GetAttr("used_leases").AsBigFloat().Int64(); usedLeases != 1 {
+		t.Errorf("used_leases = %d, expected 1", usedLeases)
+	}
+	if clusterIDs := output.GetAttr("cluster_ids").AsValueSlice(); len(clusterIDs) != 2 {
+		t.Errorf("cluster_ids = %v, expected both clusters", clusterIDs)
+	}
+
+	ars := output.GetAttr("address_ranges").AsValueSlice()
+	if len(ars) != 2 {
+		t.Fatalf("%d address ranges, expected 2", len(ars))
+	}
+	ar := ars[1]
+	id, _ := ar.GetAttr("id").AsBigFloat().Int64()
+	size, _ := ar.GetAttr("size").AsBigFloat().Int64()
+	used, _ := ar.GetAttr("used_leases").AsBigFloat().Int64()
+	if id != 1 || ar.GetAttr("ip").AsString() != "192.168.1.10" || ar.GetAttr("ip_end").AsString() != "192.168.1.14" {
+		t.Errorf("address range %d is %s-%s, expected 1 192.168.1.10-192.168.1.14", id, ar.GetAttr("ip").AsString(), ar.GetAttr("ip_end").AsString())
+	}
+	if size != 5 || used != 1 || ar.GetAttr("type").AsString() != "IP4" {
+		t.Errorf("address range of %d addresses, %d used, type %s, expected 5, 1 used, IP4", size, used, ar.GetAttr("type").AsString())
+	}
+}
@@ -0,0 +1,121 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,DatasourceOutput
+package template
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+	gotemplate "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/template"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/hcl2helper"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	dscommon "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/common"
+)
+
+type Config struct {
+	onecommon.OpenNebulaConnect `mapstructure:",squash"`
+	dscommon.Filter             `mapstructure:",squash"`
+	// Pick the most recently registered template when several match,
+	// instead of failing.
+	MostRecent bool `mapstructure:"most_recent" required:"false"`
+}
+
+type Datasource struct {
+	config Config
+}
+
+type DatasourceOutput struct {
+	// ID of the template.
+	ID int `mapstructure:"id"`
+	// Name of the template.
+	Name string `mapstructure:"name"`
+	// Name of the owner of the template.
+	Owner string `mapstructure:"owner"`
+	// CPU of the template.
+	CPU float64 `mapstructure:"cpu"`
+	// Virtual CPUs of the template.
+	VCPU int `mapstructure:"vcpu"`
+	// Memory of the template in MB.
+	Memory int `mapstructure:"memory"`
+	// IDs of the images of the template disks.
+	ImageIDs []int `mapstructure:"image_ids"`
+}
+
+func (d *Datasource) ConfigSpec() hcldec.ObjectSpec {
+	return d.config.FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Configure(raws ...interface{}) error {
+	err := config.Decode(&d.config, nil, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, d.config.OpenNebulaConnect.Prepare()...)
+	errs = packersdk.MultiErrorAppend(errs, d.config.Filter.Prepare()...)
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (d *Datasource) OutputSpec() hcldec.ObjectSpec {
+	return (&DatasourceOutput{}).FlatMapstructure().HCL2Spec()
+}
+
+func (d *Datasource) Execute() (cty.Value, error) {
+	_, controller, err := onecommon.NewOpenNebulaConnect(d.config.OpenNebulaURL, d.config.Username, d.config.Password, d.config.Insecure)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error connecting to OpenNebula: %s", err)
+	}
+
+	pool, err := controller.Templates().Info(parameters.PoolWhoAll)
+	if err != nil {
+		return cty.NullVal(cty.EmptyObject), fmt.Errorf("Error listing templates: %s", err)
+	}
+
+	var matches []gotemplate.Template
+	for _, tpl := range pool.Templates {
+		labels, _ := tpl.Template.GetStr("LABELS")
+		if d.config.Filter.Match(tpl.Name, tpl.UID, tpl.UName, labels) {
+			matches = append(matches, tpl)
+		}
+	}
+
+	if len(matches) == 0 {
+		return cty.NullVal(cty.EmptyObject), errors.New("no template matches the filters")
+	}
+	if len(matches) > 1 {
+		if !d.config.MostRecent {
+			return cty.NullVal(cty.EmptyObject), fmt.Errorf("%d templates match the filters, narrow them down or set most_recent", len(matches))
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].RegTime > matches[j].RegTime })
+	}
+
+	tpl := matches[0]
+	output := DatasourceOutput{
+		ID:    tpl.ID,
+		Name:  tpl.Name,
+		Owner: tpl.UName,
+	}
+	output.CPU, _ = tpl.Template.GetCPU()
+	output.VCPU, _ = tpl.Template.GetVCPU()
+	output.Memory, _ = tpl.Template.GetMemory()
+	for _, disk := range tpl.Template.GetDisks() {
+		if id, err := disk.GetI(shared.ImageID); err == nil {
+			output.ImageIDs = append(output.ImageIDs, id)
+		}
+	}
+
+	return hcl2helper.HCL2ValueFromConfig(output, d.OutputSpec()), nil
+}
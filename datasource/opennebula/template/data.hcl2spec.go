@@ -0,0 +1,80 @@
+// Code generated by "packer-sdc mapstructure-to-hcl2"; DO NOT EDIT.
+
+package template
+
+import (
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatConfig struct {
+	OpenNebulaURL *string  `mapstructure:"opennebula_url" cty:"opennebula_url" hcl:"opennebula_url"`
+	Username      *string  `mapstructure:"username" cty:"username" hcl:"username"`
+	Password      *string  `mapstructure:"password" cty:"password" hcl:"password"`
+	Insecure      *bool    `mapstructure:"insecure" cty:"insecure" hcl:"insecure"`
+	NameRegex     *string  `mapstructure:"name_regex" required:"false" cty:"name_regex" hcl:"name_regex"`
+	Labels        []string `mapstructure:"labels" required:"false" cty:"labels" hcl:"labels"`
+	Owner         *string  `mapstructure:"owner" required:"false" cty:"owner" hcl:"owner"`
+	MostRecent    *bool    `mapstructure:"most_recent" required:"false" cty:"most_recent" hcl:"most_recent"`
+}
+
+// FlatMapstructure returns a new FlatConfig.
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatConfig)
+}
+
+// HCL2Spec returns the hcl spec of a Config.
+// This spec is used by HCL to read the fields of Config.
+// The decoded values from this spec will then be applied to a FlatConfig.
+func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"opennebula_url": &hcldec.AttrSpec{Name: "opennebula_url", Type: cty.String, Required: false},
+		"username":       &hcldec.AttrSpec{Name: "username", Type: cty.String, Required: false},
+		"password":       &hcldec.AttrSpec{Name: "password", Type: cty.String, Required: false},
+		"insecure":       &hcldec.AttrSpec{Name: "insecure", Type: cty.Bool, Required: false},
+		"name_regex":     &hcldec.AttrSpec{Name: "name_regex", Type: cty.String, Required: false},
+		"labels":         &hcldec.AttrSpec{Name: "labels", Type: cty.List(cty.String), Required: false},
+		"owner":          &hcldec.AttrSpec{Name: "owner", Type: cty.String, Required: false},
+		"most_recent":    &hcldec.AttrSpec{Name: "most_recent", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
+// FlatDatasourceOutput is an auto-generated flat version of DatasourceOutput.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatDatasourceOutput struct {
+	ID       *int     `mapstructure:"id" cty:"id" hcl:"id"`
+	Name     *string  `mapstructure:"name" cty:"name" hcl:"name"`
+	Owner    *string  `mapstructure:"owner" cty:"owner" hcl:"owner"`
+	CPU      *float64 `mapstructure:"cpu" cty:"cpu" hcl:"cpu"`
+	VCPU     *int     `mapstructure:"vcpu" cty:"vcpu" hcl:"vcpu"`
+	Memory   *int     `mapstructure:"memory" cty:"memory" hcl:"memory"`
+	ImageIDs []int    `mapstructure:"image_ids" cty:"image_ids" hcl:"image_ids"`
+}
+
+// FlatMapstructure returns a new FlatDatasourceOutput.
+// FlatDatasourceOutput is an auto-generated flat version of DatasourceOutput.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*DatasourceOutput) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatDatasourceOutput)
+}
+
+// HCL2Spec returns the hcl spec of a DatasourceOutput.
+// This spec is used by HCL to read the fields of DatasourceOutput.
+// The decoded values from this spec will then be applied to a FlatDatasourceOutput.
+func (*FlatDatasourceOutput) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"id":        &hcldec.AttrSpec{Name: "id", Type: cty.Number, Required: false},
+		"name":      &hcldec.AttrSpec{Name: "name", Type: cty.String, Required: false},
+		"owner":     &hcldec.AttrSpec{Name: "owner", Type: cty.String, Required: false},
+		"cpu":       &hcldec.AttrSpec{Name: "cpu", Type: cty.Number, Required: false},
+		"vcpu":      &hcldec.AttrSpec{Name: "vcpu", Type: cty.Number, Required: false},
+		"memory":    &hcldec.AttrSpec{Name: "memory", Type: cty.Number, Required: false},
+		"image_ids": &hcldec.AttrSpec{Name: "image_ids", Type: cty.List(cty.Number), Required: false},
+	}
+	return s
+}
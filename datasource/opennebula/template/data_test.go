@@ -0,0 +1,118 @@
+package template
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// testTemplates registers the templates the tests look up and returns
+// their IDs by name.
+func testTemplates(t *testing.T, srv *fakeone.Server) map[string]int {
+	t.Helper()
+	ids := map[string]int{
+		"ubuntu-old":  srv.AddTemplate("ubuntu-old", `LABELS="golden,ubuntu"`),
+		"ubuntu-base": srv.AddTemplate("ubuntu-base", `LABELS="golden,ubuntu"`+"\n"+`CPU="1"`+"\n"+`VCPU="2"`+"\n"+`MEMORY="2048"`+"\n"+`DISK=[IMAGE_ID="3"]`+"\n"+`DISK=[IMAGE_ID="4"]`),
+		"debian":      srv.AddTemplate("debian", `CPU="0.5"`),
+	}
+
+	_, controller, err := onecommon.NewOpenNebulaConnect(srv.URL, "oneadmin", "opennebula", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Template(ids["debian"]).Chown(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// execute configures the data source with raw and executes it.
+func execute(t *testing.T, srv *fakeone.Server, raw map[string]interface{}) (cty.Value, error) {
+	t.Helper()
+	config := map[string]interface{}{
+		"opennebula_url": srv.URL,
+		"username":       "oneadmin",
+		"password":       "opennebula",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	d := &Datasource{}
+	if err := d.Configure(config); err != nil {
+		t.Fatalf("Configure: %s", err)
+	}
+	return d.Execute()
+}
+
+func TestDatasource(t *testing.T) {
+	srv := fakeone.New(t)
+	ids := testTemplates(t, srv)
+
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+		err      string
+	}{
+		{"name", map[string]interface{}{"name_regex": "^debian$"}, "debian", ""},
+		{"most recent", map[string]interface{}{"labels": []string{"golden"}, "most_recent": true}, "ubuntu-base", ""},
+		{"several matches", map[string]interface{}{"labels": []string{"ubuntu"}}, "", "2 templates match"},
+		{"owner by name", map[string]interface{}{"owner": "packer"}, "debian", ""},
+		{"owner by ID", map[string]interface{}{"owner": "2"}, "debian", ""},
+		{"no match", map[string]interface{}{"labels": []string{"golden"}, "owner": "packer"}, "", "no template matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := execute(t, srv, tt.raw)
+			switch {
+			case tt.err != "" && err == nil:
+				t.Fatalf("Execute succeeded, expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("Execute: %s, expected an error containing %q", err, tt.err)
+			case tt.err != "":
+				return
+			case err != nil:
+				t.Fatalf("Execute: %s", err)
+			}
+
+			if name := output.GetAttr("name").AsString(); name != tt.expected {
+				t.Errorf("name = %q, expected %q", name, tt.expected)
+			}
+			if id, _ := output.GetAttr("id").AsBigFloat().Int64(); int(id) != ids[tt.expected] {
+				t.Errorf("id = %d, expected %d", id, ids[tt.expected])
+			}
+		})
+	}
+}
+
+func TestDatasource_output(t *testing.T) {
+	srv := fakeone.New(t)
+	testTemplates(t, srv)
+
+	output, err := execute(t, srv, map[string]interface{}{"name_regex": "base"})
+	if err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	cpu, _ := output.GetAttr("cpu").AsBigFloat().Float64()
+	vcpu, _ := output.GetAttr("vcpu").AsBigFloat().Int64()
+	memory, _ := output.GetAttr("memory").AsBigFloat().Int64()
+	if cpu != 1 || vcpu != 2 || memory != 2048 {
+		t.Errorf("cpu = %v, vcpu = %d, memory = %d, expected 1, 2 and 2048", cpu, vcpu, memory)
+	}
+	if owner := output.GetAttr("owner").AsString(); owner != "oneadmin" {
+		t.Errorf("owner = %q, expected oneadmin", owner)
+	}
+
+	var imageIDs []int64
+	for _, id := range output.GetAttr("image_ids").AsValueSlice() {
+		n, _ := id.AsBigFloat().Int64()
+		imageIDs = append(imageIDs, n)
+	}
+	if len(imageIDs) != 2 || imageIDs[0] != 3 || imageIDs[1] != 4 {
+		t.Errorf("image_ids = %v, expected [3 4]", imageIDs)
+	}
+}
@@ -0,0 +1,40 @@
+package fakeone
+
+import (
+	"bytes"
+	"sort"
+)
+
+// The fake frontend has fixed clusters, the one VMs are deployed to and an
+// empty one.
+var clusters = map[int]string{
+	ClusterID:     "default",
+	EdgeClusterID: "edge",
+}
+
+func (s *Server) clusterPoolInfo() (interface{}, error) {
+	ids := make([]int, 0, len(clusters))
+	for id := range clusters {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var b bytes.Buffer
+	b.WriteString("<CLUSTER_POOL>")
+	for _, id := range ids {
+		b.WriteString("<CLUSTER>")
+		writeElement(&b, "ID", id)
+		writeElement(&b, "NAME", clusters[id])
+		b.WriteString("<HOSTS></HOSTS><DATASTORES></DATASTORES><VNETS></VNETS><TEMPLATE></TEMPLATE></CLUSTER>")
+	}
+	b.WriteString("</CLUSTER_POOL>")
+	return b.String(), nil
+}
+
+func writeClustersXML(b *bytes.Buffer, ids []int) {
+	b.WriteString("<CLUSTERS>")
+	for _, id := range ids {
+		writeElement(b, "ID", id)
+	}
+	b.WriteString("</CLUSTERS>")
+}
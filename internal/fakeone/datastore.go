@@ -0,0 +1,147 @@
+package fakeone
+
+import (
+	"bytes"
+	"fmt"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+)
+
+// Datastore is a datastore of the fake.
+type Datastore struct {
+	ID   int
+	Name string
+	// Type is IMAGE, SYSTEM or FILE.
+	Type       string
+	DSMad      string
+	TMMad      string
+	TotalMB    int
+	FreeMB     int
+	ClusterIDs []int
+	Template   *dyn.Template
+	Ownership
+}
+
+// datastoreTypes are the datastore types by the number OpenNebula reports
+// them as.
+var datastoreTypes = []string{"IMAGE", "SYSTEM", "FILE"}
+
+// defaultDatastores are the datastores of a new fake frontend, in
+// ClusterID.
+func defaultDatastores() map[int]*Datastore {
+	datastores := map[int]*Datastore{}
+	for id, ds := range []struct{ name, dsType, dsMad, tmMad string }{
+		{"system", "SYSTEM", "-", "ssh"},
+		{"default", "IMAGE", "fs", "qcow2"},
+		{"files", "FILE", "fs", "ssh"},
+	} {
+		datastores[id] = &Datastore{
+			ID:         id,
+			Name:       ds.name,
+			Type:       ds.dsType,
+			DSMad:      ds.dsMad,
+			TMMad:      ds.tmMad,
+			TotalMB:    102400,
+			FreeMB:     51200,
+			ClusterIDs: []int{ClusterID},
+			Template:   dyn.NewTemplate(),
+			Ownership:  defaultOwnership(),
+		}
+	}
+	return datastores
+}
+
+// datastoreName returns the name of the datastore.
+func (s *Server) datastoreName(id int) string {
+	if ds, ok := s.datastores[id]; ok {
+		return ds.Name
+	}
+	return fmt.Sprintf("datastore-%d", id)
+}
+
+func (s *Server) datastorePoolInfo() (interface{}, error) {
+	var b bytes.Buffer
+	b.WriteString("<DATASTORE_POOL>")
+	for id := 0; id < s.nextDatastoreID; id++ {
+		if ds, ok := s.datastores[id]; ok {
+			writeDatastoreXML(&b, ds)
+		}
+	}
+	b.WriteString("</DATASTORE_POOL>")
+	return b.String(), nil
+}
+
+func (s *Server) datastoreUpdate(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	str, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	ds, ok := s.datastores[id]
+	if !ok {
+		return nil, errorf(codeNoExists, "Error getting datastore [%d].", id)
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	updateType, _ := a.int(2)
+	if parameters.UpdateType(updateType) == parameters.Merge {
+		mergeTemplate(ds.Template, tpl)
+	} else {
+		ds.Template = tpl
+	}
+	return id, nil
+}
+
+func writeDatastoreXML(b *bytes.Buffer, ds *Datastore) {
+	dsType := ds.Type
+	for i, t := range datastoreTypes {
+		if t == ds.Type {
+			dsType = fmt.Sprint(i)
+		}
+	}
+
+	b.WriteString("<DATASTORE>")
+	writeElement(b, "ID", ds.ID)
+	ds.Ownership.writeXML(b)
+	writeElement(b, "NAME", ds.Name)
+	ds.Ownership.writePermissionsXML(b)
+	writeElement(b, "DS_MAD", ds.DSMad)
+	writeElement(b, "TM_MAD", ds.TMMad)
+	writeElement(b, "TYPE", dsType)
+	writeElement(b, "STATE", 0)
+	writeClustersXML(b, ds.ClusterIDs)
+	writeElement(b, "TOTAL_MB", ds.TotalMB)
+	writeElement(b, "FREE_MB", ds.FreeMB)
+	writeElement(b, "USED_MB", ds.TotalMB-ds.FreeMB)
+	writeTemplateXML(b, "TEMPLATE", ds.Template)
+	b.WriteString("</DATASTORE>")
+}
+
+// AddDatastore registers a datastore of the type, e.g. IMAGE, in the
+// clusters, and returns its ID.
+func (s *Server) AddDatastore(name, dsType string, totalMB, freeMB int, clusterIDs ...int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextDatastoreID
+	s.nextDatastoreID++
+	s.datastores[id] = &Datastore{
+		ID:         id,
+		Name:       name,
+		Type:       dsType,
+		DSMad:      "fs",
+		TMMad:      "qcow2",
+		TotalMB:    totalMB,
+		FreeMB:     freeMB,
+		ClusterIDs: clusterIDs,
+		Template:   dyn.NewTemplate(),
+		Ownership:  defaultOwnership(),
+	}
+	return id
+}
@@ -0,0 +1,296 @@
+package fakeone
+
+import (
+	"bytes"
+	"strconv"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp"
+)
+
+// Image is an image of the fake.
+type Image struct {
+	ID          int
+	Name        string
+	Type        string
+	DatastoreID int
+	// State is READY for an image that is not being created, USED while a
+	// VM holds it.
+	State    image.State
+	Template *dyn.Template
+	Ownership
+
+	// next are the states reached on the next info calls
+	next []image.State
+}
+
+// imageTypes are the image types by the number OpenNebula reports them as.
+var imageTypes = []string{"OS", "CDROM", "DATABLOCK", "KERNEL", "RAMDISK", "CONTEXT"}
+
+func (s *Server) newImage(name, imageType string, datastoreID int, state image.State, tpl *dyn.Template, next ...image.State) int {
+	id := s.nextImageID
+	s.nextImageID++
+	s.images[id] = &Image{
+		ID:          id,
+		Name:        name,
+		Type:        imageType,
+		DatastoreID: datastoreID,
+		State:       state,
+		Template:    tpl,
+		Ownership:   defaultOwnership(),
+		next:        next,
+	}
+	return id
+}
+
+// imageState returns the state of the image as reported by OpenNebula.
+func (s *Server) imageState(img *Image) image.State {
+	if img.State != image.Ready {
+		return img.State
+	}
+	for _, v := range s.vms {
+		if v.uses(img.ID) {
+			return image.Used
+		}
+	}
+	return image.Ready
+}
+
+func (s *Server) imageByName(name string) *Image {
+	for _, img := range s.images {
+		if img.Name == name {
+			return img
+		}
+	}
+	return nil
+}
+
+func (s *Server) getImage(id int) (*Image, error) {
+	img, ok := s.images[id]
+	if !ok {
+		return nil, errorf(codeNoExists, "Error getting image [%d].", id)
+	}
+	return img, nil
+}
+
+func (s *Server) imageAllocate(a args) (interface{}, error) {
+	str, err := a.str(0)
+	if err != nil {
+		return nil, err
+	}
+	datastoreID, err := a.int(1)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	name, _ := tpl.GetStr("NAME")
+	if name == "" {
+		return nil, errorf(codeAllocate, "Error allocating a new image. No NAME in template.")
+	}
+	if img := s.imageByName(name); img != nil {
+		return nil, errorf(codeAllocate, "Error allocating a new image. NAME is already taken by IMAGE %d.", img.ID)
+	}
+	imageType, _ := tpl.GetStr("TYPE")
+	if imageType == "" {
+		imageType = "OS"
+	}
+	path, _ := tpl.GetStr("PATH")
+	size, _ := tpl.GetStr("SIZE")
+	if appID, err := tpl.GetInt("FROM_APP"); err == nil {
+		// The image is downloaded from a Marketplace appliance
+		app, err := s.getApp(appID)
+		if err != nil {
+			return nil, err
+		}
+		if app.State != marketplaceapp.Ready {
+			return nil, errorf(codeAllocate, "Error allocating a new image. Marketplace app %d is in state %s", appID, app.State)
+		}
+		path = "marketplace://" + strconv.Itoa(appID)
+	}
+	if path == "" && (imageType != "DATABLOCK" || size == "" || size == "0") {
+		return nil, errorf(codeAllocate, "Error allocating a new image. No PATH in template.")
+	}
+
+	return s.newImage(name, imageType, datastoreID, image.Locked, tpl, image.Ready), nil
+}
+
+func (s *Server) imageInfo(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(img.next) > 0 {
+		img.State, img.next = img.next[0], img.next[1:]
+	}
+
+	var b bytes.Buffer
+	s.writeImageXML(&b, img)
+	return b.String(), nil
+}
+
+func (s *Server) writeImageXML(b *bytes.Buffer, img *Image) {
+	imageType := img.Type
+	for i, t := range imageTypes {
+		if t == img.Type {
+			imageType = strconv.Itoa(i)
+		}
+	}
+	runningVMs := 0
+	for _, v := range s.vms {
+		if v.uses(img.ID) {
+			runningVMs++
+		}
+	}
+
+	b.WriteString("<IMAGE>")
+	writeElement(b, "ID", img.ID)
+	img.Ownership.writeXML(b)
+	writeElement(b, "NAME", img.Name)
+	img.Ownership.writePermissionsXML(b)
+	writeElement(b, "TYPE", imageType)
+	writeElement(b, "PERSISTENT", 0)
+	writeElement(b, "REGTIME", 1700000000+img.ID)
+	// The attributes OpenNebula fills in from the template
+	for _, key := range []string{"PATH", "SOURCE", "FORMAT", "SIZE"} {
+		if value, err := img.Template.GetStr(key); err == nil {
+			writeElement(b, key, value)
+		}
+	}
+	writeElement(b, "STATE", int(s.imageState(img)))
+	writeElement(b, "RUNNING_VMS", runningVMs)
+	writeElement(b, "DATASTORE_ID", img.DatastoreID)
+	writeElement(b, "DATASTORE", s.datastoreName(img.DatastoreID))
+	writeTemplateXML(b, "TEMPLATE", img.Template)
+	b.WriteString("</IMAGE>")
+}
+
+func (s *Server) imagePoolInfo(a args) (interface{}, error) {
+	who := parameters.PoolWhoAll
+	if len(a) > 0 {
+		who, _ = a.int(0)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<IMAGE_POOL>")
+	for _, img := range s.sortedImages() {
+		if img.visible(who) {
+			s.writeImageXML(&b, img)
+		}
+	}
+	b.WriteString("</IMAGE_POOL>")
+	return b.String(), nil
+}
+
+func (s *Server) sortedImages() []*Image {
+	images := make([]*Image, 0, len(s.images))
+	for id := 0; id < s.nextImageID; id++ {
+		if img, ok := s.images[id]; ok {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+func (s *Server) imageClone(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	name, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	datastoreID, _ := a.int(2)
+
+	source, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+	if st := s.imageState(source); st != image.Ready && st != image.Used {
+		return nil, errorf(codeAction, "Error cloning image [%d]: wrong state %s", id, st)
+	}
+	if img := s.imageByName(name); img != nil {
+		return nil, errorf(codeAllocate, "Error cloning image [%d]. NAME is already taken by IMAGE %d.", id, img.ID)
+	}
+	if datastoreID < 0 {
+		datastoreID = source.DatastoreID
+	}
+	return s.newImage(name, source.Type, datastoreID, image.Locked, cloneTemplate(source.Template), image.Ready), nil
+}
+
+func (s *Server) imageDelete(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+	if st := s.imageState(img); st != image.Ready && st != image.Error {
+		return nil, errorf(codeAction, "Error deleting image [%d]: image is in state %s", id, st)
+	}
+	delete(s.images, id)
+	return id, nil
+}
+
+func (s *Server) imageChown(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+	return id, img.chown(a)
+}
+
+func (s *Server) imageChmod(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+	return id, img.chmod(a)
+}
+
+func (s *Server) imageUpdate(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	str, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.getImage(id)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	updateType, _ := a.int(2)
+	if parameters.UpdateType(updateType) == parameters.Merge {
+		mergeTemplate(img.Template, tpl)
+	} else {
+		img.Template = tpl
+	}
+	return id, nil
+}
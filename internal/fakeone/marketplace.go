@@ -0,0 +1,230 @@
+package fakeone
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/marketplaceapp"
+)
+
+// MarketplaceApp is a Marketplace appliance of the fake.
+type MarketplaceApp struct {
+	ID            int
+	Name          string
+	MarketplaceID int
+	// OriginID is the image the appliance was created from, -1 for the
+	// appliances of public marketplaces.
+	OriginID int
+	State    marketplaceapp.State
+	// AppTemplate is the image template exported with the appliance.
+	AppTemplate string
+	Template    *dyn.Template
+	// Source is the URL the appliance is downloaded from, MD5 its checksum,
+	// Size its size in MB and Format the format of the disk.
+	Source string
+	MD5    string
+	Size   int
+	Format string
+
+	// next are the states reached on the next info calls
+	next []marketplaceapp.State
+}
+
+// marketplaceName returns the name of the fake marketplaces.
+func marketplaceName(id int) string {
+	switch id {
+	case 0:
+		return "OpenNebula Public"
+	case 1:
+		return "private"
+	}
+	return fmt.Sprintf("marketplace-%d", id)
+}
+
+func (s *Server) newApp(app *MarketplaceApp) int {
+	app.ID = s.nextAppID
+	s.nextAppID++
+	s.apps[app.ID] = app
+	return app.ID
+}
+
+func (s *Server) getApp(id int) (*MarketplaceApp, error) {
+	app, ok := s.apps[id]
+	if !ok {
+		return nil, errorf(codeNoExists, "Error getting marketplace app [%d].", id)
+	}
+	return app, nil
+}
+
+func (s *Server) marketAppAllocate(a args) (interface{}, error) {
+	str, err := a.str(0)
+	if err != nil {
+		return nil, err
+	}
+	marketplaceID, err := a.int(1)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	name, _ := tpl.GetStr("NAME")
+	if name == "" {
+		return nil, errorf(codeAllocate, "Error allocating a new marketplace app. No NAME in template.")
+	}
+	for _, app := range s.apps {
+		if app.Name == name && app.MarketplaceID == marketplaceID {
+			return nil, errorf(codeAllocate, "Error allocating a new marketplace app. NAME is already taken by MARKETPLACEAPP %d.", app.ID)
+		}
+	}
+	originID, err := tpl.GetInt("ORIGIN_ID")
+	if err != nil {
+		return nil, errorf(codeAllocate, "Error allocating a new marketplace app. No ORIGIN_ID in template.")
+	}
+	origin, err := s.getImage(originID)
+	if err != nil {
+		return nil, err
+	}
+	if st := s.imageState(origin); st != image.Ready && st != image.Used {
+		return nil, errorf(codeAllocate, "Error allocating a new marketplace app. Image %d is in state %s", originID, st)
+	}
+
+	appTemplate := ""
+	if encoded, err := tpl.GetStr("APPTEMPLATE64"); err == nil {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errorf(codeAPI, "APPTEMPLATE64 is not base64: %s", err)
+		}
+		appTemplate = string(decoded)
+	}
+	return s.newApp(&MarketplaceApp{
+		Name:          name,
+		MarketplaceID: marketplaceID,
+		OriginID:      originID,
+		State:         marketplaceapp.Locked,
+		AppTemplate:   appTemplate,
+		Template:      tpl,
+		next:          []marketplaceapp.State{marketplaceapp.Ready},
+	}), nil
+}
+
+func (s *Server) marketAppInfo(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.getApp(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(app.next) > 0 {
+		app.State, app.next = app.next[0], app.next[1:]
+	}
+
+	var b bytes.Buffer
+	writeAppXML(&b, app)
+	return b.String(), nil
+}
+
+func (s *Server) marketAppDelete(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.getApp(id); err != nil {
+		return nil, err
+	}
+	delete(s.apps, id)
+	return id, nil
+}
+
+func (s *Server) marketAppPoolInfo() (interface{}, error) {
+	var b bytes.Buffer
+	b.WriteString("<MARKETPLACEAPP_POOL>")
+	for id := 0; id < s.nextAppID; id++ {
+		if app, ok := s.apps[id]; ok {
+			writeAppXML(&b, app)
+		}
+	}
+	b.WriteString("</MARKETPLACEAPP_POOL>")
+	return b.String(), nil
+}
+
+func (s *Server) marketplacePoolInfo() (interface{}, error) {
+	var b bytes.Buffer
+	b.WriteString("<MARKETPLACE_POOL>")
+	for id := 0; id < 2; id++ {
+		b.WriteString("<MARKETPLACE>")
+		writeElement(&b, "ID", id)
+		writeElement(&b, "NAME", marketplaceName(id))
+		b.WriteString("<TEMPLATE></TEMPLATE></MARKETPLACE>")
+	}
+	b.WriteString("</MARKETPLACE_POOL>")
+	return b.String(), nil
+}
+
+func writeAppXML(b *bytes.Buffer, app *MarketplaceApp) {
+	b.WriteString("<MARKETPLACEAPP>")
+	writeElement(b, "ID", app.ID)
+	writeElement(b, "UID", 0)
+	writeElement(b, "GID", 0)
+	writeElement(b, "UNAME", "oneadmin")
+	writeElement(b, "GNAME", "oneadmin")
+	writeElement(b, "REGTIME", 1700000000+app.ID)
+	writeElement(b, "NAME", app.Name)
+	writeElement(b, "ORIGIN_ID", app.OriginID)
+	writeElement(b, "SOURCE", app.Source)
+	writeElement(b, "MD5", app.MD5)
+	writeElement(b, "SIZE", app.Size)
+	writeElement(b, "FORMAT", app.Format)
+	writeElement(b, "APPTEMPLATE64", base64.StdEncoding.EncodeToString([]byte(app.AppTemplate)))
+	writeElement(b, "MARKETPLACE_ID", app.MarketplaceID)
+	writeElement(b, "MARKETPLACE", marketplaceName(app.MarketplaceID))
+	writeElement(b, "STATE", int(app.State))
+	writeElement(b, "TYPE", 1)
+	writeTemplateXML(b, "TEMPLATE", app.Template)
+	b.WriteString("</MARKETPLACEAPP>")
+}
+
+// AddMarketplaceApp registers a READY appliance of a public marketplace
+// exporting the image template appTemplate, and returns its ID.
+func (s *Server) AddMarketplaceApp(name string, marketplaceID int, appTemplate string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newApp(&MarketplaceApp{
+		Name:          name,
+		MarketplaceID: marketplaceID,
+		OriginID:      -1,
+		State:         marketplaceapp.Ready,
+		AppTemplate:   appTemplate,
+		Template:      dyn.NewTemplate(),
+	})
+}
+
+// SetMarketplaceAppSource sets where the appliance is downloaded from, as
+// the marketplace does once the image is uploaded.
+func (s *Server) SetMarketplaceAppSource(id int, source, md5 string, sizeMB int, format string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app := s.apps[id]
+	app.Source, app.MD5, app.Size, app.Format = source, md5, sizeMB, format
+}
+
+// MarketplaceApp returns a copy of the appliance.
+func (s *Server) MarketplaceApp(id int) (MarketplaceApp, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[id]
+	if !ok {
+		return MarketplaceApp{}, false
+	}
+	c := *app
+	c.Template = cloneTemplate(app.Template)
+	c.next = nil
+	return c, true
+}
@@ -0,0 +1,156 @@
+package fakeone
+
+import (
+	"bytes"
+	"net/netip"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+)
+
+// Network is a virtual network of the fake.
+type Network struct {
+	ID         int
+	Name       string
+	Bridge     string
+	ClusterIDs []int
+	ARs        []AddressRange
+	Template   *dyn.Template
+	Ownership
+}
+
+// AddressRange is an IP4 address range of a network.
+type AddressRange struct {
+	IP         string
+	Size       int
+	UsedLeases int
+}
+
+func (s *Server) getNetwork(id int) (*Network, error) {
+	n, ok := s.networks[id]
+	if !ok {
+		return nil, errorf(codeNoExists, "Error getting virtual network [%d].", id)
+	}
+	return n, nil
+}
+
+// networkPoolInfo lists the networks, without their address ranges like
+// OpenNebula.
+func (s *Server) networkPoolInfo(a args) (interface{}, error) {
+	who := parameters.PoolWhoAll
+	if len(a) > 0 {
+		who, _ = a.int(0)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<VNET_POOL>")
+	for id := 0; id < s.nextNetworkID; id++ {
+		if n, ok := s.networks[id]; ok && n.visible(who) {
+			writeNetworkXML(&b, n, false)
+		}
+	}
+	b.WriteString("</VNET_POOL>")
+	return b.String(), nil
+}
+
+func (s *Server) networkInfo(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.getNetwork(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	writeNetworkXML(&b, n, true)
+	return b.String(), nil
+}
+
+func (s *Server) networkUpdate(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	str, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.getNetwork(id)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	updateType, _ := a.int(2)
+	if parameters.UpdateType(updateType) == parameters.Merge {
+		mergeTemplate(n.Template, tpl)
+	} else {
+		n.Template = tpl
+	}
+	return id, nil
+}
+
+func writeNetworkXML(b *bytes.Buffer, n *Network, withARs bool) {
+	usedLeases := 0
+	for _, ar := range n.ARs {
+		usedLeases += ar.UsedLeases
+	}
+
+	b.WriteString("<VNET>")
+	writeElement(b, "ID", n.ID)
+	n.Ownership.writeXML(b)
+	writeElement(b, "NAME", n.Name)
+	n.Ownership.writePermissionsXML(b)
+	writeClustersXML(b, n.ClusterIDs)
+	writeElement(b, "BRIDGE", n.Bridge)
+	writeElement(b, "STATE", 1)
+	writeElement(b, "VN_MAD", "bridge")
+	writeElement(b, "USED_LEASES", usedLeases)
+	writeTemplateXML(b, "TEMPLATE", n.Template)
+	b.WriteString("<AR_POOL>")
+	if withARs {
+		for i, ar := range n.ARs {
+			b.WriteString("<AR>")
+			writeElement(b, "AR_ID", i)
+			writeElement(b, "IP", ar.IP)
+			if first, err := netip.ParseAddr(ar.IP); err == nil {
+				last := first
+				for j := 1; j < ar.Size; j++ {
+					last = last.Next()
+				}
+				writeElement(b, "IP_END", last)
+			}
+			writeElement(b, "MAC", "02:00:00:00:00:00")
+			writeElement(b, "SIZE", ar.Size)
+			writeElement(b, "TYPE", "IP4")
+			writeElement(b, "USED_LEASES", ar.UsedLeases)
+			b.WriteString("</AR>")
+		}
+	}
+	b.WriteString("</AR_POOL>")
+	b.WriteString("</VNET>")
+}
+
+// AddNetwork registers a bridged network in the clusters with the address
+// ranges, and returns its ID.
+func (s *Server) AddNetwork(name, bridge string, clusterIDs []int, ars ...AddressRange) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextNetworkID
+	s.nextNetworkID++
+	s.networks[id] = &Network{
+		ID:         id,
+		Name:       name,
+		Bridge:     bridge,
+		ClusterIDs: clusterIDs,
+		ARs:        ars,
+		Template:   dyn.NewTemplate(),
+		Ownership:  defaultOwnership(),
+	}
+	return id
+}
@@ -0,0 +1,360 @@
+// Package fakeone is an in-process fake of the OpenNebula XML-RPC API, so
+// that steps and builders can be tested offline with a real goca client.
+//
+// It models the lifecycle of VMs, images and Marketplace appliances the way
+// the steps observe it: every info call moves a resource to its next state,
+// e.g. a new VM goes from PENDING to RUNNING and a new image from LOCKED to
+// READY, so polling loops converge without any delay. Datastores, clusters
+// and virtual networks are static, to be looked up by the data sources.
+// Calls can be made to fail with FailNext and FailAlways.
+package fakeone
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+)
+
+// Version is the OpenNebula version the fake reports.
+const Version = "6.10.0"
+
+// Error codes of OpenNebula responses.
+const (
+	codeNoExists = 0x0400
+	codeAction   = 0x0800
+	codeAPI      = 0x1000
+	codeAllocate = 0x4000
+)
+
+// Host and cluster the VMs of the fake are deployed to.
+const (
+	Host      = "fake-host"
+	HostID    = 0
+	ClusterID = 100
+)
+
+// EdgeClusterID is a cluster without hosts, for resources outside
+// ClusterID.
+const EdgeClusterID = 101
+
+// oneError is an unsuccessful OpenNebula response.
+type oneError struct {
+	code int
+	msg  string
+}
+
+func (e *oneError) Error() string { return e.msg }
+
+func errorf(code int, format string, a ...interface{}) error {
+	return &oneError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// Call is a request received by the fake.
+type Call struct {
+	Method string
+	// Args are the parameters of the call, without the session token.
+	Args []interface{}
+}
+
+type failure struct {
+	msg    string
+	always bool
+}
+
+// Server is a fake OpenNebula frontend.
+type Server struct {
+	// URL is the XML-RPC endpoint, to be used as opennebula_url.
+	URL string
+
+	srv *httptest.Server
+
+	mu             sync.Mutex
+	vms            map[int]*VM
+	images         map[int]*Image
+	apps           map[int]*MarketplaceApp
+	templates      map[int]*VMTemplate
+	datastores     map[int]*Datastore
+	networks       map[int]*Network
+	nextVMID       int
+	nextImageID    int
+	nextAppID      int
+	nextTemplateID int
+	// nextDatastoreID follows the default datastores
+	nextDatastoreID int
+	nextNetworkID   int
+	calls           []Call
+	failures        map[string][]failure
+}
+
+// New starts a fake frontend, which is stopped when the test ends.
+func New(tb testing.TB) *Server {
+	s := &Server{
+		vms:        map[int]*VM{},
+		images:     map[int]*Image{},
+		apps:       map[int]*MarketplaceApp{},
+		templates:  map[int]*VMTemplate{},
+		datastores: defaultDatastores(),
+		networks:   map[int]*Network{},
+		failures:   map[string][]failure{},
+	}
+	s.nextDatastoreID = len(s.datastores)
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL + "/RPC2"
+	tb.Cleanup(s.srv.Close)
+	return s
+}
+
+// FailNext makes the next call of method fail with msg.
+func (s *Server) FailNext(method, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failure{msg: msg})
+}
+
+// FailAlways makes every following call of method fail with msg.
+func (s *Server) FailAlways(method, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], failure{msg: msg, always: true})
+}
+
+// Calls returns the calls received for method, or all of them if method is
+// empty.
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Actions returns the VM actions, e.g. "poweroff", requested for the VM.
+func (s *Server) Actions(vmID int) []string {
+	var actions []string
+	for _, c := range s.Calls("one.vm.action") {
+		if len(c.Args) == 2 && c.Args[1] == vmID {
+			actions = append(actions, c.Args[0].(string))
+		}
+	}
+	return actions
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method, a, err := decodeCall(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.call(method, a)
+	w.Header().Set("Content-Type", "text/xml")
+	if err != nil {
+		code := codeAPI
+		if oneErr, ok := err.(*oneError); ok {
+			code = oneErr.code
+		}
+		w.Write(encodeResponse(false, fmt.Sprintf("[%s] %s", method, err), code))
+		return
+	}
+	w.Write(encodeResponse(true, result, 0))
+}
+
+func (s *Server) call(method string, a args) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, Call{Method: method, Args: a})
+	if failures := s.failures[method]; len(failures) > 0 {
+		f := failures[0]
+		if !f.always {
+			s.failures[method] = failures[1:]
+		}
+		return nil, errorf(codeAction, "%s", f.msg)
+	}
+
+	switch method {
+	case "one.system.version":
+		return Version, nil
+	case "one.vm.allocate":
+		return s.vmAllocate(a)
+	case "one.vm.info":
+		return s.vmInfo(a)
+	case "one.vm.action":
+		return s.vmAction(a)
+	case "one.vm.update":
+		return s.vmUpdate(a)
+	case "one.vm.updateconf":
+		return s.vmUpdateConf(a)
+	case "one.vm.disksaveas":
+		return s.vmDiskSaveas(a)
+	case "one.vm.detach":
+		return s.vmDetach(a)
+	case "one.image.allocate":
+		return s.imageAllocate(a)
+	case "one.image.info":
+		return s.imageInfo(a)
+	case "one.image.clone":
+		return s.imageClone(a)
+	case "one.image.delete":
+		return s.imageDelete(a)
+	case "one.image.update":
+		return s.imageUpdate(a)
+	case "one.imagepool.info":
+		return s.imagePoolInfo(a)
+	case "one.image.chown":
+		return s.imageChown(a)
+	case "one.image.chmod":
+		return s.imageChmod(a)
+	case "one.template.allocate":
+		return s.templateAllocate(a)
+	case "one.template.info":
+		return s.templateInfo(a)
+	case "one.template.update":
+		return s.templateUpdate(a)
+	case "one.template.clone":
+		return s.templateClone(a)
+	case "one.template.delete":
+		return s.templateDelete(a)
+	case "one.template.chown":
+		return s.templateChown(a)
+	case "one.template.chmod":
+		return s.templateChmod(a)
+	case "one.templatepool.info":
+		return s.templatePoolInfo(a)
+	case "one.userpool.info":
+		return s.userPoolInfo()
+	case "one.grouppool.info":
+		return s.groupPoolInfo()
+	case "one.marketapp.allocate":
+		return s.marketAppAllocate(a)
+	case "one.marketapp.info":
+		return s.marketAppInfo(a)
+	case "one.marketapp.delete":
+		return s.marketAppDelete(a)
+	case "one.marketapppool.info":
+		return s.marketAppPoolInfo()
+	case "one.marketpool.info":
+		return s.marketplacePoolInfo()
+	case "one.datastorepool.info":
+		return s.datastorePoolInfo()
+	case "one.datastore.update":
+		return s.datastoreUpdate(a)
+	case "one.clusterpool.info":
+		return s.clusterPoolInfo()
+	case "one.vnpool.info":
+		return s.networkPoolInfo(a)
+	case "one.vn.info":
+		return s.networkInfo(a)
+	case "one.vn.update":
+		return s.networkUpdate(a)
+	}
+	return nil, errorf(codeAPI, "method %s is not supported by the fake", method)
+}
+
+// mergeTemplate replaces the attributes of tpl with the ones of update.
+func mergeTemplate(tpl, update *dyn.Template) {
+	for _, element := range update.Elements {
+		tpl.Del(element.Key())
+	}
+	tpl.Elements = append(tpl.Elements, cloneTemplate(update).Elements...)
+}
+
+// SetVMState moves the VM to the given state, e.g. to POWEROFF when the
+// guest shuts itself down at the end of an installation.
+func (s *Server) SetVMState(id int, state vm.State, lcmState vm.LCMState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.vms[id]
+	v.State, v.LCMState, v.next = state, lcmState, nil
+}
+
+// VM returns a copy of the VM.
+func (s *Server) VM(id int) (VM, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vms[id]
+	if !ok {
+		return VM{}, false
+	}
+	c := *v
+	c.Template = cloneTemplate(v.Template)
+	c.UserTemplate = cloneTemplate(v.UserTemplate)
+	c.next = nil
+	return c, true
+}
+
+// VMs returns copies of all the VMs, by ID.
+func (s *Server) VMs() []VM {
+	s.mu.Lock()
+	ids := make([]int, 0, len(s.vms))
+	for id := range s.vms {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	sort.Ints(ids)
+	vms := make([]VM, 0, len(ids))
+	for _, id := range ids {
+		v, _ := s.VM(id)
+		vms = append(vms, v)
+	}
+	return vms
+}
+
+// AddImage registers a READY image, e.g. an existing base image, and
+// returns its ID.
+func (s *Server) AddImage(name, imageType string, datastoreID int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newImage(name, imageType, datastoreID, image.Ready, dyn.NewTemplate())
+}
+
+// Image returns a copy of the image.
+func (s *Server) Image(id int) (Image, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img, ok := s.images[id]
+	if !ok {
+		return Image{}, false
+	}
+	c := *img
+	c.State = s.imageState(img)
+	c.Template = cloneTemplate(img.Template)
+	c.next = nil
+	return c, true
+}
+
+// Images returns copies of all the images, by ID.
+func (s *Server) Images() []Image {
+	s.mu.Lock()
+	ids := make([]int, 0, len(s.images))
+	for id := range s.images {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	sort.Ints(ids)
+	images := make([]Image, 0, len(ids))
+	for _, id := range ids {
+		img, _ := s.Image(id)
+		images = append(images, img)
+	}
+	return images
+}
@@ -0,0 +1,179 @@
+package fakeone
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+)
+
+// ParseTemplate parses a template in OpenNebula syntax, as sent to the
+// allocate and update calls. Keys are upper cased like OpenNebula does.
+func ParseTemplate(s string) (*dyn.Template, error) {
+	p := &templateParser{s: s}
+	tpl := dyn.NewTemplate()
+	for {
+		p.skip(" \t\r\n")
+		if p.eof() {
+			return tpl, nil
+		}
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != '[' {
+			value, err := p.value("\n")
+			if err != nil {
+				return nil, err
+			}
+			tpl.AddPair(key, value)
+			continue
+		}
+
+		p.pos++
+		vec := tpl.AddVector(key)
+		for {
+			p.skip(" \t\r\n,")
+			if p.eof() {
+				return nil, fmt.Errorf("vector %s is not closed", key)
+			}
+			if p.peek() == ']' {
+				p.pos++
+				break
+			}
+			vkey, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			value, err := p.value(",]\n")
+			if err != nil {
+				return nil, err
+			}
+			vec.AddPair(vkey, value)
+		}
+	}
+}
+
+type templateParser struct {
+	s   string
+	pos int
+}
+
+func (p *templateParser) eof() bool { return p.pos >= len(p.s) }
+
+func (p *templateParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *templateParser) skip(chars string) {
+	for !p.eof() {
+		c := p.peek()
+		if c == '#' {
+			// Comments run to the end of the line
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if !strings.ContainsRune(chars, rune(c)) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// key reads an attribute name and the following equal sign.
+func (p *templateParser) key() (string, error) {
+	start := p.pos
+	for !p.eof() && p.peek() != '=' && p.peek() != '\n' {
+		p.pos++
+	}
+	key := strings.TrimSpace(p.s[start:p.pos])
+	if p.peek() != '=' || key == "" {
+		return "", fmt.Errorf("expected an attribute at %q", p.s[start:p.pos])
+	}
+	p.pos++
+	p.skip(" \t")
+	return strings.ToUpper(key), nil
+}
+
+// value reads a quoted value or an unquoted one up to one of the stop
+// characters.
+func (p *templateParser) value(stop string) (string, error) {
+	if p.peek() != '"' {
+		start := p.pos
+		for !p.eof() && !strings.ContainsRune(stop, rune(p.peek())) {
+			p.pos++
+		}
+		return strings.TrimSpace(p.s[start:p.pos]), nil
+	}
+
+	p.pos++
+	start := p.pos
+	for !p.eof() && p.peek() != '"' {
+		if p.peek() == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.eof() {
+		return "", fmt.Errorf("unterminated value at %q", p.s[start-1:])
+	}
+	raw := p.s[start:p.pos]
+	p.pos++
+
+	// goca quotes values the Go way when it can, or only escapes quotes and
+	// backslashes otherwise
+	if value, err := strconv.Unquote(`"` + raw + `"`); err == nil {
+		return value, nil
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(raw), nil
+}
+
+// writeTemplateXML writes tpl as the XML element name, the way OpenNebula
+// returns templates.
+func writeTemplateXML(b *bytes.Buffer, name string, tpl *dyn.Template) {
+	fmt.Fprintf(b, "<%s>", name)
+	for _, element := range tpl.Elements {
+		switch e := element.(type) {
+		case *dyn.Pair:
+			writeElement(b, e.Key(), e.Value)
+		case *dyn.Vector:
+			fmt.Fprintf(b, "<%s>", e.Key())
+			for _, pair := range e.Pairs {
+				writeElement(b, pair.Key(), pair.Value)
+			}
+			fmt.Fprintf(b, "</%s>", e.Key())
+		}
+	}
+	fmt.Fprintf(b, "</%s>", name)
+}
+
+func writeElement(b *bytes.Buffer, name string, value interface{}) {
+	fmt.Fprintf(b, "<%s>", name)
+	xml.EscapeText(b, []byte(fmt.Sprint(value)))
+	fmt.Fprintf(b, "</%s>", name)
+}
+
+// cloneTemplate returns a deep copy of tpl.
+func cloneTemplate(tpl *dyn.Template) *dyn.Template {
+	clone := dyn.NewTemplate()
+	for _, element := range tpl.Elements {
+		switch e := element.(type) {
+		case *dyn.Pair:
+			clone.AddPair(e.Key(), e.Value)
+		case *dyn.Vector:
+			vec := clone.AddVector(e.Key())
+			for _, pair := range e.Pairs {
+				vec.AddPair(pair.Key(), pair.Value)
+			}
+		}
+	}
+	return clone
+}
@@ -0,0 +1,45 @@
+package fakeone
+
+import (
+	"testing"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	vmk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm/keys"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tpl := vm.NewTemplate()
+	tpl.Add(vmk.Name, "packer \"quoted\"")
+	tpl.Memory(1024)
+	tpl.AddDisk().Add("IMAGE_ID", 3)
+	tpl.AddOS(vmk.KernelCmd, "console=ttyS0 ds=nocloud-net;s=http://192.0.2.10:8100/")
+
+	parsed, err := ParseTemplate(tpl.String() + "\n# comment\nlabels = packer\n")
+	if err != nil {
+		t.Fatalf("ParseTemplate: %s", err)
+	}
+	expected := map[string]string{
+		"NAME":   "packer \"quoted\"",
+		"MEMORY": "1024",
+		"LABELS": "packer",
+	}
+	for key, value := range expected {
+		if got, _ := parsed.GetStr(key); got != value {
+			t.Errorf("%s = %q, expected %q", key, got, value)
+		}
+	}
+	if imageID, _ := parsed.GetStrFromVec("DISK", "IMAGE_ID"); imageID != "3" {
+		t.Errorf("DISK/IMAGE_ID = %q, expected 3", imageID)
+	}
+	if cmd, _ := parsed.GetStrFromVec("OS", "KERNEL_CMD"); cmd != "console=ttyS0 ds=nocloud-net;s=http://192.0.2.10:8100/" {
+		t.Errorf("OS/KERNEL_CMD = %q", cmd)
+	}
+}
+
+func TestParseTemplate_error(t *testing.T) {
+	for _, s := range []string{`NAME="unterminated`, `DISK=[ IMAGE_ID="1"`, `="value"`} {
+		if _, err := ParseTemplate(s); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded, expected an error", s)
+		}
+	}
+}
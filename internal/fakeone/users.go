@@ -0,0 +1,152 @@
+package fakeone
+
+import (
+	"bytes"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+)
+
+// The fake frontend has fixed users and groups. Every call is made as
+// oneadmin, whatever the credentials of the client.
+var (
+	users = []struct {
+		name string
+		gid  int
+	}{
+		{"oneadmin", 0},
+		{"serveradmin", 0},
+		{"packer", 1},
+	}
+	groups = []string{"oneadmin", "users"}
+)
+
+// sessionUID is the user the calls are made as.
+const sessionUID = 0
+
+// Ownership is the owner, group and permissions of a resource.
+type Ownership struct {
+	UID int
+	GID int
+	// Permissions are the USE, MANAGE and ADMIN bits of the owner, the
+	// group and the others, in the order of one.*.chmod.
+	Permissions [9]int
+}
+
+// defaultOwnership is the ownership of the resources created by the session
+// user, with the default umask of 177.
+func defaultOwnership() Ownership {
+	return Ownership{UID: sessionUID, GID: users[sessionUID].gid, Permissions: [9]int{1, 1}}
+}
+
+func userName(uid int) string {
+	if uid >= 0 && uid < len(users) {
+		return users[uid].name
+	}
+	return ""
+}
+
+func groupName(gid int) string {
+	if gid >= 0 && gid < len(groups) {
+		return groups[gid]
+	}
+	return ""
+}
+
+// visible reports whether the resource is listed by a pool info call made
+// with the filter flag who.
+func (o *Ownership) visible(who int) bool {
+	switch who {
+	case parameters.PoolWhoAll:
+		return true
+	case parameters.PoolWhoMine:
+		return o.UID == sessionUID
+	case parameters.PoolWhoGroup:
+		return o.UID == sessionUID || o.GID == users[sessionUID].gid
+	case parameters.PoolWhoPrimaryGroup:
+		return o.GID == users[sessionUID].gid
+	}
+	return o.UID == who
+}
+
+// chown applies the arguments of one.*.chown, -1 keeping the current value.
+func (o *Ownership) chown(a args) error {
+	uid, err := a.int(1)
+	if err != nil {
+		return err
+	}
+	gid, err := a.int(2)
+	if err != nil {
+		return err
+	}
+	if uid != -1 {
+		if userName(uid) == "" {
+			return errorf(codeNoExists, "Error getting user [%d].", uid)
+		}
+		o.UID = uid
+	}
+	if gid != -1 {
+		if groupName(gid) == "" {
+			return errorf(codeNoExists, "Error getting group [%d].", gid)
+		}
+		o.GID = gid
+	}
+	return nil
+}
+
+// chmod applies the arguments of one.*.chmod, -1 keeping the current value.
+func (o *Ownership) chmod(a args) error {
+	for i := range o.Permissions {
+		bit, err := a.int(i + 1)
+		if err != nil {
+			return err
+		}
+		if bit != -1 {
+			o.Permissions[i] = bit
+		}
+	}
+	return nil
+}
+
+func (o *Ownership) writeXML(b *bytes.Buffer) {
+	writeElement(b, "UID", o.UID)
+	writeElement(b, "GID", o.GID)
+	writeElement(b, "UNAME", userName(o.UID))
+	writeElement(b, "GNAME", groupName(o.GID))
+}
+
+func (o *Ownership) writePermissionsXML(b *bytes.Buffer) {
+	b.WriteString("<PERMISSIONS>")
+	for i, key := range []string{"OWNER_U", "OWNER_M", "OWNER_A", "GROUP_U", "GROUP_M", "GROUP_A", "OTHER_U", "OTHER_M", "OTHER_A"} {
+		writeElement(b, key, o.Permissions[i])
+	}
+	b.WriteString("</PERMISSIONS>")
+}
+
+func (s *Server) userPoolInfo() (interface{}, error) {
+	var b bytes.Buffer
+	b.WriteString("<USER_POOL>")
+	for uid, u := range users {
+		b.WriteString("<USER>")
+		writeElement(&b, "ID", uid)
+		writeElement(&b, "GID", u.gid)
+		writeElement(&b, "GNAME", groupName(u.gid))
+		writeElement(&b, "NAME", u.name)
+		writeElement(&b, "ENABLED", 1)
+		b.WriteString("<TEMPLATE></TEMPLATE></USER>")
+	}
+	b.WriteString("</USER_POOL>")
+	return b.String(), nil
+}
+
+func (s *Server) groupPoolInfo() (interface{}, error) {
+	var b bytes.Buffer
+	b.WriteString("<GROUP_POOL>")
+	for gid, name := range groups {
+		b.WriteString("<GROUP>")
+		writeElement(&b, "ID", gid)
+		writeElement(&b, "NAME", name)
+		b.WriteString("<TEMPLATE></TEMPLATE></GROUP>")
+	}
+	b.WriteString("</GROUP_POOL>")
+	return b.String(), nil
+}
@@ -0,0 +1,382 @@
+package fakeone
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+)
+
+// VM is a virtual machine of the fake.
+type VM struct {
+	ID           int
+	Name         string
+	State        vm.State
+	LCMState     vm.LCMState
+	Template     *dyn.Template
+	UserTemplate *dyn.Template
+	// Deployed is set once the VM ran on Host.
+	Deployed bool
+
+	// next are the states reached on the next info calls
+	next []vmState
+}
+
+type vmState struct {
+	state vm.State
+	lcm   vm.LCMState
+}
+
+var (
+	stateRunning  = vmState{vm.Active, vm.Running}
+	statePoweroff = vmState{vm.Poweroff, vm.LcmInit}
+	stateDone     = vmState{vm.Done, vm.LcmInit}
+)
+
+func (v *VM) is(st vmState) bool {
+	return v.State == st.state && v.LCMState == st.lcm
+}
+
+// moveTo sets the current state of the VM and the ones it reaches next.
+func (v *VM) moveTo(current vmState, next ...vmState) {
+	v.State, v.LCMState = current.state, current.lcm
+	v.next = next
+	if v.is(stateRunning) {
+		v.Deployed = true
+	}
+}
+
+// disk returns the DISK vector with the given DISK_ID.
+func (v *VM) disk(diskID int) (*dyn.Vector, int) {
+	for i, element := range v.Template.Elements {
+		disk, ok := element.(*dyn.Vector)
+		if !ok || disk.Key() != "DISK" {
+			continue
+		}
+		if id, err := disk.GetInt("DISK_ID"); err == nil && id == diskID {
+			return disk, i
+		}
+	}
+	return nil, -1
+}
+
+// uses reports whether the VM holds the image as one of its disks.
+func (v *VM) uses(imageID int) bool {
+	if v.State == vm.Done {
+		return false
+	}
+	for _, disk := range v.Template.GetVectors("DISK") {
+		if id, err := disk.GetInt("IMAGE_ID"); err == nil && id == imageID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getVM(id int) (*VM, error) {
+	v, ok := s.vms[id]
+	if !ok {
+		return nil, errorf(codeNoExists, "Error getting virtual machine [%d].", id)
+	}
+	return v, nil
+}
+
+func (s *Server) vmAllocate(a args) (interface{}, error) {
+	str, err := a.str(0)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	id := s.nextVMID
+	v := &VM{ID: id, Name: fmt.Sprintf("one-%d", id), Template: tpl, UserTemplate: dyn.NewTemplate()}
+	if name, err := tpl.GetStr("NAME"); err == nil && name != "" {
+		v.Name = name
+	}
+	tpl.Del("NAME")
+
+	// Resolve the images of the disks like OpenNebula does
+	for i, disk := range tpl.GetVectors("DISK") {
+		var img *Image
+		if imageID, err := disk.GetInt("IMAGE_ID"); err == nil {
+			img = s.images[imageID]
+		} else if name, err := disk.GetStr("IMAGE"); err == nil {
+			img = s.imageByName(name)
+		}
+		if img == nil {
+			return nil, errorf(codeAllocate, "Error allocating a new virtual machine template. DISK %d: image does not exist", i)
+		}
+		if st := s.imageState(img); st != image.Ready && st != image.Used {
+			return nil, errorf(codeAllocate, "Error allocating a new virtual machine template. DISK %d: image %d is in state %s", i, img.ID, st)
+		}
+
+		disk.Del("IMAGE")
+		disk.Del("IMAGE_ID")
+		disk.AddPair("DISK_ID", i)
+		disk.AddPair("IMAGE", img.Name)
+		disk.AddPair("IMAGE_ID", img.ID)
+		disk.AddPair("DATASTORE_ID", img.DatastoreID)
+		if img.Type == "CDROM" {
+			disk.AddPair("TYPE", "CDROM")
+		} else {
+			disk.AddPair("TYPE", "FILE")
+		}
+	}
+	for i, nic := range tpl.GetVectors("NIC") {
+		nic.AddPair("NIC_ID", i)
+		nic.AddPair("IP", fmt.Sprintf("10.0.%d.%d", id/250, id%250+2))
+	}
+	if graphics, err := tpl.GetVector("GRAPHICS"); err == nil {
+		graphics.Del("PORT")
+		graphics.AddPair("PORT", 5900+id)
+	}
+	tpl.AddPair("VMID", id)
+
+	if a.bool(1) {
+		v.moveTo(vmState{vm.Hold, vm.LcmInit})
+	} else {
+		v.moveTo(vmState{vm.Pending, vm.LcmInit}, stateRunning)
+	}
+	s.vms[id] = v
+	s.nextVMID++
+	return id, nil
+}
+
+func (s *Server) vmInfo(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.getVM(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(v.next) > 0 {
+		v.moveTo(v.next[0], v.next[1:]...)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<VM>")
+	writeElement(&b, "ID", v.ID)
+	writeElement(&b, "UID", 0)
+	writeElement(&b, "GID", 0)
+	writeElement(&b, "UNAME", "oneadmin")
+	writeElement(&b, "GNAME", "oneadmin")
+	writeElement(&b, "NAME", v.Name)
+	writeElement(&b, "STATE", int(v.State))
+	writeElement(&b, "LCM_STATE", int(v.LCMState))
+	writeTemplateXML(&b, "TEMPLATE", v.Template)
+	writeTemplateXML(&b, "USER_TEMPLATE", v.UserTemplate)
+	if v.Deployed {
+		b.WriteString("<HISTORY_RECORDS><HISTORY>")
+		writeElement(&b, "OID", v.ID)
+		writeElement(&b, "SEQ", 0)
+		writeElement(&b, "HOSTNAME", Host)
+		writeElement(&b, "HID", HostID)
+		writeElement(&b, "CID", ClusterID)
+		writeElement(&b, "DS_ID", 0)
+		b.WriteString("</HISTORY></HISTORY_RECORDS>")
+	}
+	b.WriteString("</VM>")
+	return b.String(), nil
+}
+
+func (s *Server) vmAction(a args) (interface{}, error) {
+	action, err := a.str(0)
+	if err != nil {
+		return nil, err
+	}
+	id, err := a.int(1)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.getVM(id)
+	if err != nil {
+		return nil, err
+	}
+
+	wrongState := func() error {
+		return errorf(codeAction, "Error performing action %q on virtual machine [%d]: wrong state %s/%s", action, id, v.State, v.LCMState)
+	}
+
+	switch action {
+	case "poweroff", "poweroff-hard":
+		if !v.is(stateRunning) {
+			return nil, wrongState()
+		}
+		v.moveTo(vmState{vm.Active, vm.ShutdownPoweroff}, statePoweroff)
+	case "resume":
+		if !v.is(statePoweroff) {
+			return nil, wrongState()
+		}
+		v.moveTo(vmState{vm.Active, vm.BootPoweroff}, stateRunning)
+	case "release":
+		if v.State != vm.Hold {
+			return nil, wrongState()
+		}
+		v.moveTo(vmState{vm.Pending, vm.LcmInit}, stateRunning)
+	case "terminate":
+		switch {
+		case v.is(stateRunning):
+			v.moveTo(vmState{vm.Active, vm.Shutdown}, stateDone)
+		case v.State == vm.Poweroff || v.State == vm.Hold || v.State == vm.Pending:
+			v.moveTo(stateDone)
+		default:
+			return nil, wrongState()
+		}
+	case "terminate-hard":
+		if v.State == vm.Done {
+			return nil, wrongState()
+		}
+		v.moveTo(stateDone)
+	default:
+		return nil, errorf(codeAPI, "action %q is not supported by the fake", action)
+	}
+	return id, nil
+}
+
+func (s *Server) vmUpdate(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	str, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.getVM(id)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	updateType, _ := a.int(2)
+	if parameters.UpdateType(updateType) == parameters.Merge {
+		mergeTemplate(v.UserTemplate, tpl)
+	} else {
+		v.UserTemplate = tpl
+	}
+	return id, nil
+}
+
+// vmUpdateConf replaces the sections given in the template, e.g. OS, of a
+// powered off VM.
+func (s *Server) vmUpdateConf(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	str, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.getVM(id)
+	if err != nil {
+		return nil, err
+	}
+	if v.State != vm.Poweroff && v.State != vm.Pending && v.State != vm.Hold {
+		return nil, errorf(codeAction, "Error updating the configuration of virtual machine [%d]: wrong state %s", id, v.State)
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+	mergeTemplate(v.Template, tpl)
+	return id, nil
+}
+
+func (s *Server) vmDiskSaveas(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	diskID, err := a.int(1)
+	if err != nil {
+		return nil, err
+	}
+	name, err := a.str(2)
+	if err != nil {
+		return nil, err
+	}
+	imageType, _ := a.str(3)
+
+	v, err := s.getVM(id)
+	if err != nil {
+		return nil, err
+	}
+	disk, _ := v.disk(diskID)
+	if disk == nil {
+		return nil, errorf(codeNoExists, "VM disk does not exist: %d", diskID)
+	}
+	if name == "" {
+		return nil, errorf(codeAction, "Error saving disk %d of virtual machine [%d]: image name is empty", diskID, id)
+	}
+	if s.imageByName(name) != nil {
+		return nil, errorf(codeAllocate, "NAME is already taken by IMAGE %d.", s.imageByName(name).ID)
+	}
+
+	switch {
+	case v.is(stateRunning):
+		v.moveTo(vmState{vm.Active, vm.HotplugSaveas}, stateRunning)
+	case v.is(statePoweroff):
+		v.moveTo(vmState{vm.Active, vm.HotplugSaveasPoweroff}, statePoweroff)
+	default:
+		return nil, errorf(codeAction, "Error saving disk %d of virtual machine [%d]: wrong state %s/%s", diskID, id, v.State, v.LCMState)
+	}
+
+	sourceID, _ := disk.GetInt("IMAGE_ID")
+	source := s.images[sourceID]
+	datastoreID := 1
+	if source != nil {
+		datastoreID = source.DatastoreID
+		if imageType == "" {
+			imageType = source.Type
+		}
+	}
+	if imageType == "" {
+		imageType = "OS"
+	}
+	imageID := s.newImage(name, imageType, datastoreID, image.Locked, dyn.NewTemplate(), image.Ready)
+	return imageID, nil
+}
+
+func (s *Server) vmDetach(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	diskID, err := a.int(1)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.getVM(id)
+	if err != nil {
+		return nil, err
+	}
+	_, index := v.disk(diskID)
+	if index < 0 {
+		return nil, errorf(codeNoExists, "Error detaching disk: DISK_ID %s does not exist", strconv.Itoa(diskID))
+	}
+
+	switch {
+	case v.is(stateRunning):
+		v.moveTo(vmState{vm.Active, vm.Hotplug}, stateRunning)
+	case v.is(statePoweroff):
+		v.moveTo(vmState{vm.Active, vm.HotplugEpilogPoweroff}, statePoweroff)
+	default:
+		return nil, errorf(codeAction, "Error detaching disk %d of virtual machine [%d]: wrong state %s/%s", diskID, id, v.State, v.LCMState)
+	}
+	v.Template.Elements = append(v.Template.Elements[:index], v.Template.Elements[index+1:]...)
+	return id, nil
+}
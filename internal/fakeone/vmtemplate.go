@@ -0,0 +1,224 @@
+package fakeone
+
+import (
+	"bytes"
+	"sort"
+
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+)
+
+// VMTemplate is a VM template of the fake.
+type VMTemplate struct {
+	ID       int
+	Name     string
+	Template *dyn.Template
+	Ownership
+}
+
+func (s *Server) newTemplate(name string, tpl *dyn.Template) int {
+	id := s.nextTemplateID
+	s.nextTemplateID++
+	s.templates[id] = &VMTemplate{ID: id, Name: name, Template: tpl, Ownership: defaultOwnership()}
+	return id
+}
+
+func (s *Server) getTemplate(id int) (*VMTemplate, error) {
+	t, ok := s.templates[id]
+	if !ok {
+		return nil, errorf(codeNoExists, "Error getting template [%d].", id)
+	}
+	return t, nil
+}
+
+func (s *Server) templateAllocate(a args) (interface{}, error) {
+	str, err := a.str(0)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+	name, _ := tpl.GetStr("NAME")
+	if name == "" {
+		return nil, errorf(codeAllocate, "Error allocating a new template. No NAME in template.")
+	}
+	for _, t := range s.templates {
+		if t.Name == name && t.UID == sessionUID {
+			return nil, errorf(codeAllocate, "Error allocating a new template. NAME is already taken by TEMPLATE %d.", t.ID)
+		}
+	}
+	tpl.Del("NAME")
+	return s.newTemplate(name, tpl), nil
+}
+
+func (s *Server) templateInfo(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	writeVMTemplateXML(&b, t)
+	return b.String(), nil
+}
+
+func (s *Server) templateUpdate(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	str, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := ParseTemplate(str)
+	if err != nil {
+		return nil, errorf(codeAPI, "Parse error: %s", err)
+	}
+
+	updateType, _ := a.int(2)
+	if parameters.UpdateType(updateType) == parameters.Merge {
+		mergeTemplate(t.Template, tpl)
+	} else {
+		t.Template = tpl
+	}
+	return id, nil
+}
+
+func (s *Server) templateClone(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	name, err := a.str(1)
+	if err != nil {
+		return nil, err
+	}
+	source, err := s.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range s.templates {
+		if t.Name == name && t.UID == sessionUID {
+			return nil, errorf(codeAllocate, "Error cloning template [%d]. NAME is already taken by TEMPLATE %d.", id, t.ID)
+		}
+	}
+	return s.newTemplate(name, cloneTemplate(source.Template)), nil
+}
+
+func (s *Server) templateDelete(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.getTemplate(id); err != nil {
+		return nil, err
+	}
+	delete(s.templates, id)
+	return id, nil
+}
+
+func (s *Server) templateChown(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+	return id, t.chown(a)
+}
+
+func (s *Server) templateChmod(a args) (interface{}, error) {
+	id, err := a.int(0)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+	return id, t.chmod(a)
+}
+
+func (s *Server) templatePoolInfo(a args) (interface{}, error) {
+	who := parameters.PoolWhoAll
+	if len(a) > 0 {
+		who, _ = a.int(0)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<VMTEMPLATE_POOL>")
+	for id := 0; id < s.nextTemplateID; id++ {
+		if t, ok := s.templates[id]; ok && t.visible(who) {
+			writeVMTemplateXML(&b, t)
+		}
+	}
+	b.WriteString("</VMTEMPLATE_POOL>")
+	return b.String(), nil
+}
+
+func writeVMTemplateXML(b *bytes.Buffer, t *VMTemplate) {
+	b.WriteString("<VMTEMPLATE>")
+	writeElement(b, "ID", t.ID)
+	t.Ownership.writeXML(b)
+	writeElement(b, "NAME", t.Name)
+	t.Ownership.writePermissionsXML(b)
+	writeElement(b, "REGTIME", 1700000000+t.ID)
+	writeTemplateXML(b, "TEMPLATE", t.Template)
+	b.WriteString("</VMTEMPLATE>")
+}
+
+// Templates returns copies of all the VM templates, by ID.
+func (s *Server) Templates() []VMTemplate {
+	s.mu.Lock()
+	ids := make([]int, 0, len(s.templates))
+	for id := range s.templates {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	sort.Ints(ids)
+	templates := make([]VMTemplate, 0, len(ids))
+	for _, id := range ids {
+		t, _ := s.Template(id)
+		templates = append(templates, t)
+	}
+	return templates
+}
+
+// AddTemplate registers a VM template with the attributes of the template
+// string tpl, and returns its ID.
+func (s *Server) AddTemplate(name, tpl string) int {
+	parsed, err := ParseTemplate(tpl)
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newTemplate(name, parsed)
+}
+
+// Template returns a copy of the VM template.
+func (s *Server) Template(id int) (VMTemplate, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.templates[id]
+	if !ok {
+		return VMTemplate{}, false
+	}
+	c := *t
+	c.Template = cloneTemplate(t.Template)
+	return c, true
+}
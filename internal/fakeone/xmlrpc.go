@@ -0,0 +1,114 @@
+package fakeone
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// methodCall is an XML-RPC request as sent by goca.
+type methodCall struct {
+	Name   string  `xml:"methodName"`
+	Params []param `xml:"params>param>value"`
+}
+
+// param is an XML-RPC value. goca only sends scalars.
+type param struct {
+	Int     *string `xml:"int"`
+	I4      *string `xml:"i4"`
+	I8      *string `xml:"i8"`
+	Boolean *string `xml:"boolean"`
+	String  *string `xml:"string"`
+	Text    string  `xml:",chardata"`
+}
+
+// value returns the param as an int, bool or string.
+func (p param) value() interface{} {
+	for _, i := range []*string{p.Int, p.I4, p.I8} {
+		if i != nil {
+			n, _ := strconv.Atoi(strings.TrimSpace(*i))
+			return n
+		}
+	}
+	if p.Boolean != nil {
+		return strings.TrimSpace(*p.Boolean) == "1"
+	}
+	if p.String != nil {
+		return *p.String
+	}
+	return p.Text
+}
+
+// args are the parameters of a call, without the session token.
+type args []interface{}
+
+func (a args) int(i int) (int, error) {
+	if i >= len(a) {
+		return 0, fmt.Errorf("missing parameter %d", i)
+	}
+	n, ok := a[i].(int)
+	if !ok {
+		return 0, fmt.Errorf("parameter %d is not an int: %v", i, a[i])
+	}
+	return n, nil
+}
+
+func (a args) str(i int) (string, error) {
+	if i >= len(a) {
+		return "", fmt.Errorf("missing parameter %d", i)
+	}
+	s, ok := a[i].(string)
+	if !ok {
+		return "", fmt.Errorf("parameter %d is not a string: %v", i, a[i])
+	}
+	return s, nil
+}
+
+func (a args) bool(i int) bool {
+	if i >= len(a) {
+		return false
+	}
+	b, _ := a[i].(bool)
+	return b
+}
+
+func decodeCall(body []byte) (string, args, error) {
+	var call methodCall
+	if err := xml.Unmarshal(body, &call); err != nil {
+		return "", nil, err
+	}
+	values := make(args, 0, len(call.Params))
+	for _, p := range call.Params {
+		values = append(values, p.value())
+	}
+	// The first parameter is the session token
+	if len(values) > 0 {
+		values = values[1:]
+	}
+	return call.Name, values, nil
+}
+
+// encodeResponse returns the answer of OpenNebula to a call: whether it
+// succeeded, the body (a string or an int) and the error code.
+func encodeResponse(ok bool, body interface{}, code int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0"?><methodResponse><params><param><value><array><data>`)
+	if ok {
+		b.WriteString("<value><boolean>1</boolean></value>")
+	} else {
+		b.WriteString("<value><boolean>0</boolean></value>")
+	}
+	switch v := body.(type) {
+	case int:
+		fmt.Fprintf(&b, "<value><i4>%d</i4></value>", v)
+	default:
+		b.WriteString("<value><string>")
+		xml.EscapeText(&b, []byte(fmt.Sprint(v)))
+		b.WriteString("</string></value>")
+	}
+	fmt.Fprintf(&b, "<value><i4>%d</i4></value>", code)
+	b.WriteString(`</data></array></value></param></params></methodResponse>`)
+	return b.Bytes()
+}
@@ -7,6 +7,14 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/plugin"
 	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/image"
 	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/iso"
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/kernel"
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/vm"
+	datastoredata "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/datastore"
+	imagedata "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/image"
+	networkdata "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/network"
+	templatedata "github.com/shurkys/packer-plugin-opennebula/datasource/opennebula/template"
+	"github.com/shurkys/packer-plugin-opennebula/post-processor/opennebula/importer"
+	templatepp "github.com/shurkys/packer-plugin-opennebula/post-processor/opennebula/template"
 	"github.com/shurkys/packer-plugin-opennebula/version"
 )
 
@@ -14,6 +22,14 @@ func main() {
 	pps := plugin.NewSet()
 	pps.RegisterBuilder("iso", new(iso.Builder))
 	pps.RegisterBuilder("image", new(image.Builder))
+	pps.RegisterBuilder("vm", new(vm.Builder))
+	pps.RegisterBuilder("kernel", new(kernel.Builder))
+	pps.RegisterDatasource("image", new(imagedata.Datasource))
+	pps.RegisterDatasource("template", new(templatedata.Datasource))
+	pps.RegisterDatasource("network", new(networkdata.Datasource))
+	pps.RegisterDatasource("datastore", new(datastoredata.Datasource))
+	pps.RegisterPostProcessor("import", new(importer.PostProcessor))
+	pps.RegisterPostProcessor("template", new(templatepp.PostProcessor))
 	pps.SetVersion(version.PluginVersion)
 	err := pps.Run()
 	if err != nil {
@@ -0,0 +1,303 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm"
+	vmk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm/keys"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packernet "github.com/hashicorp/packer-plugin-sdk/net"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+)
+
+// BuilderID is the ID of the artifacts of the post-processor.
+const BuilderID = "opennebula.import"
+
+// imageFormats maps the extensions of the files to import to their format.
+var imageFormats = map[string]string{
+	".qcow2": "qcow2",
+	".raw":   "raw",
+	".img":   "raw",
+	".vmdk":  "vmdk",
+}
+
+type Config struct {
+	common.PackerConfig         `mapstructure:",squash"`
+	onecommon.OpenNebulaConnect `mapstructure:",squash"`
+	// Ownership and permissions of the imported images and of the template.
+	onecommon.OutputConfig `mapstructure:",squash"`
+	// Name of the image. Defaults to `packer-<build name>`; a suffix is
+	// appended when the artifact has several files.
+	ImageName string `mapstructure:"image_name" required:"false"`
+	// Type of the image. Defaults to `OS`.
+	ImageType string `mapstructure:"image_type" required:"false"`
+	// ID of the datastore to create the image in.
+	DatastoreID int `mapstructure:"datastore_id" required:"true"`
+	// Format of the image, `qcow2`, `raw` or `vmdk`. Defaults to the one of
+	// the file extension.
+	Format string `mapstructure:"format" required:"false"`
+	// Address OpenNebula downloads the files from. Defaults to the first
+	// IPv4 address of the host.
+	HTTPAddress string `mapstructure:"http_address" required:"false"`
+	// Minimum port to serve the files on. Defaults to `8000`.
+	HTTPPortMin int `mapstructure:"http_port_min" required:"false"`
+	// Maximum port to serve the files on. Defaults to `9000`.
+	HTTPPortMax int `mapstructure:"http_port_max" required:"false"`
+	// Time to wait for the images to be READY. Defaults to `30m`.
+	Timeout time.Duration `mapstructure:"timeout" required:"false"`
+	// Create a VM template with this name using the images.
+	TemplateName string `mapstructure:"template_name" required:"false"`
+	// CPU of the template. Defaults to `1`.
+	TemplateCPU float64 `mapstructure:"template_cpu" required:"false"`
+	// Virtual CPUs of the template. Defaults to `1`.
+	TemplateVCPU int `mapstructure:"template_vcpu" required:"false"`
+	// Memory of the template in MB. Defaults to `1024`.
+	TemplateMemory int `mapstructure:"template_memory" required:"false"`
+	// Names of the networks of the template NICs.
+	TemplateNetworks []string `mapstructure:"template_networks" required:"false"`
+	// Boot order of the template, e.g. `disk0`. Defaults to the
+	// `post_install_boot` of the OpenNebula build of the artifact, if any.
+	TemplateBoot string `mapstructure:"template_boot" required:"false"`
+
+	ctx interpolate.Context
+}
+
+type PostProcessor struct {
+	config Config
+}
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	err := config.Decode(&p.config, &config.DecodeOpts{
+		PluginType:         BuilderID,
+		Interpolate:        true,
+		InterpolateContext: &p.config.ctx,
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, p.config.OpenNebulaConnect.Prepare()...)
+	errs = packersdk.MultiErrorAppend(errs, p.config.OutputConfig.Prepare()...)
+
+	if p.config.ImageName == "" {
+		p.config.ImageName = fmt.Sprintf("packer-%s", p.config.PackerBuildName)
+	}
+	if p.config.ImageType == "" {
+		p.config.ImageType = string(image.OS)
+	}
+	if p.config.DatastoreID == 0 {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("datastore_id must be specified"))
+	}
+	switch p.config.Format {
+	case "", "qcow2", "raw", "vmdk":
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid format %q, expected \"qcow2\", \"raw\" or \"vmdk\"", p.config.Format))
+	}
+	if p.config.HTTPPortMin == 0 {
+		p.config.HTTPPortMin = 8000
+	}
+	if p.config.HTTPPortMax == 0 {
+		p.config.HTTPPortMax = 9000
+	}
+	if p.config.HTTPPortMin > p.config.HTTPPortMax {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("http_port_min must be less than http_port_max"))
+	}
+	if p.config.Timeout == 0 {
+		p.config.Timeout = 30 * time.Minute
+	}
+	if p.config.TemplateCPU == 0 {
+		p.config.TemplateCPU = 1
+	}
+	if p.config.TemplateVCPU == 0 {
+		p.config.TemplateVCPU = 1
+	}
+	if p.config.TemplateMemory == 0 {
+		p.config.TemplateMemory = 1024
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, source packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	var files []string
+	for _, path := range source.Files() {
+		if _, ok := imageFormats[strings.ToLower(filepath.Ext(path))]; ok {
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		return nil, false, false, fmt.Errorf("Artifact of %s has no .qcow2, .raw, .img or .vmdk file to import", source.BuilderId())
+	}
+
+	client, controller, err := onecommon.NewOpenNebulaConnect(p.config.OpenNebulaURL, p.config.Username, p.config.Password, p.config.Insecure)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error connecting to OpenNebula: %s", err)
+	}
+
+	address := p.config.HTTPAddress
+	if address == "" {
+		if address, err = onecommon.HostIP(""); err != nil {
+			return nil, false, false, fmt.Errorf("Failed to determine host IP: %s", err)
+		}
+	}
+
+	listener, err := packernet.ListenRangeConfig{
+		Addr: "0.0.0.0",
+		Min:  p.config.HTTPPortMin,
+		Max:  p.config.HTTPPortMax,
+	}.Listen(ctx)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error starting the HTTP server: %s", err)
+	}
+	mux := http.NewServeMux()
+	for i, path := range files {
+		path := path
+		mux.HandleFunc(fmt.Sprintf("/%d/%s", i, filepath.Base(path)), func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, path)
+		})
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+	ui.Say(fmt.Sprintf("Serving %d file(s) on %s", len(files), net.JoinHostPort(address, strconv.Itoa(listener.Port))))
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", ui)
+	state.Put("OpenNebulaController", controller)
+
+	var imageIDs []int
+	for i, path := range files {
+		name := p.config.ImageName
+		if len(files) > 1 {
+			name = fmt.Sprintf("%s-%d", name, i)
+		}
+		url := fmt.Sprintf("http://%s/%d/%s", net.JoinHostPort(address, strconv.Itoa(listener.Port)), i, filepath.Base(path))
+
+		ui.Say(fmt.Sprintf("Importing %s as image %s...", path, name))
+		imageID, err := p.importImage(controller, name, url, path, state)
+		if err != nil {
+			deleteImported(controller, ui, imageIDs, nil)
+			return nil, false, false, err
+		}
+		imageIDs = append(imageIDs, imageID)
+	}
+
+	artifact := onecommon.NewArtifact(BuilderID, imageIDs, client, controller)
+	artifact.FrontendURL = p.config.OpenNebulaURL
+
+	var templateIDs []int
+	if p.config.TemplateName != "" {
+		boot := p.config.TemplateBoot
+		if boot == "" {
+			boot, _ = source.State("PostInstallBoot").(string)
+		}
+		templateID, err := controller.Templates().Create(p.templateString(imageIDs, boot))
+		if err != nil {
+			deleteImported(controller, ui, imageIDs, nil)
+			return nil, false, false, fmt.Errorf("Error creating template %s: %s", p.config.TemplateName, err)
+		}
+		ui.Say(fmt.Sprintf("Template %s created with ID: %d", p.config.TemplateName, templateID))
+		artifact.StateData["TemplateID"] = templateID
+		templateIDs = append(templateIDs, templateID)
+	}
+
+	if err := p.config.OutputConfig.Apply(controller, ui, imageIDs, templateIDs); err != nil {
+		deleteImported(controller, ui, imageIDs, templateIDs)
+		return nil, false, false, err
+	}
+
+	return artifact, true, false, nil
+}
+
+func (p *PostProcessor) importImage(controller *goca.Controller, name, url, path string, state multistep.StateBag) (int, error) {
+	format := p.config.Format
+	if format == "" {
+		format = imageFormats[strings.ToLower(filepath.Ext(path))]
+	}
+
+	tpl := image.NewTemplate()
+	tpl.Add("NAME", name)
+	tpl.Add("TYPE", p.config.ImageType)
+	tpl.Add("PATH", url)
+	tpl.Add("DRIVER", format)
+
+	imageID, err := controller.Images().Create(tpl.String(), uint(p.config.DatastoreID))
+	if err != nil {
+		return 0, fmt.Errorf("Error creating image %s: %s", name, err)
+	}
+
+	err = onecommon.WaitForResourceState(imageID, "READY", "image", state, p.config.Timeout)
+	if err != nil {
+		if err := controller.Image(imageID).Delete(); err != nil {
+			state.Get("ui").(packersdk.Ui).Error(fmt.Sprintf("Failed to delete image %d: %s", imageID, err))
+		}
+		return 0, fmt.Errorf("Error waiting for image %s to become READY: %s", name, err)
+	}
+	return imageID, nil
+}
+
+// deleteImported removes the images and templates of a failed import, so
+// that it leaves nothing behind.
+func deleteImported(controller *goca.Controller, ui packersdk.Ui, imageIDs, templateIDs []int) {
+	for _, templateID := range templateIDs {
+		ui.Say(fmt.Sprintf("Deleting template ID: %d", templateID))
+		if err := controller.Template(templateID).Delete(); err != nil {
+			ui.Error(fmt.Sprintf("Failed to delete template %d: %s", templateID, err))
+		}
+	}
+	for _, imageID := range imageIDs {
+		ui.Say(fmt.Sprintf("Deleting image ID: %d", imageID))
+		if err := controller.Image(imageID).Delete(); err != nil {
+			ui.Error(fmt.Sprintf("Failed to delete image %d: %s", imageID, err))
+		}
+	}
+}
+
+// templateString returns the VM template using imageIDs as disks and
+// booting in the boot order, if any.
+func (p *PostProcessor) templateString(imageIDs []int, boot string) string {
+	tpl := vm.NewTemplate()
+	tpl.Add(vmk.Name, p.config.TemplateName)
+	tpl.CPU(p.config.TemplateCPU)
+	tpl.VCPU(p.config.TemplateVCPU)
+	tpl.Memory(p.config.TemplateMemory)
+	for _, imageID := range imageIDs {
+		tpl.AddDisk().Add(shared.ImageID, imageID)
+	}
+	for _, network := range p.config.TemplateNetworks {
+		tpl.AddNIC().Add(shared.Network, network)
+	}
+	if boot != "" {
+		tpl.AddOS(vmk.Boot, boot)
+	}
+	tpl.AddIOGraphic(vmk.GraphicType, "VNC")
+	tpl.AddIOGraphic(vmk.Listen, "0.0.0.0")
+	tpl.AddCtx(vmk.NetworkCtx, "YES")
+	tpl.AddCtx(vmk.SSHPubKey, "$USER[SSH_PUBLIC_KEY]")
+	return tpl.String()
+}
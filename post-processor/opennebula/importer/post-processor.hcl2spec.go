@@ -0,0 +1,87 @@
+// Code generated by "packer-sdc mapstructure-to-hcl2"; DO NOT EDIT.
+
+package importer
+
+import (
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatConfig struct {
+	PackerBuildName     *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType   *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion   *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug         *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce         *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError       *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars      map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	OpenNebulaURL       *string           `mapstructure:"opennebula_url" cty:"opennebula_url" hcl:"opennebula_url"`
+	Username            *string           `mapstructure:"username" cty:"username" hcl:"username"`
+	Password            *string           `mapstructure:"password" cty:"password" hcl:"password"`
+	Insecure            *bool             `mapstructure:"insecure" cty:"insecure" hcl:"insecure"`
+	OutputOwner         *string           `mapstructure:"output_owner" cty:"output_owner" hcl:"output_owner"`
+	OutputGroup         *string           `mapstructure:"output_group" cty:"output_group" hcl:"output_group"`
+	OutputPermissions   *string           `mapstructure:"output_permissions" cty:"output_permissions" hcl:"output_permissions"`
+	ImageName           *string           `mapstructure:"image_name" required:"false" cty:"image_name" hcl:"image_name"`
+	ImageType           *string           `mapstructure:"image_type" required:"false" cty:"image_type" hcl:"image_type"`
+	DatastoreID         *int              `mapstructure:"datastore_id" required:"true" cty:"datastore_id" hcl:"datastore_id"`
+	Format              *string           `mapstructure:"format" required:"false" cty:"format" hcl:"format"`
+	HTTPAddress         *string           `mapstructure:"http_address" required:"false" cty:"http_address" hcl:"http_address"`
+	HTTPPortMin         *int              `mapstructure:"http_port_min" required:"false" cty:"http_port_min" hcl:"http_port_min"`
+	HTTPPortMax         *int              `mapstructure:"http_port_max" required:"false" cty:"http_port_max" hcl:"http_port_max"`
+	Timeout             *string           `mapstructure:"timeout" required:"false" cty:"timeout" hcl:"timeout"`
+	TemplateName        *string           `mapstructure:"template_name" required:"false" cty:"template_name" hcl:"template_name"`
+	TemplateCPU         *float64          `mapstructure:"template_cpu" required:"false" cty:"template_cpu" hcl:"template_cpu"`
+	TemplateVCPU        *int              `mapstructure:"template_vcpu" required:"false" cty:"template_vcpu" hcl:"template_vcpu"`
+	TemplateMemory      *int              `mapstructure:"template_memory" required:"false" cty:"template_memory" hcl:"template_memory"`
+	TemplateNetworks    []string          `mapstructure:"template_networks" required:"false" cty:"template_networks" hcl:"template_networks"`
+	TemplateBoot        *string           `mapstructure:"template_boot" required:"false" cty:"template_boot" hcl:"template_boot"`
+}
+
+// FlatMapstructure returns a new FlatConfig.
+// FlatConfig is an auto-generated flat version of Config.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatConfig)
+}
+
+// HCL2Spec returns the hcl spec of a Config.
+// This spec is used by HCL to read the fields of Config.
+// The decoded values from this spec will then be applied to a FlatConfig.
+func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"packer_build_name":          &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
+		"packer_builder_type":        &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
+		"packer_core_version":        &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
+		"packer_debug":               &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
+		"packer_force":               &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
+		"packer_on_error":            &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
+		"packer_user_variables":      &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
+		"packer_sensitive_variables": &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
+		"opennebula_url":             &hcldec.AttrSpec{Name: "opennebula_url", Type: cty.String, Required: false},
+		"username":                   &hcldec.AttrSpec{Name: "username", Type: cty.String, Required: false},
+		"password":                   &hcldec.AttrSpec{Name: "password", Type: cty.String, Required: false},
+		"insecure":                   &hcldec.AttrSpec{Name: "insecure", Type: cty.Bool, Required: false},
+		"output_owner":               &hcldec.AttrSpec{Name: "output_owner", Type: cty.String, Required: false},
+		"output_group":               &hcldec.AttrSpec{Name: "output_group", Type: cty.String, Required: false},
+		"output_permissions":         &hcldec.AttrSpec{Name: "output_permissions", Type: cty.String, Required: false},
+		"image_name":                 &hcldec.AttrSpec{Name: "image_name", Type: cty.String, Required: false},
+		"image_type":                 &hcldec.AttrSpec{Name: "image_type", Type: cty.String, Required: false},
+		"datastore_id":               &hcldec.AttrSpec{Name: "datastore_id", Type: cty.Number, Required: false},
+		"format":                     &hcldec.AttrSpec{Name: "format", Type: cty.String, Required: false},
+		"http_address":               &hcldec.AttrSpec{Name: "http_address", Type: cty.String, Required: false},
+		"http_port_min":              &hcldec.AttrSpec{Name: "http_port_min", Type: cty.Number, Required: false},
+		"http_port_max":              &hcldec.AttrSpec{Name: "http_port_max", Type: cty.Number, Required: false},
+		"timeout":                    &hcldec.AttrSpec{Name: "timeout", Type: cty.String, Required: false},
+		"template_name":              &hcldec.AttrSpec{Name: "template_name", Type: cty.String, Required: false},
+		"template_cpu":               &hcldec.AttrSpec{Name: "template_cpu", Type: cty.Number, Required: false},
+		"template_vcpu":              &hcldec.AttrSpec{Name: "template_vcpu", Type: cty.Number, Required: false},
+		"template_memory":            &hcldec.AttrSpec{Name: "template_memory", Type: cty.Number, Required: false},
+		"template_networks":          &hcldec.AttrSpec{Name: "template_networks", Type: cty.List(cty.String), Required: false},
+		"template_boot":              &hcldec.AttrSpec{Name: "template_boot", Type: cty.String, Required: false},
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// testPostProcessor returns a post-processor importing into the fake
+// frontend, configured with raw.
+func testPostProcessor(t *testing.T, srv *fakeone.Server, raw map[string]interface{}) *PostProcessor {
+	t.Helper()
+	config := map[string]interface{}{
+		"opennebula_url": srv.URL,
+		"username":       "oneadmin",
+		"password":       "opennebula",
+		"datastore_id":   1,
+		"http_address":   "127.0.0.1",
+		"image_name":     "ubuntu",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	p := &PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("Configure: %s", err)
+	}
+	return p
+}
+
+// testArtifact returns an artifact with a disk file of each name.
+func testArtifact(t *testing.T, names ...string) packersdk.Artifact {
+	t.Helper()
+	dir := t.TempDir()
+	var files []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("disk"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	return &packersdk.MockArtifact{BuilderIdValue: "packer.qemu", FilesValue: files}
+}
+
+func TestPostProcessor_outputPermissions(t *testing.T) {
+	srv := fakeone.New(t)
+	p := testPostProcessor(t, srv, map[string]interface{}{
+		"template_name":      "ubuntu",
+		"output_owner":       "packer",
+		"output_group":       "users",
+		"output_permissions": "640",
+	})
+
+	artifact, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), testArtifact(t, "disk.qcow2", "data.raw"))
+	if err != nil {
+		t.Fatalf("PostProcess: %s", err)
+	}
+
+	perms := [9]int{1, 1, 0, 1, 0, 0, 0, 0, 0}
+	images := srv.Images()
+	if len(images) != 2 {
+		t.Fatalf("%d images imported, expected 2", len(images))
+	}
+	for _, img := range images {
+		if img.UID != 2 || img.GID != 1 || img.Permissions != perms {
+			t.Errorf("image %s owned by %d:%d with %v, expected 2:1 with %v", img.Name, img.UID, img.GID, img.Permissions, perms)
+		}
+	}
+	templateID := artifact.State("TemplateID").(int)
+	tpl, _ := srv.Template(templateID)
+	if tpl.UID != 2 || tpl.GID != 1 || tpl.Permissions != perms {
+		t.Errorf("template owned by %d:%d with %v, expected 2:1 with %v", tpl.UID, tpl.GID, tpl.Permissions, perms)
+	}
+}
+
+func TestPostProcessor_invalidPermissions(t *testing.T) {
+	srv := fakeone.New(t)
+	p := &PostProcessor{}
+	err := p.Configure(map[string]interface{}{
+		"opennebula_url":     srv.URL,
+		"username":           "oneadmin",
+		"password":           "opennebula",
+		"datastore_id":       1,
+		"output_permissions": "rw-",
+	})
+	if err == nil {
+		t.Fatal("Configure accepted invalid output_permissions")
+	}
+}
+
+func TestPostProcessor_deleteImportedOnFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    map[string]interface{}
+		method string
+	}{
+		{"image", nil, ""},
+		{"template", map[string]interface{}{"template_name": "ubuntu"}, "one.template.allocate"},
+		{"output permissions", map[string]interface{}{"template_name": "ubuntu", "output_permissions": "640"}, "one.template.chmod"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakeone.New(t)
+			p := testPostProcessor(t, srv, tt.raw)
+			existing := 0
+			if tt.method == "" {
+				// The name of the second image is taken, the first one is
+				// imported
+				srv.AddImage("ubuntu-1", "OS", 1)
+				existing = 1
+			} else {
+				srv.FailNext(tt.method, "permission denied")
+			}
+
+			_, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), testArtifact(t, "disk.qcow2", "data.raw"))
+			if err == nil {
+				t.Fatal("PostProcess succeeded, expected it to fail")
+			}
+			if images := srv.Images(); len(images) != existing {
+				t.Errorf("%d images left, expected the %d existing ones", len(images), existing)
+			}
+			if templates := srv.Templates(); len(templates) != 0 {
+				t.Errorf("%d templates left behind", len(templates))
+			}
+		})
+	}
+}
+
+func TestPostProcessor_templateBoot(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		state    interface{}
+		expected string
+	}{
+		{"none", nil, nil, ""},
+		{"from the build", nil, "disk0", "disk0"},
+		{"configured", map[string]interface{}{"template_boot": "disk1,disk0"}, "disk0", "disk1,disk0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakeone.New(t)
+			raw := map[string]interface{}{"template_name": "ubuntu"}
+			for k, v := range tt.raw {
+				raw[k] = v
+			}
+			p := testPostProcessor(t, srv, raw)
+			source := testArtifact(t, "disk.qcow2").(*packersdk.MockArtifact)
+			if tt.state != nil {
+				source.StateValues = map[string]interface{}{"PostInstallBoot": tt.state}
+			}
+
+			artifact, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), source)
+			if err != nil {
+				t.Fatalf("PostProcess: %s", err)
+			}
+			tpl, _ := srv.Template(artifact.State("TemplateID").(int))
+			if boot, _ := tpl.Template.GetStrFromVec("OS", "BOOT"); boot != tt.expected {
+				t.Errorf("OS/BOOT = %q, expected %q", boot, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+package template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/OpenNebula/one/src/oca/go/src/goca"
+	dyn "github.com/OpenNebula/one/src/oca/go/src/goca/dynamic"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/parameters"
+	goimage "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/image"
+	"github.com/OpenNebula/one/src/oca/go/src/goca/schemas/shared"
+	vmk "github.com/OpenNebula/one/src/oca/go/src/goca/schemas/vm/keys"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+
+	onecommon "github.com/shurkys/packer-plugin-opennebula/builder/opennebula/common"
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/image"
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/iso"
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/kernel"
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/vm"
+	"github.com/shurkys/packer-plugin-opennebula/post-processor/opennebula/importer"
+)
+
+// BuilderID is the ID of the artifacts of the post-processor.
+const BuilderID = "opennebula.template"
+
+// previousImagesAttr is the template attribute the image IDs replaced by the
+// last update are kept in, for rollbacks.
+const previousImagesAttr = "PACKER_PREVIOUS_IMAGE_IDS"
+
+// sourceBuilderIDs are the artifacts the post-processor accepts.
+var sourceBuilderIDs = []string{iso.BuilderID, image.BuilderID, vm.BuilderID, kernel.BuilderID, importer.BuilderID}
+
+type Config struct {
+	common.PackerConfig         `mapstructure:",squash"`
+	onecommon.OpenNebulaConnect `mapstructure:",squash"`
+	// Ownership and permissions of the backup template. The updated template
+	// keeps its own.
+	onecommon.OutputConfig `mapstructure:",squash"`
+	// Name or ID of the VM template whose disks are pointed at the images of
+	// the artifact, in order. Volatile disks, which have no image, are left
+	// alone. The boot order of the template is set to the `post_install_boot`
+	// of the build, if any.
+	Template string `mapstructure:"template" required:"true"`
+	// Clone the template under this name before updating it, replacing an
+	// existing template of that name. The replaced image IDs are also kept
+	// in the `PACKER_PREVIOUS_IMAGE_IDS` attribute of the template.
+	BackupTemplateName string `mapstructure:"backup_template_name" required:"false"`
+	// Point the template back at the images recorded in
+	// `PACKER_PREVIOUS_IMAGE_IDS` instead of the images of the artifact.
+	Rollback bool `mapstructure:"rollback" required:"false"`
+
+	ctx interpolate.Context
+}
+
+type PostProcessor struct {
+	config Config
+}
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	err := config.Decode(&p.config, &config.DecodeOpts{
+		PluginType:         BuilderID,
+		Interpolate:        true,
+		InterpolateContext: &p.config.ctx,
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	var errs *packersdk.MultiError
+	errs = packersdk.MultiErrorAppend(errs, p.config.OpenNebulaConnect.Prepare()...)
+	errs = packersdk.MultiErrorAppend(errs, p.config.OutputConfig.Prepare()...)
+	if p.config.Template == "" {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("template must be specified"))
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, source packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	client, controller, err := onecommon.NewOpenNebulaConnect(p.config.OpenNebulaURL, p.config.Username, p.config.Password, p.config.Insecure)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error connecting to OpenNebula: %s", err)
+	}
+
+	templateID, err := findTemplate(controller, p.config.Template)
+	if err != nil {
+		return nil, false, false, err
+	}
+	tpl, err := controller.Template(templateID).Info(false, false)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error getting template %d: %s", templateID, err)
+	}
+
+	// The images are matched with the disks backed by an image, in order.
+	// Disks referencing their image by name are resolved so that the
+	// previous images stay aligned with the disks they were on.
+	var disks []*dyn.Vector
+	var currentIDs []int
+	var images *goimage.Pool
+	for i, disk := range tpl.Template.GetVectors("DISK") {
+		id, err := diskImageID(controller, disk, &images)
+		if err != nil {
+			return nil, false, false, fmt.Errorf("Error getting the image of disk %d of template %d: %s", i, templateID, err)
+		}
+		if id < 0 {
+			// A volatile disk
+			continue
+		}
+		disks = append(disks, disk)
+		currentIDs = append(currentIDs, id)
+	}
+
+	var imageIDs []int
+	if p.config.Rollback {
+		previous, _ := tpl.Template.GetStr(previousImagesAttr)
+		if imageIDs, err = parseImageIDs(previous); err != nil || len(imageIDs) == 0 {
+			return nil, false, false, fmt.Errorf("Template %d has no previous images to roll back to", templateID)
+		}
+		ui.Say(fmt.Sprintf("Rolling template %d back to images %v", templateID, imageIDs))
+	} else {
+		if !slices.Contains(sourceBuilderIDs, source.BuilderId()) {
+			return nil, false, false, fmt.Errorf("Unsupported artifact of %s, expected one of %v", source.BuilderId(), sourceBuilderIDs)
+		}
+		if ids, ok := source.State("ImageIDs").([]int); ok {
+			imageIDs = ids
+		} else if imageIDs, err = parseImageIDs(source.Id()); err != nil {
+			return nil, false, false, fmt.Errorf("Error reading the images of the artifact: %s", err)
+		}
+		if len(imageIDs) == 0 {
+			return nil, false, false, errors.New("The artifact has no images")
+		}
+		ui.Say(fmt.Sprintf("Pointing template %d at images %v", templateID, imageIDs))
+	}
+
+	if p.config.BackupTemplateName != "" {
+		backupID, err := backupTemplate(controller, templateID, p.config.BackupTemplateName)
+		if err != nil {
+			return nil, false, false, err
+		}
+		ui.Say(fmt.Sprintf("Template %d backed up as %s (ID: %d)", templateID, p.config.BackupTemplateName, backupID))
+		if err := p.config.OutputConfig.Apply(controller, ui, nil, []int{backupID}); err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	for i, imageID := range imageIDs {
+		if i < len(disks) {
+			// Drop the other ways to reference an image
+			for _, key := range []string{"IMAGE", "IMAGE_UNAME", "IMAGE_UID", string(shared.ImageID)} {
+				disks[i].Del(key)
+			}
+			disks[i].AddPair(string(shared.ImageID), imageID)
+		} else {
+			tpl.Template.AddDisk().Add(shared.ImageID, imageID)
+		}
+	}
+	if boot, _ := source.State("PostInstallBoot").(string); boot != "" && !p.config.Rollback {
+		if osVec, err := tpl.Template.GetVector(string(vmk.OSVec)); err == nil {
+			osVec.Del(string(vmk.Boot))
+		}
+		tpl.Template.AddOS(vmk.Boot, boot)
+	}
+	tpl.Template.Del(previousImagesAttr)
+	tpl.Template.AddPair(previousImagesAttr, joinImageIDs(currentIDs))
+
+	if err := controller.Template(templateID).Update(tpl.Template.String(), parameters.Replace); err != nil {
+		return nil, false, false, fmt.Errorf("Error updating template %d: %s", templateID, err)
+	}
+	ui.Say(fmt.Sprintf("Template %d updated, previous images: %v", templateID, currentIDs))
+
+	artifact := onecommon.NewArtifact(BuilderID, imageIDs, client, controller)
+	artifact.FrontendURL = p.config.OpenNebulaURL
+	artifact.StateData["TemplateID"] = templateID
+	return artifact, true, false, nil
+}
+
+// findTemplate resolves the name or ID of a template.
+func findTemplate(controller *goca.Controller, value string) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+
+	pool, err := controller.Templates().Info(parameters.PoolWhoAll)
+	if err != nil {
+		return 0, fmt.Errorf("Error listing templates: %s", err)
+	}
+	var ids []int
+	for _, tpl := range pool.Templates {
+		if tpl.Name == value {
+			ids = append(ids, tpl.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("Template %s not found", value)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("Several templates are named %s: %v", value, ids)
+}
+
+// backupTemplate clones the template under name, replacing a template of
+// the same name, and returns the ID of the clone.
+func backupTemplate(controller *goca.Controller, templateID int, name string) (int, error) {
+	if backupID, err := findTemplate(controller, name); err == nil {
+		if backupID == templateID {
+			return 0, fmt.Errorf("backup_template_name %s designates the template itself", name)
+		}
+		if err := controller.Template(backupID).Delete(); err != nil {
+			return 0, fmt.Errorf("Error deleting the previous backup template %d: %s", backupID, err)
+		}
+	}
+	if err := controller.Template(templateID).Clone(name, false); err != nil {
+		return 0, fmt.Errorf("Error backing up template %d: %s", templateID, err)
+	}
+	// goca does not return the ID of the clone
+	backupID, err := findTemplate(controller, name)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting the backup template: %s", err)
+	}
+	return backupID, nil
+}
+
+// diskImageID returns the ID of the image of the disk, or -1 for a volatile
+// disk. The image pool is only listed, into images, for disks referencing
+// their image by name.
+func diskImageID(controller *goca.Controller, disk *dyn.Vector, images **goimage.Pool) (int, error) {
+	if id, err := disk.GetInt(string(shared.ImageID)); err == nil {
+		return id, nil
+	}
+	name, err := disk.GetStr(string(shared.Image))
+	if err != nil {
+		return -1, nil
+	}
+
+	if *images == nil {
+		if *images, err = controller.Images().Info(parameters.PoolWhoAll); err != nil {
+			return 0, fmt.Errorf("Error listing images: %s", err)
+		}
+	}
+	uname, _ := disk.GetStr(string(shared.ImageUname))
+	uid, uidErr := disk.GetInt("IMAGE_UID")
+	var ids []int
+	for _, img := range (*images).Images {
+		if img.Name != name || (uname != "" && img.UName != uname) || (uidErr == nil && img.UID != uid) {
+			continue
+		}
+		ids = append(ids, img.ID)
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("image %s not found", name)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("several images are named %s: %v, set IMAGE_UNAME or IMAGE_ID", name, ids)
+}
+
+func parseImageIDs(value string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func joinImageIDs(ids []int) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return strings.Join(fields, ",")
+}
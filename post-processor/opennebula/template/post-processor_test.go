@@ -0,0 +1,165 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+
+	"github.com/shurkys/packer-plugin-opennebula/builder/opennebula/iso"
+	"github.com/shurkys/packer-plugin-opennebula/internal/fakeone"
+)
+
+// testPostProcessor returns a post-processor updating templates of the fake
+// frontend, configured with raw.
+func testPostProcessor(t *testing.T, srv *fakeone.Server, raw map[string]interface{}) *PostProcessor {
+	t.Helper()
+	config := map[string]interface{}{
+		"opennebula_url": srv.URL,
+		"username":       "oneadmin",
+		"password":       "opennebula",
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	p := &PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("Configure: %s", err)
+	}
+	return p
+}
+
+// testArtifact returns an artifact of the ISO builder with the images.
+func testArtifact(imageIDs ...int) packersdk.Artifact {
+	return &packersdk.MockArtifact{
+		BuilderIdValue: iso.BuilderID,
+		StateValues:    map[string]interface{}{"ImageIDs": imageIDs},
+	}
+}
+
+func TestPostProcessor_backupPermissions(t *testing.T) {
+	srv := fakeone.New(t)
+	old := srv.AddImage("ubuntu-old", "OS", 1)
+	saved := srv.AddImage("ubuntu-new", "OS", 1)
+	templateID := srv.AddTemplate("ubuntu", fmt.Sprintf("CPU=\"1\"\nDISK=[IMAGE_ID=\"%d\"]", old))
+
+	p := testPostProcessor(t, srv, map[string]interface{}{
+		"template":             "ubuntu",
+		"backup_template_name": "ubuntu-backup",
+		"output_owner":         "packer",
+		"output_permissions":   "644",
+	})
+	if _, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), testArtifact(saved)); err != nil {
+		t.Fatalf("PostProcess: %s", err)
+	}
+
+	templates := srv.Templates()
+	if len(templates) != 2 {
+		t.Fatalf("%d templates, expected the template and its backup", len(templates))
+	}
+	backup := templates[1]
+	if backup.Name != "ubuntu-backup" || backup.UID != 2 || backup.Permissions != [9]int{1, 1, 0, 1, 0, 0, 1, 0, 0} {
+		t.Errorf("backup %s owned by %d with %v, expected ubuntu-backup owned by 2 with 644", backup.Name, backup.UID, backup.Permissions)
+	}
+	if id, _ := backup.Template.GetVectors("DISK")[0].GetInt("IMAGE_ID"); id != old {
+		t.Errorf("backup points at image %d, expected %d", id, old)
+	}
+	// The updated template keeps its ownership
+	if tpl, _ := srv.Template(templateID); tpl.UID != 0 || tpl.Permissions != [9]int{1, 1} {
+		t.Errorf("template owned by %d with %v, expected it unchanged", tpl.UID, tpl.Permissions)
+	}
+}
+
+func TestPostProcessor_diskAlignment(t *testing.T) {
+	srv := fakeone.New(t)
+	system := srv.AddImage("ubuntu-old", "OS", 1)
+	data := srv.AddImage("data-old", "DATABLOCK", 1)
+	newSystem := srv.AddImage("ubuntu-new", "OS", 1)
+	newData := srv.AddImage("data-new", "DATABLOCK", 1)
+	// The system disk is referenced by name and followed by a volatile disk
+	templateID := srv.AddTemplate("ubuntu", fmt.Sprintf(
+		"DISK=[IMAGE=\"ubuntu-old\",IMAGE_UNAME=\"oneadmin\"]\nDISK=[TYPE=\"fs\",SIZE=\"1024\"]\nDISK=[IMAGE_ID=\"%d\"]", data))
+
+	// checkDisks fails unless the image disks point at the images and the
+	// volatile disk is left alone
+	checkDisks := func(t *testing.T, systemID, dataID int) {
+		t.Helper()
+		tpl, _ := srv.Template(templateID)
+		disks := tpl.Template.GetVectors("DISK")
+		if len(disks) != 3 {
+			t.Fatalf("%d disks, expected 3", len(disks))
+		}
+		if id, _ := disks[0].GetInt("IMAGE_ID"); id != systemID {
+			t.Errorf("disk 0 points at image %d, expected %d", id, systemID)
+		}
+		if _, err := disks[0].GetStr("IMAGE"); err == nil {
+			t.Error("disk 0 still references its image by name")
+		}
+		if size, _ := disks[1].GetStr("SIZE"); size != "1024" || len(disks[1].Pairs) != 2 {
+			t.Errorf("volatile disk changed to %s", disks[1])
+		}
+		if id, _ := disks[2].GetInt("IMAGE_ID"); id != dataID {
+			t.Errorf("disk 2 points at image %d, expected %d", id, dataID)
+		}
+	}
+
+	p := testPostProcessor(t, srv, map[string]interface{}{"template": "ubuntu"})
+	if _, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), testArtifact(newSystem, newData)); err != nil {
+		t.Fatalf("PostProcess: %s", err)
+	}
+	checkDisks(t, newSystem, newData)
+	tpl, _ := srv.Template(templateID)
+	if previous, _ := tpl.Template.GetStr("PACKER_PREVIOUS_IMAGE_IDS"); previous != fmt.Sprintf("%d,%d", system, data) {
+		t.Errorf("PACKER_PREVIOUS_IMAGE_IDS = %q, expected %d,%d", previous, system, data)
+	}
+
+	p = testPostProcessor(t, srv, map[string]interface{}{"template": "ubuntu", "rollback": true})
+	if _, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), testArtifact()); err != nil {
+		t.Fatalf("PostProcess: %s", err)
+	}
+	checkDisks(t, system, data)
+}
+
+func TestPostProcessor_ambiguousImageName(t *testing.T) {
+	srv := fakeone.New(t)
+	saved := srv.AddImage("ubuntu-new", "OS", 1)
+	srv.AddImage("ubuntu", "OS", 1)
+	srv.AddImage("ubuntu", "OS", 2)
+	templateID := srv.AddTemplate("ubuntu", "DISK=[IMAGE=\"ubuntu\"]")
+
+	p := testPostProcessor(t, srv, map[string]interface{}{"template": "ubuntu"})
+	if _, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), testArtifact(saved)); err == nil {
+		t.Fatal("PostProcess succeeded, expected the image of the disk to be ambiguous")
+	}
+	tpl, _ := srv.Template(templateID)
+	if _, err := tpl.Template.GetVectors("DISK")[0].GetInt("IMAGE_ID"); err == nil {
+		t.Errorf("template changed to %s", tpl.Template)
+	}
+}
+
+func TestPostProcessor_bootOrder(t *testing.T) {
+	srv := fakeone.New(t)
+	old := srv.AddImage("ubuntu-old", "OS", 1)
+	saved := srv.AddImage("ubuntu-new", "OS", 1)
+	templateID := srv.AddTemplate("ubuntu", fmt.Sprintf("OS=[ARCH=\"x86_64\",BOOT=\"disk1,disk0\"]\nDISK=[IMAGE_ID=\"%d\"]", old))
+
+	p := testPostProcessor(t, srv, map[string]interface{}{"template": "ubuntu"})
+	artifact := testArtifact(saved).(*packersdk.MockArtifact)
+	artifact.StateValues["PostInstallBoot"] = "disk0"
+	if _, _, _, err := p.PostProcess(context.Background(), packersdk.TestUi(t), artifact); err != nil {
+		t.Fatalf("PostProcess: %s", err)
+	}
+
+	tpl, _ := srv.Template(templateID)
+	osVec, err := tpl.Template.GetVector("OS")
+	if err != nil {
+		t.Fatalf("OS section dropped: %s", err)
+	}
+	if boots := osVec.GetStrs("BOOT"); len(boots) != 1 || boots[0] != "disk0" {
+		t.Errorf("OS/BOOT = %v, expected disk0", boots)
+	}
+	if arch, _ := osVec.GetStr("ARCH"); arch != "x86_64" {
+		t.Errorf("OS/ARCH = %q, expected it unchanged", arch)
+	}
+}